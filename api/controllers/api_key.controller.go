@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"diandi-backend/api/middlewares"
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyController struct {
+	logger  lib.Logger
+	service services.APIKeyService
+}
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is the lifetime in seconds, keys without one never expire
+	ExpiresIn int64 `json:"expiresIn" binding:"min=0"`
+}
+
+type updateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"max=100"`
+	Scopes []string `json:"scopes"`
+}
+
+func NewAPIKeyController(
+	logger lib.Logger,
+	service services.APIKeyService,
+) APIKeyController {
+	return APIKeyController{
+		logger:  logger,
+		service: service,
+	}
+}
+
+// Create issues a key for the signed in user. The key is only part of this response.
+func (kc APIKeyController) Create(c *gin.Context) {
+	var request createAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	owner := kc.owner(c)
+	created, err := kc.service.Create(
+		c.Request.Context(),
+		owner,
+		owner.ID,
+		request.Name,
+		request.Scopes,
+		time.Duration(request.ExpiresIn)*time.Second,
+	)
+	if err != nil {
+		kc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// List returns the keys of the signed in user
+func (kc APIKeyController) List(c *gin.Context) {
+	keys, err := kc.service.List(c.Request.Context(), kc.owner(c))
+	if err != nil {
+		kc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKeys": keys})
+}
+
+// Get returns one key of the signed in user
+func (kc APIKeyController) Get(c *gin.Context) {
+	key, err := kc.service.Get(c.Request.Context(), kc.owner(c), c.Param("id"))
+	if err != nil {
+		kc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// Update renames a key or replaces its scopes
+func (kc APIKeyController) Update(c *gin.Context) {
+	var request updateAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	key, err := kc.service.Update(c.Request.Context(), kc.owner(c), c.Param("id"), request.Name, request.Scopes)
+	if err != nil {
+		kc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// Delete revokes a key for good
+func (kc APIKeyController) Delete(c *gin.Context) {
+	if err := kc.service.Delete(c.Request.Context(), kc.owner(c), c.Param("id")); err != nil {
+		kc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key deleted"})
+}
+
+func (kc APIKeyController) owner(c *gin.Context) domains.APIKeyOwner {
+	return domains.APIKeyOwner{
+		Type: domains.UserAPIKeyOwner,
+		ID:   c.GetString(middlewares.UserIDKey),
+	}
+}
+
+func (kc APIKeyController) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, domains.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "api_key_not_found"})
+	case errors.Is(err, domains.ErrInvalidScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+	default:
+		kc.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+}
@@ -1,34 +1,445 @@
 package controllers
 
 import (
+	"diandi-backend/api/middlewares"
 	"diandi-backend/domains"
 	"diandi-backend/lib"
+	"diandi-backend/services"
+	"errors"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthController struct {
-	logger  lib.Logger
-	service domains.AuthService
+	logger       lib.Logger
+	service      domains.AuthService
+	sessions     services.SessionService
+	users        services.UserService
+	verification services.EmailVerificationService
+	resets       services.PasswordResetService
+	mfa          services.MFAService
+	emailLogins  services.EmailLoginService
+	verifiedURL  string
+}
+
+type registerRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8,max=128"`
+	Name     string `json:"name" binding:"max=200"`
+}
+
+type signInRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type verifyMFARequest struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type exchangeTokenRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8,max=128"`
+}
+
+type emailLoginRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type verifyEmailLoginRequest struct {
+	Token string `json:"token"`
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+type logoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 func NewAuthController(
 	logger lib.Logger,
+	env lib.Env,
 	service domains.AuthService,
+	sessions services.SessionService,
+	users services.UserService,
+	verification services.EmailVerificationService,
+	resets services.PasswordResetService,
+	mfa services.MFAService,
+	emailLogins services.EmailLoginService,
 ) AuthController {
 	return AuthController{
-		logger:  logger,
-		service: service,
+		logger:       logger,
+		service:      service,
+		sessions:     sessions,
+		users:        users,
+		verification: verification,
+		resets:       resets,
+		mfa:          mfa,
+		emailLogins:  emailLogins,
+		verifiedURL:  env.EmailVerificationRedirectURL,
+	}
+}
+
+// Register creates a password account. The response is the same whether or not
+// the email already has an account, the client signs in afterwards.
+func (ac AuthController) Register(c *gin.Context) {
+	var request registerRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	if err := ac.users.Register(c.Request.Context(), request.Email, request.Password, request.Name); err != nil {
+		ac.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
 	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Registration received"})
 }
 
+// SignIn exchanges email and password credentials for a token pair
 func (ac AuthController) SignIn(c *gin.Context) {
-	ac.logger.Info(c.ClientIP())
-	c.JSON(
-		http.StatusAccepted,
-		gin.H{
-			"accessToken": "aaaa",
-		},
-	)
+	var request signInRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	user, err := ac.users.Authenticate(c.Request.Context(), request.Email, request.Password)
+	if err != nil {
+		if errors.Is(err, domains.ErrInvalidCredentials) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_credentials"})
+			return
+		}
+		ac.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	ac.completeSignIn(c, user, domains.PasswordMethod)
+}
+
+// RequestEmailLogin mails a magic link and a one-time code. It always answers 202
+// so the response does not reveal whether the email has an account.
+func (ac AuthController) RequestEmailLogin(c *gin.Context) {
+	var request emailLoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	ac.emailLogins.RequestLogin(request.Email)
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "A sign in link and code are on their way"})
+}
+
+// VerifyEmailLogin signs in with the token of a magic link or with the emailed
+// code, creating the account on first sign in
+func (ac AuthController) VerifyEmailLogin(c *gin.Context) {
+	var request verifyEmailLoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	var user *domains.User
+	var err error
+	switch {
+	case request.Token != "":
+		user, err = ac.emailLogins.VerifyToken(c.Request.Context(), request.Token)
+	case request.Email != "" && request.Code != "":
+		user, err = ac.emailLogins.VerifyCode(c.Request.Context(), request.Email, request.Code)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, domains.ErrInvalidEmailLogin) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_grant"})
+			return
+		}
+		ac.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	ac.completeSignIn(c, user, domains.OTPMethod)
+}
+
+// ExchangeToken redeems the login code handed to the frontend by the OAuth callback
+func (ac AuthController) ExchangeToken(c *gin.Context) {
+	var request exchangeTokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	userID, err := ac.sessions.RedeemLoginCode(c.Request.Context(), request.Code)
+	if err != nil {
+		if errors.Is(err, domains.ErrInvalidLoginCode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+			return
+		}
+		ac.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	user, err := ac.users.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		ac.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	ac.completeSignIn(c, user, domains.FederatedMethod)
+}
+
+// VerifyMFA finishes a sign in challenged for a second factor with a TOTP or
+// recovery code
+func (ac AuthController) VerifyMFA(c *gin.Context) {
+	var request verifyMFARequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	user, err := ac.mfa.VerifyChallenge(c.Request.Context(), request.MFAToken, request.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, domains.ErrInvalidActionToken):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_grant"})
+		case errors.Is(err, domains.ErrInvalidMFACode):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_mfa_code"})
+		case errors.Is(err, domains.ErrMFANotEnabled):
+			// Users with passkeys as their only second factor finish with WebAuthn
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_mfa_method"})
+		case errors.Is(err, domains.ErrMFALocked):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "mfa_locked"})
+		default:
+			ac.logger.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		}
+		return
+	}
+
+	ac.issueTokens(c, user.ID, domains.MultiFactorMethod, domains.OTPMethod)
+}
+
+// completeSignIn issues tokens once the primary factor method succeeded, or
+// answers with an MFA challenge when the user enabled a second factor
+func (ac AuthController) completeSignIn(c *gin.Context, user *domains.User, method string) {
+	if user.SuspendedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account_suspended"})
+		return
+	}
+
+	challenge, err := ac.mfa.Challenge(user)
+	if err != nil {
+		ac.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	ac.issueTokens(c, user.ID, method)
+}
+
+func (ac AuthController) issueTokens(c *gin.Context, userID string, methods ...string) {
+	issueTokens(c, ac.logger, ac.sessions, userID, methods...)
+}
+
+// issueTokens answers with a new token pair for a user who completed sign in
+// with methods
+func issueTokens(c *gin.Context, logger lib.Logger, sessions services.SessionService, userID string, methods ...string) {
+	auth := domains.Authentication{Time: time.Now(), Methods: methods}
+	tokens, err := sessions.IssueTokens(c.Request.Context(), userID, auth)
+	if err != nil {
+		if errors.Is(err, domains.ErrUserSuspended) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "account_suspended"})
+			return
+		}
+		logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Refresh rotates a refresh token into a new access and refresh token pair
+func (ac AuthController) Refresh(c *gin.Context) {
+	var request refreshTokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	tokens, err := ac.sessions.Refresh(c.Request.Context(), request.RefreshToken)
+	if err != nil {
+		if errors.Is(err, domains.ErrInvalidRefreshToken) || errors.Is(err, domains.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_grant"})
+			return
+		}
+		if errors.Is(err, domains.ErrUserSuspended) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "account_suspended"})
+			return
+		}
+		ac.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the presented access token and the session's refresh tokens
+func (ac AuthController) Logout(c *gin.Context) {
+	var request logoutRequest
+	// The body is optional, without a refresh token only the access token is revoked
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+			return
+		}
+	}
+
+	claims := c.MustGet(middlewares.ClaimsKey).(*domains.AccessClaims)
+	if err := ac.sessions.Logout(c.Request.Context(), claims, request.RefreshToken); err != nil {
+		ac.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
+}
+
+// SendVerificationEmail mails a new verification link to the signed in user
+func (ac AuthController) SendVerificationEmail(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, err := ac.users.GetUser(ctx, c.GetString(middlewares.UserIDKey))
+	if err != nil {
+		ac.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	if err := ac.verification.SendVerification(ctx, user); err != nil {
+		switch {
+		case errors.Is(err, domains.ErrEmailAlreadyVerified):
+			c.JSON(http.StatusConflict, gin.H{"error": "already_verified"})
+		case errors.Is(err, domains.ErrEmailMissing):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email_missing"})
+		default:
+			ac.logger.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// ConfirmEmail verifies the address named by the link mailed to the user. The
+// browser is sent to EMAIL_VERIFICATION_REDIRECT_URL when configured.
+func (ac AuthController) ConfirmEmail(c *gin.Context) {
+	_, err := ac.verification.ConfirmEmail(c.Request.Context(), c.Query("token"))
+	if err != nil && !errors.Is(err, domains.ErrInvalidActionToken) {
+		ac.logger.Error(err)
+		ac.verificationResult(c, http.StatusInternalServerError, url.Values{"error": {"server_error"}})
+		return
+	}
+	if err != nil {
+		ac.verificationResult(c, http.StatusBadRequest, url.Values{"error": {"invalid_token"}})
+		return
+	}
+
+	ac.verificationResult(c, http.StatusOK, url.Values{"status": {"verified"}})
+}
+
+// verificationResult redirects to the configured frontend page with params, or
+// reports them as JSON when there is none
+func (ac AuthController) verificationResult(c *gin.Context, status int, params url.Values) {
+	target, err := url.Parse(ac.verifiedURL)
+	if ac.verifiedURL == "" || err != nil {
+		body := gin.H{}
+		for key := range params {
+			body[key] = params.Get(key)
+		}
+		c.JSON(status, body)
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	target.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusSeeOther, target.String())
+}
+
+// ForgotPassword mails a reset link. It always answers 202 so the response does
+// not reveal whether the email has an account.
+func (ac AuthController) ForgotPassword(c *gin.Context) {
+	var request forgotPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	ac.resets.RequestReset(request.Email)
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email has an account, a reset link is on its way"})
+}
+
+// ResetPassword sets a new password with a mailed reset token
+func (ac AuthController) ResetPassword(c *gin.Context) {
+	var request resetPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	if err := ac.resets.ResetPassword(c.Request.Context(), request.Token, request.Password); err != nil {
+		switch {
+		case errors.Is(err, domains.ErrInvalidResetToken):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_token"})
+		case errors.Is(err, domains.ErrUserSuspended):
+			c.JSON(http.StatusForbidden, gin.H{"error": "account_suspended"})
+		default:
+			ac.logger.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// JWKS publishes the public keys access tokens are verified with
+func (ac AuthController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ac.service.JWKS())
 }
@@ -4,4 +4,10 @@ import "go.uber.org/fx"
 
 var Module = fx.Options(
 	fx.Provide(NewAuthController),
+	fx.Provide(NewMFAController),
+	fx.Provide(NewWebAuthnController),
+	fx.Provide(NewRoleController),
+	fx.Provide(NewAPIKeyController),
+	fx.Provide(NewOAuth2Controller),
+	fx.Provide(NewOAuth2ClientController),
 )
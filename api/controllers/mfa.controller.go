@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"diandi-backend/api/middlewares"
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAController struct {
+	logger  lib.Logger
+	service services.MFAService
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func NewMFAController(
+	logger lib.Logger,
+	service services.MFAService,
+) MFAController {
+	return MFAController{
+		logger:  logger,
+		service: service,
+	}
+}
+
+// Enroll starts TOTP enrollment and returns the secret and otpauth URI to
+// register with an authenticator app
+func (mc MFAController) Enroll(c *gin.Context) {
+	enrollment, err := mc.service.BeginEnrollment(c.Request.Context(), c.GetString(middlewares.UserIDKey))
+	if err != nil {
+		mc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmEnrollment enables MFA with a first code from the authenticator app
+func (mc MFAController) ConfirmEnrollment(c *gin.Context) {
+	var request mfaCodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	codes, err := mc.service.ConfirmEnrollment(c.Request.Context(), c.GetString(middlewares.UserIDKey), request.Code)
+	if err != nil {
+		mc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, invalidating the old ones
+func (mc MFAController) RegenerateRecoveryCodes(c *gin.Context) {
+	var request mfaCodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	codes, err := mc.service.RegenerateRecoveryCodes(c.Request.Context(), c.GetString(middlewares.UserIDKey), request.Code)
+	if err != nil {
+		mc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// Disable turns MFA off for the signed in user
+func (mc MFAController) Disable(c *gin.Context) {
+	var request mfaCodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	if err := mc.service.Disable(c.Request.Context(), c.GetString(middlewares.UserIDKey), request.Code); err != nil {
+		mc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Multi-factor authentication disabled"})
+}
+
+// ResetUser turns MFA off for another user, e.g. one who lost their device
+func (mc MFAController) ResetUser(c *gin.Context) {
+	if err := mc.service.Reset(c.Request.Context(), c.Param("id")); err != nil {
+		mc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Multi-factor authentication reset"})
+}
+
+func (mc MFAController) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, domains.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user_not_found"})
+	case errors.Is(err, domains.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "mfa_already_enabled"})
+	case errors.Is(err, domains.ErrMFANotEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "mfa_not_enabled"})
+	case errors.Is(err, domains.ErrMFAEnrollmentMissing):
+		c.JSON(http.StatusConflict, gin.H{"error": "mfa_enrollment_missing"})
+	case errors.Is(err, domains.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_mfa_code"})
+	case errors.Is(err, domains.ErrMFALocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "mfa_locked"})
+	default:
+		mc.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+}
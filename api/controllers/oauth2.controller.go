@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"diandi-backend/api/middlewares"
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OAuth2Controller serves the endpoints of our own authorization server and its
+// OpenID Connect layer, the consent API used by the frontend included
+type OAuth2Controller struct {
+	logger     lib.Logger
+	service    services.OAuth2Service
+	clients    services.OAuth2ClientService
+	oidc       services.OIDCService
+	consentURL string
+}
+
+type decideAuthorizationRequest struct {
+	Approve bool `json:"approve"`
+}
+
+func NewOAuth2Controller(
+	logger lib.Logger,
+	env lib.Env,
+	service services.OAuth2Service,
+	clients services.OAuth2ClientService,
+	oidc services.OIDCService,
+) OAuth2Controller {
+	return OAuth2Controller{
+		logger:     logger,
+		service:    service,
+		clients:    clients,
+		oidc:       oidc,
+		consentURL: env.OAuth2ConsentURL,
+	}
+}
+
+// Authorize validates the request of a client and sends the browser to the
+// frontend, which signs the user in and asks for consent
+func (oc OAuth2Controller) Authorize(c *gin.Context) {
+	request, err := oc.service.Authorize(c.Request.Context(), &domains.AuthorizationParams{
+		ResponseType:        c.Query("response_type"),
+		ClientID:            c.Query("client_id"),
+		RedirectURI:         c.Query("redirect_uri"),
+		Scope:               c.Query("scope"),
+		State:               c.Query("state"),
+		Nonce:               c.Query("nonce"),
+		CodeChallenge:       c.Query("code_challenge"),
+		CodeChallengeMethod: c.Query("code_challenge_method"),
+	})
+	if err != nil {
+		var oauthErr *domains.OAuth2Error
+		if errors.As(err, &oauthErr) && oauthErr.RedirectURI != "" {
+			c.Redirect(http.StatusFound, oauth2ErrorRedirect(oauthErr))
+			return
+		}
+		oc.handleError(c, err)
+		return
+	}
+
+	target, err := url.Parse(oc.consentURL)
+	if oc.consentURL == "" || err != nil {
+		c.JSON(http.StatusOK, gin.H{"requestId": request.ID})
+		return
+	}
+
+	query := target.Query()
+	query.Set("request", request.ID)
+	target.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, target.String())
+}
+
+// Prompt describes a pending authorization request to the signed in user
+func (oc OAuth2Controller) Prompt(c *gin.Context) {
+	prompt, err := oc.service.Prompt(c.Request.Context(), c.Param("id"), c.GetString(middlewares.UserIDKey))
+	if err != nil {
+		oc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, prompt)
+}
+
+// Decide records whether the signed in user approved the request and returns
+// where the frontend sends the browser next
+func (oc OAuth2Controller) Decide(c *gin.Context) {
+	var request decideAuthorizationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	claims := c.MustGet(middlewares.ClaimsKey).(*domains.AccessClaims)
+	redirectTo, err := oc.service.Decide(c.Request.Context(), c.Param("id"), claims, request.Approve)
+	if err != nil {
+		oc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"redirectTo": redirectTo})
+}
+
+// Token exchanges an authorization code or refresh token for tokens, or issues
+// a machine client a token of its own. Clients authenticate with HTTP Basic or
+// with client_id and client_secret form fields.
+func (oc OAuth2Controller) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := authenticateClient(c, oc.logger, oc.clients)
+	if !ok {
+		return
+	}
+
+	response, err := oc.service.Token(c.Request.Context(), client, &domains.TokenRequest{
+		GrantType:    c.PostForm("grant_type"),
+		Code:         c.PostForm("code"),
+		RedirectURI:  c.PostForm("redirect_uri"),
+		CodeVerifier: c.PostForm("code_verifier"),
+		RefreshToken: c.PostForm("refresh_token"),
+		Scope:        c.PostForm("scope"),
+		Audience:     c.PostForm("audience"),
+	})
+	if err != nil {
+		oc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Introspect tells resource servers whether a token is active and what it grants
+func (oc OAuth2Controller) Introspect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := authenticateClient(c, oc.logger, oc.clients)
+	if !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, &domains.OAuth2Error{Code: domains.InvalidRequestError, Description: "token is required"})
+		return
+	}
+
+	response, err := oc.service.Introspect(c.Request.Context(), client, token, c.PostForm("token_type_hint"))
+	if err != nil {
+		oc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Revoke revokes an access or refresh token issued to the client. Unknown tokens
+// are answered with success as well.
+func (oc OAuth2Controller) Revoke(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := authenticateClient(c, oc.logger, oc.clients)
+	if !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, &domains.OAuth2Error{Code: domains.InvalidRequestError, Description: "token is required"})
+		return
+	}
+
+	if err := oc.service.Revoke(c.Request.Context(), client, token, c.PostForm("token_type_hint")); err != nil {
+		oc.handleError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// Discovery serves the OpenID Connect provider metadata
+func (oc OAuth2Controller) Discovery(c *gin.Context) {
+	configuration, err := oc.oidc.Discovery()
+	if errors.Is(err, domains.ErrOpenIDUnavailable) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "openid_unavailable"})
+		return
+	}
+	if err != nil {
+		oc.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, configuration)
+}
+
+// UserInfo returns the claims about the user of the access token that the
+// scopes granted to the client cover
+func (oc OAuth2Controller) UserInfo(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	claims := c.MustGet(middlewares.ClaimsKey).(*domains.AccessClaims)
+	info, err := oc.oidc.UserInfo(c.Request.Context(), claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, domains.ErrInsufficientScope):
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_scope"})
+		case errors.Is(err, domains.ErrUserNotFound), errors.Is(err, domains.ErrUserSuspended):
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		default:
+			oc.logger.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+func (oc OAuth2Controller) handleError(c *gin.Context, err error) {
+	var oauthErr *domains.OAuth2Error
+	switch {
+	case errors.As(err, &oauthErr):
+		c.JSON(http.StatusBadRequest, oauthErr)
+	case errors.Is(err, domains.ErrAuthorizationRequestMissing):
+		c.JSON(http.StatusNotFound, gin.H{"error": "authorization_request_not_found"})
+	default:
+		oc.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+}
+
+// authenticateClient reads the client credentials of a request to the token,
+// introspection or revocation endpoint and verifies them. On failure it answers invalid_client with
+// 401, challenging for Basic credentials when the client used them.
+func authenticateClient(c *gin.Context, logger lib.Logger, clients services.OAuth2ClientService) (*domains.OAuth2Client, bool) {
+	clientID, secret, basic := clientCredentials(c)
+
+	client, err := clients.Authenticate(c.Request.Context(), clientID, secret)
+	if err == nil {
+		return client, true
+	}
+
+	if !errors.Is(err, domains.ErrClientNotFound) {
+		logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return nil, false
+	}
+
+	if basic {
+		c.Header("WWW-Authenticate", `Basic realm="oauth2"`)
+	}
+	c.JSON(http.StatusUnauthorized, &domains.OAuth2Error{
+		Code:        domains.InvalidClientError,
+		Description: "client authentication failed",
+	})
+	return nil, false
+}
+
+// clientCredentials returns the client id and secret from the Authorization
+// header, which RFC 6749 requires form encoded, or else from the request body
+func clientCredentials(c *gin.Context) (string, string, bool) {
+	if username, password, ok := c.Request.BasicAuth(); ok {
+		clientID, idErr := url.QueryUnescape(username)
+		secret, secretErr := url.QueryUnescape(password)
+		if idErr != nil || secretErr != nil {
+			return "", "", true
+		}
+		return clientID, secret, true
+	}
+
+	return c.PostForm("client_id"), c.PostForm("client_secret"), false
+}
+
+// oauth2ErrorRedirect delivers an authorization error to the client redirect uri
+func oauth2ErrorRedirect(err *domains.OAuth2Error) string {
+	target, parseErr := url.Parse(err.RedirectURI)
+	if parseErr != nil {
+		return err.RedirectURI
+	}
+
+	query := target.Query()
+	query.Set("error", err.Code)
+	if err.Description != "" {
+		query.Set("error_description", err.Description)
+	}
+	if err.State != "" {
+		query.Set("state", err.State)
+	}
+	target.RawQuery = query.Encode()
+
+	return target.String()
+}
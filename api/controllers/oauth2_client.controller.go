@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OAuth2ClientController struct {
+	logger  lib.Logger
+	service services.OAuth2ClientService
+}
+
+type registerClientRequest struct {
+	Name         string   `json:"name" binding:"required,max=100"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grantTypes"`
+	Audiences    []string `json:"audiences"`
+	FirstParty   bool     `json:"firstParty"`
+	// ResourceServer lets the client introspect every access token
+	ResourceServer bool `json:"resourceServer"`
+}
+
+func NewOAuth2ClientController(
+	logger lib.Logger,
+	service services.OAuth2ClientService,
+) OAuth2ClientController {
+	return OAuth2ClientController{
+		logger:  logger,
+		service: service,
+	}
+}
+
+// Register adds a client. The secret of a confidential client is only part of this response.
+func (cc OAuth2ClientController) Register(c *gin.Context) {
+	var request registerClientRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	registered, err := cc.service.Register(c.Request.Context(), &domains.OAuth2Client{
+		Name:           request.Name,
+		Public:         request.Public,
+		RedirectURIs:   request.RedirectURIs,
+		Scopes:         request.Scopes,
+		GrantTypes:     request.GrantTypes,
+		Audiences:      request.Audiences,
+		FirstParty:     request.FirstParty,
+		ResourceServer: request.ResourceServer,
+	})
+	if err != nil {
+		cc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, registered)
+}
+
+// List returns every registered client
+func (cc OAuth2ClientController) List(c *gin.Context) {
+	clients, err := cc.service.List(c.Request.Context())
+	if err != nil {
+		cc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"clients": clients})
+}
+
+// Get returns one client
+func (cc OAuth2ClientController) Get(c *gin.Context) {
+	client, err := cc.service.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		cc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, client)
+}
+
+// Delete removes a client and revokes its refresh tokens
+func (cc OAuth2ClientController) Delete(c *gin.Context) {
+	if err := cc.service.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		cc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Client deleted"})
+}
+
+func (cc OAuth2ClientController) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, domains.ErrClientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "client_not_found"})
+	case errors.Is(err, domains.ErrInvalidClientMetadata):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_client_metadata", "message": err.Error()})
+	default:
+		cc.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleController struct {
+	logger  lib.Logger
+	service services.RoleService
+	users   services.UserService
+}
+
+type assignRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+func NewRoleController(
+	logger lib.Logger,
+	service services.RoleService,
+	users services.UserService,
+) RoleController {
+	return RoleController{
+		logger:  logger,
+		service: service,
+		users:   users,
+	}
+}
+
+// ListRoles returns every role with the permissions it grants
+func (rc RoleController) ListRoles(c *gin.Context) {
+	roles, err := rc.service.ListRoles(c.Request.Context())
+	if err != nil {
+		rc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// GetUser returns the account of any user
+func (rc RoleController) GetUser(c *gin.Context) {
+	user, err := rc.users.GetUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		rc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// AssignRoles replaces the roles of a user
+func (rc RoleController) AssignRoles(c *gin.Context) {
+	var request assignRolesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	user, err := rc.service.AssignRoles(c.Request.Context(), c.Param("id"), request.Roles)
+	if err != nil {
+		rc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": user.Roles})
+}
+
+func (rc RoleController) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, domains.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user_not_found"})
+	case errors.Is(err, domains.ErrRoleNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown_role"})
+	default:
+		rc.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+}
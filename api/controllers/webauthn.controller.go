@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"diandi-backend/api/middlewares"
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnController struct {
+	logger   lib.Logger
+	service  services.WebAuthnService
+	sessions services.SessionService
+}
+
+type finishRegistrationRequest struct {
+	SessionID    string          `json:"sessionId" binding:"required"`
+	Name         string          `json:"name" binding:"max=100"`
+	SecondFactor bool            `json:"secondFactor"`
+	Credential   json.RawMessage `json:"credential" binding:"required"`
+}
+
+type finishLoginRequest struct {
+	SessionID  string          `json:"sessionId" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+type beginSecondFactorRequest struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+}
+
+type finishSecondFactorRequest struct {
+	MFAToken   string          `json:"mfaToken" binding:"required"`
+	SessionID  string          `json:"sessionId" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+func NewWebAuthnController(
+	logger lib.Logger,
+	service services.WebAuthnService,
+	sessions services.SessionService,
+) WebAuthnController {
+	return WebAuthnController{
+		logger:   logger,
+		service:  service,
+		sessions: sessions,
+	}
+}
+
+// BeginRegistration returns the options for navigator.credentials.create
+func (wc WebAuthnController) BeginRegistration(c *gin.Context) {
+	claims := c.MustGet(middlewares.ClaimsKey).(*domains.AccessClaims)
+	ceremony, err := wc.service.BeginRegistration(c.Request.Context(), c.GetString(middlewares.UserIDKey), claims.Authentication())
+	if err != nil {
+		wc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ceremony)
+}
+
+// FinishRegistration stores the passkey created by the browser
+func (wc WebAuthnController) FinishRegistration(c *gin.Context) {
+	var request finishRegistrationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	claims := c.MustGet(middlewares.ClaimsKey).(*domains.AccessClaims)
+	credential, err := wc.service.FinishRegistration(
+		c.Request.Context(),
+		c.GetString(middlewares.UserIDKey),
+		claims.Authentication(),
+		request.SessionID,
+		request.Name,
+		request.SecondFactor,
+		request.Credential,
+	)
+	if err != nil {
+		wc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, credential)
+}
+
+// BeginLogin returns the options for navigator.credentials.get, any passkey
+// registered for this site may answer
+func (wc WebAuthnController) BeginLogin(c *gin.Context) {
+	ceremony, err := wc.service.BeginLogin(c.Request.Context())
+	if err != nil {
+		wc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ceremony)
+}
+
+// FinishLogin signs the passkey owner in. A passkey verifies possession and the
+// user on the device, so no further MFA challenge is issued.
+func (wc WebAuthnController) FinishLogin(c *gin.Context) {
+	var request finishLoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	user, err := wc.service.FinishLogin(c.Request.Context(), request.SessionID, request.Credential)
+	if err != nil {
+		wc.handleError(c, err)
+		return
+	}
+
+	issueTokens(c, wc.logger, wc.sessions, user.ID, domains.HardwareKeyMethod)
+}
+
+// BeginSecondFactor starts answering an MFA challenge with one of the user's passkeys
+func (wc WebAuthnController) BeginSecondFactor(c *gin.Context) {
+	var request beginSecondFactorRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	ceremony, err := wc.service.BeginSecondFactor(c.Request.Context(), request.MFAToken)
+	if err != nil {
+		wc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ceremony)
+}
+
+// FinishSecondFactor completes a challenged sign in with a passkey assertion
+func (wc WebAuthnController) FinishSecondFactor(c *gin.Context) {
+	var request finishSecondFactorRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	user, err := wc.service.FinishSecondFactor(c.Request.Context(), request.MFAToken, request.SessionID, request.Credential)
+	if err != nil {
+		wc.handleError(c, err)
+		return
+	}
+
+	issueTokens(c, wc.logger, wc.sessions, user.ID, domains.MultiFactorMethod, domains.HardwareKeyMethod)
+}
+
+// ListCredentials returns the passkeys of the signed in user
+func (wc WebAuthnController) ListCredentials(c *gin.Context) {
+	credentials, err := wc.service.ListCredentials(c.Request.Context(), c.GetString(middlewares.UserIDKey))
+	if err != nil {
+		wc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"credentials": credentials})
+}
+
+// DeleteCredential removes one of the signed in user's passkeys
+func (wc WebAuthnController) DeleteCredential(c *gin.Context) {
+	claims := c.MustGet(middlewares.ClaimsKey).(*domains.AccessClaims)
+	if err := wc.service.DeleteCredential(c.Request.Context(), c.GetString(middlewares.UserIDKey), claims.Authentication(), c.Param("id")); err != nil {
+		wc.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted"})
+}
+
+func (wc WebAuthnController) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, domains.ErrWebAuthnDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": "webauthn_disabled"})
+	case errors.Is(err, domains.ErrInvalidWebAuthnSession):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_session"})
+	case errors.Is(err, domains.ErrInvalidActionToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_grant"})
+	case errors.Is(err, domains.ErrWebAuthnFailed), errors.Is(err, domains.ErrUserNotFound):
+		// Unknown user handles fail like bad signatures so accounts cannot be probed
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "webauthn_failed"})
+	case errors.Is(err, domains.ErrReauthenticationRequired):
+		// Refreshing keeps the sign in time, the user has to sign in again
+		c.JSON(http.StatusForbidden, gin.H{"error": "reauthentication_required"})
+	case errors.Is(err, domains.ErrCredentialNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "credential_not_found"})
+	default:
+		wc.logger.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+}
@@ -0,0 +1,7 @@
+package handlers
+
+import "go.uber.org/fx"
+
+var Module = fx.Options(
+	fx.Provide(NewOAuthHandler),
+)
@@ -1,11 +1,13 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"errors"
 	"net/http"
+	"net/url"
 
+	"diandi-backend/api/middlewares"
 	"diandi-backend/domains"
+	"diandi-backend/lib"
 	"diandi-backend/services"
 
 	"github.com/gin-gonic/gin"
@@ -13,13 +15,30 @@ import (
 
 // OAuthHandler handles OAuth-related HTTP requests
 type OAuthHandler struct {
-	oauthService services.OAuthService
+	logger         lib.Logger
+	frontendURL    string
+	oauthService   services.OAuthService
+	userService    services.UserService
+	sessionService services.SessionService
+	authMiddleware middlewares.JWTMiddleware
 }
 
 // NewOAuthHandler creates a new OAuth handler
-func NewOAuthHandler(oauthService services.OAuthService) *OAuthHandler {
+func NewOAuthHandler(
+	logger lib.Logger,
+	env lib.Env,
+	oauthService services.OAuthService,
+	userService services.UserService,
+	sessionService services.SessionService,
+	authMiddleware middlewares.JWTMiddleware,
+) *OAuthHandler {
 	return &OAuthHandler{
-		oauthService: oauthService,
+		logger:         logger,
+		frontendURL:    env.FrontendURL,
+		oauthService:   oauthService,
+		userService:    userService,
+		sessionService: sessionService,
+		authMiddleware: authMiddleware,
 	}
 }
 
@@ -28,93 +47,167 @@ func (h *OAuthHandler) RegisterRoutes(router *gin.RouterGroup) {
 	oauth := router.Group("/oauth")
 	{
 		oauth.GET("/login/:provider", h.HandleOAuthLogin)
+		oauth.POST("/link/:provider", h.authMiddleware.Handler(), h.HandleOAuthLink)
 		oauth.GET("/callback/:provider", h.HandleOAuthCallback)
-		oauth.POST("/unlink/:provider", h.HandleUnlinkAccount)
+		oauth.POST("/callback/:provider", h.HandleOAuthCallback)
+		oauth.POST("/unlink/:provider", h.authMiddleware.Handler(), h.HandleUnlinkAccount)
 	}
 }
 
-// HandleOAuthLogin initiates the OAuth flow
+// HandleOAuthLogin initiates the OAuth sign in flow
 func (h *OAuthHandler) HandleOAuthLogin(c *gin.Context) {
-	provider := domains.OAuthProvider(c.Param("provider"))
+	// Browsers navigating here send no Authorization header, linking starts at HandleOAuthLink
+	if c.Query("intent") == string(domains.LinkOAuthIntent) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Linking starts with POST /oauth/link/:provider"})
+		return
+	}
+
+	url, ok := h.authURL(c, domains.LoginOAuthIntent, "")
+	if !ok {
+		return
+	}
+
+	// Redirect to provider's consent page
+	c.Redirect(http.StatusTemporaryRedirect, url)
+}
+
+// HandleOAuthLink initiates linking a provider account to the signed in user. The
+// provider URL is returned for the frontend to navigate to, as the navigation
+// itself cannot carry the bearer token.
+func (h *OAuthHandler) HandleOAuthLink(c *gin.Context) {
+	userID := c.GetString(middlewares.UserIDKey)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
 
-	// Generate random state
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate state"})
+	url, ok := h.authURL(c, domains.LinkOAuthIntent, userID)
+	if !ok {
 		return
 	}
-	state := base64.URLEncoding.EncodeToString(b)
 
-	// Store state in session or cookie
-	c.SetCookie("oauth_state", state, 3600, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{"url": url})
+}
+
+// authURL stores the state of a new flow and returns the provider's authorization
+// URL, answering the request itself when that fails
+func (h *OAuthHandler) authURL(c *gin.Context, intent domains.OAuthIntent, userID string) (string, bool) {
+	provider := domains.OAuthProvider(c.Param("provider"))
+
+	// Store state, PKCE verifier and nonce server side until the callback
+	state, err := h.oauthService.CreateState(c.Request.Context(), provider, intent, userID, c.Query("return_to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
 
 	// Get authorization URL
-	url, err := h.oauthService.GetAuthURL(provider, state)
+	url, err := h.oauthService.GetAuthURL(state)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+		return "", false
 	}
 
-	// Redirect to provider's consent page
-	c.Redirect(http.StatusTemporaryRedirect, url)
+	return url, true
 }
 
-// HandleOAuthCallback processes the OAuth callback
+// HandleOAuthCallback processes the OAuth callback and sends the browser back to
+// the frontend with a single use login code, or with an error code on failure
 func (h *OAuthHandler) HandleOAuthCallback(c *gin.Context) {
 	provider := domains.OAuthProvider(c.Param("provider"))
-	code := c.Query("code")
-	state := c.Query("state")
+	ctx := c.Request.Context()
+
+	// Providers using response_mode=form_post deliver the callback parameters in the body
+	if err := c.Request.ParseForm(); err != nil {
+		h.redirectError(c, "", domains.InvalidStateOAuthError)
+		return
+	}
+	params := c.Request.Form
 
-	// Verify state
-	savedState, err := c.Cookie("oauth_state")
-	if err != nil || state != savedState {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+	// Verify and consume state
+	savedState, err := h.oauthService.ConsumeState(ctx, provider, params.Get("state"))
+	if err != nil {
+		h.redirectError(c, "", domains.InvalidStateOAuthError)
 		return
 	}
+	returnTo := savedState.ReturnTo
 
-	// Clear state cookie
-	c.SetCookie("oauth_state", "", -1, "/", "", false, true)
+	// The user declined the consent or the provider failed the authorization
+	if providerError := params.Get("error"); providerError != "" {
+		h.logger.Info("OAuth provider returned an error: ", provider, " ", providerError)
+		if providerError == "access_denied" || providerError == "user_cancelled_authorize" {
+			h.redirectError(c, returnTo, domains.AccessDeniedOAuthError)
+			return
+		}
+		h.redirectError(c, returnTo, domains.ProviderOAuthError)
+		return
+	}
 
 	// Exchange code for token
-	token, err := h.oauthService.ExchangeCode(c.Request.Context(), provider, code)
+	token, err := h.oauthService.ExchangeCode(ctx, savedState, params.Get("code"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.logger.Error("Failed to exchange code: ", err)
+		h.redirectError(c, returnTo, domains.ProviderOAuthError)
 		return
 	}
 
 	// Get user profile
-	profile, err := h.oauthService.GetUserProfile(c.Request.Context(), provider, token)
+	profile, err := h.oauthService.GetUserProfile(ctx, provider, token)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.logger.Error("Failed to get user profile: ", err)
+		h.redirectError(c, returnTo, domains.ProviderOAuthError)
 		return
 	}
 
-	// Get user ID from session or create new user
-	userID := c.GetString("user_id") // Assuming user ID is set in middleware
-	if userID == "" {
-		// Create new user logic here
-		// This should be handled by your user service
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "User creation not implemented"})
+	// Merge profile data sent along with the callback, e.g. Apple's first sign in name
+	if err := h.oauthService.CompleteProfile(provider, profile, params); err != nil {
+		h.logger.Error("Failed to complete user profile: ", err)
+		h.redirectError(c, returnTo, domains.ProviderOAuthError)
 		return
 	}
 
+	// Link to the user who started the flow, otherwise resolve or create the profile owner
+	userID := savedState.UserID
+	if savedState.Intent != domains.LinkOAuthIntent {
+		user, _, err := h.userService.ResolveOAuthUser(ctx, profile)
+		if errors.Is(err, domains.ErrEmailTaken) {
+			h.redirectError(c, returnTo, domains.EmailTakenOAuthError)
+			return
+		}
+		if err != nil {
+			h.logger.Error("Failed to resolve user: ", err)
+			h.redirectError(c, returnTo, domains.ServerOAuthError)
+			return
+		}
+		userID = user.ID
+	}
+
 	// Link account
-	if err := h.oauthService.LinkAccount(c.Request.Context(), userID, profile, token); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.oauthService.LinkAccount(ctx, userID, profile, token); err != nil {
+		if errors.Is(err, domains.ErrAccountLinked) {
+			h.redirectError(c, returnTo, domains.AccountLinkedOAuthError)
+			return
+		}
+		h.logger.Error("Failed to link account: ", err)
+		h.redirectError(c, returnTo, domains.ServerOAuthError)
+		return
+	}
+
+	// Hand a single use code to the frontend, exchanged for tokens at /auth/token
+	code, err := h.sessionService.CreateLoginCode(ctx, userID)
+	if err != nil {
+		h.logger.Error("Failed to create login code: ", err)
+		h.redirectError(c, returnTo, domains.ServerOAuthError)
 		return
 	}
 
-	// Redirect to frontend with success
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Successfully linked account",
-		"profile": profile,
-	})
+	h.redirect(c, returnTo, url.Values{"code": {code}})
 }
 
 // HandleUnlinkAccount unlinks a social account from the user
 func (h *OAuthHandler) HandleUnlinkAccount(c *gin.Context) {
 	provider := domains.OAuthProvider(c.Param("provider"))
-	userID := c.GetString("user_id") // Assuming user ID is set in middleware
+	userID := c.GetString(middlewares.UserIDKey)
 
 	if userID == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
@@ -128,3 +221,36 @@ func (h *OAuthHandler) HandleUnlinkAccount(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully unlinked account"})
 }
+
+// redirectError sends the browser back to the frontend with a stable error code
+func (h *OAuthHandler) redirectError(c *gin.Context, returnTo string, code domains.OAuthErrorCode) {
+	h.redirect(c, returnTo, url.Values{"error": {string(code)}})
+}
+
+// redirect sends the browser back to return_to, or FRONTEND_URL when the flow did
+// not carry one, with params added to the query string
+func (h *OAuthHandler) redirect(c *gin.Context, returnTo string, params url.Values) {
+	target := returnTo
+	if target == "" {
+		target = h.frontendURL
+	}
+
+	u, err := url.Parse(target)
+	if target == "" || err != nil {
+		// Without a frontend to return to, report the outcome directly
+		if params.Has("error") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": params.Get("error")})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"code": params.Get("code")})
+		return
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusSeeOther, u.String())
+}
@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"diandi-backend/lib"
+	"diandi-backend/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyKey is the gin context key holding the API key a request was made with
+const APIKeyKey = "api_key"
+
+// APIKeyMiddleware authenticates requests carrying `Authorization: ApiKey <key>`.
+// The key's effective scopes become the permissions of the request claims, so
+// RoleMiddleware.RequirePermission applies to keys and access tokens alike.
+type APIKeyMiddleware struct {
+	logger  lib.Logger
+	service services.APIKeyService
+	jwt     JWTMiddleware
+}
+
+func NewAPIKeyMiddleware(
+	logger lib.Logger,
+	service services.APIKeyService,
+	jwt JWTMiddleware,
+) APIKeyMiddleware {
+	return APIKeyMiddleware{
+		logger:  logger,
+		service: service,
+		jwt:     jwt,
+	}
+}
+
+func (m APIKeyMiddleware) SetUp() {}
+
+// Handler accepts an API key, or else any bearer token checked by
+// JWTMiddleware.ResourceHandler. The user id is only set for keys owned by a user.
+func (m APIKeyMiddleware) Handler() gin.HandlerFunc {
+	bearer := m.jwt.ResourceHandler()
+
+	return func(c *gin.Context) {
+		t := strings.Split(c.GetHeader("Authorization"), " ")
+		if len(t) != 2 || !strings.EqualFold(t[0], "ApiKey") {
+			bearer(c)
+			return
+		}
+
+		apiKey, claims, err := m.service.Authenticate(c.Request.Context(), t[1])
+		if err == nil {
+			setClaims(c, claims)
+			c.Set(APIKeyKey, apiKey)
+			c.Next()
+			return
+		}
+		m.logger.Debug(err)
+
+		c.AbortWithStatusJSON(
+			http.StatusUnauthorized,
+			gin.H{
+				"error": "You are not authorized",
+			},
+		)
+	}
+}
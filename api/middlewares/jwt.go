@@ -1,53 +1,127 @@
 package middlewares
 
 import (
+	"diandi-backend/domains"
 	"diandi-backend/lib"
 	"diandi-backend/services"
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// UserIDKey is the gin context key holding the authenticated user id
+	UserIDKey = "user_id"
+	// ClaimsKey is the gin context key holding the verified access token claims
+	ClaimsKey = "claims"
+	// PrincipalKey is the gin context key holding the domains.Principal the
+	// request acts for, a user or a service
+	PrincipalKey = "principal"
+)
+
 type JWTMiddleware struct {
-	logger  lib.Logger
-	service services.AuthService
+	logger      lib.Logger
+	service     domains.AuthService
+	revocations services.TokenRevocationService
 }
 
 func NewAuthMiddleware(
 	logger lib.Logger,
-	service services.AuthService,
+	service domains.AuthService,
+	revocations services.TokenRevocationService,
 ) JWTMiddleware {
 	return JWTMiddleware{
-		logger:  logger,
-		service: service,
+		logger:      logger,
+		service:     service,
+		revocations: revocations,
 	}
 }
 
 func (m JWTMiddleware) SetUp() {}
 
+// Handler only accepts tokens of our own frontend sessions. Account management
+// stays out of reach of tokens issued to OAuth2 clients.
 func (m JWTMiddleware) Handler() gin.HandlerFunc {
+	return m.handler(true)
+}
+
+// ResourceHandler also accepts tokens issued to OAuth2 clients, whose
+// permissions are limited to the scopes the user granted the client, and the
+// tokens of machine clients. Handlers tell them apart by the PrincipalKey, the
+// UserIDKey is only set for users.
+func (m JWTMiddleware) ResourceHandler() gin.HandlerFunc {
+	return m.handler(false)
+}
+
+func (m JWTMiddleware) handler(firstPartyOnly bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		t := strings.Split(authHeader, " ")
-		if len(t) == 2 {
+		if len(t) == 2 && strings.EqualFold(t[0], "Bearer") {
 			authToken := t[1]
-			authorized, err := m.service.Authorize(authToken)
-			if authorized {
+			claims, err := m.authorize(authToken)
+			if err == nil && firstPartyOnly && !claims.FirstParty() {
+				err = errors.New("token was issued to an oauth2 client")
+			}
+			if err == nil {
+				setClaims(c, claims)
 				c.Next()
 				return
 			}
-			c.JSON(
-				http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
-				})
+			m.logger.Debug(err)
 		}
-		c.JSON(
+		c.AbortWithStatusJSON(
 			http.StatusUnauthorized,
 			gin.H{
 				"error": "You are not authorized",
 			},
 		)
-		c.Abort()
 	}
 }
+
+// OptionalHandler populates the authenticated user when a valid bearer token is
+// present and lets anonymous requests through untouched
+func (m JWTMiddleware) OptionalHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := strings.Split(c.GetHeader("Authorization"), " ")
+		if len(t) == 2 && strings.EqualFold(t[0], "Bearer") {
+			if claims, err := m.authorize(t[1]); err == nil && claims.FirstParty() {
+				setClaims(c, claims)
+			}
+		}
+		c.Next()
+	}
+}
+
+// authorize verifies the token was issued for our API and rejects it once
+// revoked through logout or suspension
+func (m JWTMiddleware) authorize(tokenString string) (*domains.AccessClaims, error) {
+	claims, err := m.service.Authorize(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens of machine clients for other resource servers are not ours to accept
+	if !slices.Contains(claims.Audience, m.service.Audience()) {
+		return nil, errors.New("token was issued for another audience")
+	}
+
+	if m.revocations.IsRevoked(claims) {
+		return nil, errors.New("token has been revoked")
+	}
+
+	return claims, nil
+}
+
+// setClaims stores the verified claims and whom they act for in the context
+func setClaims(c *gin.Context, claims *domains.AccessClaims) {
+	principal := claims.Principal()
+	if principal.Type == domains.UserPrincipal {
+		c.Set(UserIDKey, principal.ID)
+	}
+	c.Set(PrincipalKey, principal)
+	c.Set(ClaimsKey, claims)
+}
@@ -1,5 +1,13 @@
 package middlewares
 
+import "go.uber.org/fx"
+
+var Module = fx.Options(
+	fx.Provide(NewAuthMiddleware),
+	fx.Provide(NewRoleMiddleware),
+	fx.Provide(NewAPIKeyMiddleware),
+)
+
 type Middlewares []Middleware
 
 type Middleware interface {
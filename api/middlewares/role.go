@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoleMiddleware restricts routes by the roles and permissions embedded in the
+// access token. It runs after JWTMiddleware.Handler, which verifies the token.
+type RoleMiddleware struct {
+	logger lib.Logger
+}
+
+func NewRoleMiddleware(logger lib.Logger) RoleMiddleware {
+	return RoleMiddleware{
+		logger: logger,
+	}
+}
+
+func (m RoleMiddleware) SetUp() {}
+
+// RequireRole lets only users holding role through
+func (m RoleMiddleware) RequireRole(role string) gin.HandlerFunc {
+	return m.require(func(claims *domains.AccessClaims) bool {
+		for _, granted := range claims.Roles {
+			if granted == role {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// RequirePermission lets only users whose roles grant permission through,
+// e.g. RequirePermission(domains.UsersReadPermission)
+func (m RoleMiddleware) RequirePermission(permission string) gin.HandlerFunc {
+	return m.require(func(claims *domains.AccessClaims) bool {
+		return claims.HasPermission(permission)
+	})
+}
+
+func (m RoleMiddleware) require(allowed func(claims *domains.AccessClaims) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := c.Get(ClaimsKey)
+		if ok && allowed(claims.(*domains.AccessClaims)) {
+			c.Next()
+			return
+		}
+
+		m.logger.Debug("Access denied to ", c.GetString(UserIDKey), " for ", c.FullPath())
+		c.AbortWithStatusJSON(
+			http.StatusForbidden,
+			gin.H{
+				"error": "You are not allowed to access this resource",
+			},
+		)
+	}
+}
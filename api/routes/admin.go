@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"diandi-backend/api/controllers"
+	"diandi-backend/api/middlewares"
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminRoutes struct {
+	logger           lib.Logger
+	group            *gin.RouterGroup
+	mfaController    controllers.MFAController
+	roleController   controllers.RoleController
+	clientController controllers.OAuth2ClientController
+	authMiddleware   middlewares.APIKeyMiddleware
+	roleMiddleware   middlewares.RoleMiddleware
+}
+
+func (s AdminRoutes) SetUp() {
+	s.logger.Info("Setting up Admin Routes")
+	// Admin endpoints also serve automation holding an API key with the right scopes
+	admin := s.group.Group("/admin", s.authMiddleware.Handler())
+	{
+		admin.GET("/roles", s.roleMiddleware.RequirePermission(domains.RolesReadPermission), s.roleController.ListRoles)
+		admin.GET("/users/:id", s.roleMiddleware.RequirePermission(domains.UsersReadPermission), s.roleController.GetUser)
+		admin.PUT("/users/:id/roles", s.roleMiddleware.RequirePermission(domains.RolesWritePermission), s.roleController.AssignRoles)
+		admin.POST("/users/:id/mfa/reset", s.roleMiddleware.RequirePermission(domains.UsersWritePermission), s.mfaController.ResetUser)
+		admin.GET("/clients", s.roleMiddleware.RequirePermission(domains.ClientsReadPermission), s.clientController.List)
+		admin.POST("/clients", s.roleMiddleware.RequirePermission(domains.ClientsWritePermission), s.clientController.Register)
+		admin.GET("/clients/:id", s.roleMiddleware.RequirePermission(domains.ClientsReadPermission), s.clientController.Get)
+		admin.DELETE("/clients/:id", s.roleMiddleware.RequirePermission(domains.ClientsWritePermission), s.clientController.Delete)
+	}
+}
+
+func NewAdminRoutes(
+	logger lib.Logger,
+	group *gin.RouterGroup,
+	mfaController controllers.MFAController,
+	roleController controllers.RoleController,
+	clientController controllers.OAuth2ClientController,
+	authMiddleware middlewares.APIKeyMiddleware,
+	roleMiddleware middlewares.RoleMiddleware,
+) AdminRoutes {
+	return AdminRoutes{
+		logger:           logger,
+		group:            group,
+		mfaController:    mfaController,
+		roleController:   roleController,
+		clientController: clientController,
+		authMiddleware:   authMiddleware,
+		roleMiddleware:   roleMiddleware,
+	}
+}
@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"diandi-backend/api/controllers"
+	"diandi-backend/api/middlewares"
+	"diandi-backend/lib"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyRoutes struct {
+	logger         lib.Logger
+	group          *gin.RouterGroup
+	controller     controllers.APIKeyController
+	authMiddleware middlewares.JWTMiddleware
+}
+
+func (s APIKeyRoutes) SetUp() {
+	s.logger.Info("Setting up API Key Routes")
+	// Keys are managed with access tokens only, a leaked key cannot mint new ones
+	keys := s.group.Group("/api-keys", s.authMiddleware.Handler())
+	{
+		keys.POST("", s.controller.Create)
+		keys.GET("", s.controller.List)
+		keys.GET("/:id", s.controller.Get)
+		keys.PATCH("/:id", s.controller.Update)
+		keys.DELETE("/:id", s.controller.Delete)
+	}
+}
+
+func NewAPIKeyRoutes(
+	logger lib.Logger,
+	group *gin.RouterGroup,
+	controller controllers.APIKeyController,
+	authMiddleware middlewares.JWTMiddleware,
+) APIKeyRoutes {
+	return APIKeyRoutes{
+		logger:         logger,
+		group:          group,
+		controller:     controller,
+		authMiddleware: authMiddleware,
+	}
+}
@@ -2,22 +2,35 @@ package routes
 
 import (
 	"diandi-backend/api/controllers"
+	"diandi-backend/api/middlewares"
 	"diandi-backend/lib"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthRoutes struct {
-	logger     lib.Logger
-	group      *gin.RouterGroup
-	controller controllers.AuthController
+	logger         lib.Logger
+	group          *gin.RouterGroup
+	controller     controllers.AuthController
+	authMiddleware middlewares.JWTMiddleware
 }
 
 func (s AuthRoutes) SetUp() {
 	s.logger.Info("Setting up Auth Routes")
 	auth := s.group.Group("/auth")
 	{
+		auth.POST("/register", s.controller.Register)
 		auth.POST("/login", s.controller.SignIn)
+		auth.POST("/mfa/verify", s.controller.VerifyMFA)
+		auth.POST("/email-login", s.controller.RequestEmailLogin)
+		auth.POST("/email-login/verify", s.controller.VerifyEmailLogin)
+		auth.POST("/token", s.controller.ExchangeToken)
+		auth.POST("/refresh", s.controller.Refresh)
+		auth.POST("/logout", s.authMiddleware.Handler(), s.controller.Logout)
+		auth.POST("/verify-email/send", s.authMiddleware.Handler(), s.controller.SendVerificationEmail)
+		auth.GET("/verify-email/confirm", s.controller.ConfirmEmail)
+		auth.POST("/password/forgot", s.controller.ForgotPassword)
+		auth.POST("/password/reset", s.controller.ResetPassword)
 	}
 }
 
@@ -25,10 +38,12 @@ func NewAuthRoutes(
 	logger lib.Logger,
 	group *gin.RouterGroup,
 	controller controllers.AuthController,
+	authMiddleware middlewares.JWTMiddleware,
 ) AuthRoutes {
 	return AuthRoutes{
-		logger:     logger,
-		group:      group,
-		controller: controller,
+		logger:         logger,
+		group:          group,
+		controller:     controller,
+		authMiddleware: authMiddleware,
 	}
 }
@@ -0,0 +1,41 @@
+package routes
+
+import (
+	"diandi-backend/api/controllers"
+	"diandi-backend/api/middlewares"
+	"diandi-backend/lib"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFARoutes struct {
+	logger         lib.Logger
+	group          *gin.RouterGroup
+	controller     controllers.MFAController
+	authMiddleware middlewares.JWTMiddleware
+}
+
+func (s MFARoutes) SetUp() {
+	s.logger.Info("Setting up MFA Routes")
+	mfa := s.group.Group("/auth/mfa", s.authMiddleware.Handler())
+	{
+		mfa.POST("/totp/enroll", s.controller.Enroll)
+		mfa.POST("/totp/confirm", s.controller.ConfirmEnrollment)
+		mfa.POST("/recovery-codes", s.controller.RegenerateRecoveryCodes)
+		mfa.POST("/disable", s.controller.Disable)
+	}
+}
+
+func NewMFARoutes(
+	logger lib.Logger,
+	group *gin.RouterGroup,
+	controller controllers.MFAController,
+	authMiddleware middlewares.JWTMiddleware,
+) MFARoutes {
+	return MFARoutes{
+		logger:         logger,
+		group:          group,
+		controller:     controller,
+		authMiddleware: authMiddleware,
+	}
+}
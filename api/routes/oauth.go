@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"diandi-backend/api/handlers"
+	"diandi-backend/lib"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OAuthRoutes struct {
+	logger  lib.Logger
+	group   *gin.RouterGroup
+	handler *handlers.OAuthHandler
+}
+
+func (s OAuthRoutes) SetUp() {
+	s.logger.Info("Setting up OAuth Routes")
+	s.handler.RegisterRoutes(s.group)
+}
+
+func NewOAuthRoutes(
+	logger lib.Logger,
+	group *gin.RouterGroup,
+	handler *handlers.OAuthHandler,
+) OAuthRoutes {
+	return OAuthRoutes{
+		logger:  logger,
+		group:   group,
+		handler: handler,
+	}
+}
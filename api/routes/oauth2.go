@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"diandi-backend/api/controllers"
+	"diandi-backend/api/middlewares"
+	"diandi-backend/lib"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OAuth2Routes serves our authorization server. The protocol endpoints sit under
+// /oauth2 at the root, the consent API for the frontend under the versioned API.
+type OAuth2Routes struct {
+	logger         lib.Logger
+	handler        lib.RequestHandler
+	group          *gin.RouterGroup
+	controller     controllers.OAuth2Controller
+	authMiddleware middlewares.JWTMiddleware
+}
+
+func (s OAuth2Routes) SetUp() {
+	s.logger.Info("Setting up OAuth2 Routes")
+	oauth2 := s.handler.Gin.Group("/oauth2")
+	{
+		oauth2.GET("/authorize", s.controller.Authorize)
+		oauth2.POST("/token", s.controller.Token)
+		oauth2.POST("/introspect", s.controller.Introspect)
+		oauth2.POST("/revoke", s.controller.Revoke)
+		oauth2.GET("/userinfo", s.authMiddleware.ResourceHandler(), s.controller.UserInfo)
+		oauth2.POST("/userinfo", s.authMiddleware.ResourceHandler(), s.controller.UserInfo)
+	}
+
+	requests := s.group.Group("/oauth2/requests", s.authMiddleware.Handler())
+	{
+		requests.GET("/:id", s.controller.Prompt)
+		requests.POST("/:id", s.controller.Decide)
+	}
+}
+
+func NewOAuth2Routes(
+	logger lib.Logger,
+	handler lib.RequestHandler,
+	group *gin.RouterGroup,
+	controller controllers.OAuth2Controller,
+	authMiddleware middlewares.JWTMiddleware,
+) OAuth2Routes {
+	return OAuth2Routes{
+		logger:         logger,
+		handler:        handler,
+		group:          group,
+		controller:     controller,
+		authMiddleware: authMiddleware,
+	}
+}
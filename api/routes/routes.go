@@ -8,6 +8,13 @@ var Module = fx.Options(
 	fx.Provide(NewApiV1),
 	fx.Provide(NewRoutes),
 	fx.Provide(NewAuthRoutes),
+	fx.Provide(NewOAuthRoutes),
+	fx.Provide(NewWellKnownRoutes),
+	fx.Provide(NewMFARoutes),
+	fx.Provide(NewAdminRoutes),
+	fx.Provide(NewWebAuthnRoutes),
+	fx.Provide(NewAPIKeyRoutes),
+	fx.Provide(NewOAuth2Routes),
 )
 
 type Routes []Route
@@ -18,9 +25,23 @@ type Route interface {
 
 func NewRoutes(
 	authRoutes AuthRoutes,
+	oauthRoutes OAuthRoutes,
+	wellKnownRoutes WellKnownRoutes,
+	mfaRoutes MFARoutes,
+	adminRoutes AdminRoutes,
+	webAuthnRoutes WebAuthnRoutes,
+	apiKeyRoutes APIKeyRoutes,
+	oauth2Routes OAuth2Routes,
 ) Routes {
 	return Routes{
 		authRoutes,
+		oauthRoutes,
+		wellKnownRoutes,
+		mfaRoutes,
+		adminRoutes,
+		webAuthnRoutes,
+		apiKeyRoutes,
+		oauth2Routes,
 	}
 }
 
@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"diandi-backend/api/controllers"
+	"diandi-backend/api/middlewares"
+	"diandi-backend/lib"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnRoutes struct {
+	logger         lib.Logger
+	group          *gin.RouterGroup
+	controller     controllers.WebAuthnController
+	authMiddleware middlewares.JWTMiddleware
+}
+
+func (s WebAuthnRoutes) SetUp() {
+	s.logger.Info("Setting up WebAuthn Routes")
+	webauthn := s.group.Group("/auth/webauthn")
+	{
+		webauthn.POST("/login/begin", s.controller.BeginLogin)
+		webauthn.POST("/login/finish", s.controller.FinishLogin)
+		webauthn.POST("/mfa/begin", s.controller.BeginSecondFactor)
+		webauthn.POST("/mfa/finish", s.controller.FinishSecondFactor)
+	}
+
+	protected := webauthn.Group("", s.authMiddleware.Handler())
+	{
+		protected.POST("/register/begin", s.controller.BeginRegistration)
+		protected.POST("/register/finish", s.controller.FinishRegistration)
+		protected.GET("/credentials", s.controller.ListCredentials)
+		protected.DELETE("/credentials/:id", s.controller.DeleteCredential)
+	}
+}
+
+func NewWebAuthnRoutes(
+	logger lib.Logger,
+	group *gin.RouterGroup,
+	controller controllers.WebAuthnController,
+	authMiddleware middlewares.JWTMiddleware,
+) WebAuthnRoutes {
+	return WebAuthnRoutes{
+		logger:         logger,
+		group:          group,
+		controller:     controller,
+		authMiddleware: authMiddleware,
+	}
+}
@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"diandi-backend/api/controllers"
+	"diandi-backend/lib"
+)
+
+// WellKnownRoutes serves the discovery documents under /.well-known, outside the
+// versioned API so they sit where clients expect them
+type WellKnownRoutes struct {
+	logger     lib.Logger
+	handler    lib.RequestHandler
+	controller controllers.AuthController
+	oauth2     controllers.OAuth2Controller
+}
+
+func (s WellKnownRoutes) SetUp() {
+	s.logger.Info("Setting up Well-Known Routes")
+	wellKnown := s.handler.Gin.Group("/.well-known")
+	{
+		wellKnown.GET("/jwks.json", s.controller.JWKS)
+		wellKnown.GET("/openid-configuration", s.oauth2.Discovery)
+	}
+}
+
+func NewWellKnownRoutes(
+	logger lib.Logger,
+	handler lib.RequestHandler,
+	controller controllers.AuthController,
+	oauth2 controllers.OAuth2Controller,
+) WellKnownRoutes {
+	return WellKnownRoutes{
+		logger:     logger,
+		handler:    handler,
+		controller: controller,
+		oauth2:     oauth2,
+	}
+}
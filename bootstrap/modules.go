@@ -2,8 +2,12 @@ package bootstrap
 
 import (
 	"diandi-backend/api/controllers"
+	"diandi-backend/api/handlers"
+	"diandi-backend/api/middlewares"
 	"diandi-backend/api/routes"
 	"diandi-backend/lib"
+	"diandi-backend/providers"
+	"diandi-backend/repositories"
 	"diandi-backend/services"
 
 	"go.uber.org/fx"
@@ -11,7 +15,11 @@ import (
 
 var CommonModules = fx.Options(
 	lib.Module,
+	providers.Module,
+	repositories.Module,
 	routes.Module,
 	services.Module,
 	controllers.Module,
+	handlers.Module,
+	middlewares.Module,
 )
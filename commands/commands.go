@@ -12,6 +12,11 @@ var cmds = map[string]lib.Command{
 	"app:serve":      NewServeCommand(),
 	"migration:up":   NewMigrationUp(),
 	"migration:down": NewMigrationDown(),
+	"keys:rotate":    NewKeysRotate(),
+	"user:suspend":   NewUserSuspend(),
+	"user:reinstate": NewUserReinstate(),
+	"user:roles":     NewUserRoles(),
+	"apikey:create":  NewAPIKeyCreate(),
 }
 
 func GetSubCommands(opt fx.Option) []*cobra.Command {
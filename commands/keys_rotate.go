@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"context"
+
+	"diandi-backend/lib"
+	"diandi-backend/services"
+
+	"github.com/spf13/cobra"
+)
+
+// KeysRotate introduces a new access token signing key, previous keys stay
+// published in the JWKS until the tokens they signed have expired. The keys are
+// only used with JWT_KEY_SOURCE=database.
+type KeysRotate struct{}
+
+func (kr *KeysRotate) Short() string {
+	return "Rotate the access token signing key"
+}
+
+func (kr *KeysRotate) Setup(cmd *cobra.Command) {}
+
+func (kr *KeysRotate) Run() lib.CommandRunner {
+	return func(env lib.Env, logger lib.Logger, keys services.SigningKeyService) {
+		// Rotating keys the server does not sign with would only look like it worked
+		if env.JWTKeySource != services.DatabaseKeySource {
+			logger.Fatal("keys:rotate requires JWT_KEY_SOURCE=database, the server signs with the JWT_* key from the environment")
+		}
+
+		key, err := keys.Rotate(context.Background())
+		if err != nil {
+			logger.Fatal("Failed to rotate signing key: ", err)
+		}
+		logger.Info("New signing key ", key.ID, " (", key.Algorithm, ")")
+	}
+}
+
+func NewKeysRotate() *KeysRotate {
+	return &KeysRotate{}
+}
@@ -1,7 +1,7 @@
 package commands
 
 import (
-	"github.com/caophuoclong/codifech/lib"
+	"diandi-backend/lib"
 	"github.com/spf13/cobra"
 )
 
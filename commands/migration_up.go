@@ -1,7 +1,11 @@
 package commands
 
 import (
-	"github.com/caophuoclong/codifech/lib"
+	"context"
+
+	"diandi-backend/lib"
+	"diandi-backend/services"
+
 	"github.com/spf13/cobra"
 )
 
@@ -13,7 +17,12 @@ func (mu *MigrationUp) Short() string {
 
 func (mu *MigrationUp) Setup(cmd *cobra.Command) {}
 func (mu *MigrationUp) Run() lib.CommandRunner {
-	return func() {}
+	return func(logger lib.Logger, roles services.RoleService) {
+		if err := roles.SeedDefaultRoles(context.Background()); err != nil {
+			logger.Fatal("Failed to seed default roles: ", err)
+		}
+		logger.Info("Migrations complete")
+	}
 }
 
 func NewMigrationUp() *MigrationUp {
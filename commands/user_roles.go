@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"context"
+
+	"diandi-backend/lib"
+	"diandi-backend/services"
+
+	"github.com/spf13/cobra"
+)
+
+// UserRoles replaces the roles of a user, e.g. to appoint the first administrator
+type UserRoles struct {
+	userID string
+	roles  []string
+}
+
+func (ur *UserRoles) Short() string {
+	return "Assign roles to a user"
+}
+
+func (ur *UserRoles) Setup(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&ur.userID, "user", "", "id of the user")
+	cmd.Flags().StringSliceVar(&ur.roles, "role", nil, "role to assign, repeat for several and omit to remove all")
+	_ = cmd.MarkFlagRequired("user")
+}
+
+func (ur *UserRoles) Run() lib.CommandRunner {
+	return func(logger lib.Logger, roles services.RoleService) {
+		user, err := roles.AssignRoles(context.Background(), ur.userID, ur.roles)
+		if err != nil {
+			logger.Fatal("Failed to assign roles: ", err)
+		}
+		logger.Info("User ", user.ID, " now has roles ", user.Roles)
+	}
+}
+
+func NewUserRoles() *UserRoles {
+	return &UserRoles{}
+}
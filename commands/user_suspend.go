@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"context"
+
+	"diandi-backend/lib"
+	"diandi-backend/services"
+
+	"github.com/spf13/cobra"
+)
+
+// UserSuspend blocks a user from signing in and revokes their outstanding tokens
+type UserSuspend struct {
+	userID string
+}
+
+func (us *UserSuspend) Short() string {
+	return "Suspend a user and revoke their tokens"
+}
+
+func (us *UserSuspend) Setup(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&us.userID, "user", "", "id of the user to suspend")
+	_ = cmd.MarkFlagRequired("user")
+}
+
+func (us *UserSuspend) Run() lib.CommandRunner {
+	return func(logger lib.Logger, users services.UserService) {
+		if err := users.SuspendUser(context.Background(), us.userID); err != nil {
+			logger.Fatal("Failed to suspend user: ", err)
+		}
+		logger.Info("Suspended user ", us.userID)
+	}
+}
+
+func NewUserSuspend() *UserSuspend {
+	return &UserSuspend{}
+}
+
+// UserReinstate lifts the suspension of a user
+type UserReinstate struct {
+	userID string
+}
+
+func (ur *UserReinstate) Short() string {
+	return "Lift the suspension of a user"
+}
+
+func (ur *UserReinstate) Setup(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&ur.userID, "user", "", "id of the user to reinstate")
+	_ = cmd.MarkFlagRequired("user")
+}
+
+func (ur *UserReinstate) Run() lib.CommandRunner {
+	return func(logger lib.Logger, users services.UserService) {
+		if err := users.ReinstateUser(context.Background(), ur.userID); err != nil {
+			logger.Fatal("Failed to reinstate user: ", err)
+		}
+		logger.Info("Reinstated user ", ur.userID)
+	}
+}
+
+func NewUserReinstate() *UserReinstate {
+	return &UserReinstate{}
+}
@@ -0,0 +1,20 @@
+package config
+
+import "os"
+
+// AppleConfig holds the Sign in with Apple key used to generate client secrets
+type AppleConfig struct {
+	TeamID         string
+	KeyID          string
+	PrivateKeyPath string
+}
+
+// LoadAppleConfig loads the Apple key settings from APPLE_TEAM_ID, APPLE_KEY_ID
+// and APPLE_PRIVATE_KEY_PATH, the client id being the Services ID read by LoadOAuthConfig
+func LoadAppleConfig() *AppleConfig {
+	return &AppleConfig{
+		TeamID:         os.Getenv("APPLE_TEAM_ID"),
+		KeyID:          os.Getenv("APPLE_KEY_ID"),
+		PrivateKeyPath: os.Getenv("APPLE_PRIVATE_KEY_PATH"),
+	}
+}
@@ -2,58 +2,69 @@ package config
 
 import (
 	"os"
+	"strings"
 
 	"diandi-backend/domains"
 )
 
 // OAuthConfigs holds all OAuth provider configurations
 type OAuthConfigs struct {
-	Google   *domains.OAuthConfig
-	Facebook *domains.OAuthConfig
+	configs map[domains.OAuthProvider]*domains.OAuthConfig
 }
 
-// LoadOAuthConfigs loads OAuth configurations from environment variables
-func LoadOAuthConfigs() *OAuthConfigs {
-	return &OAuthConfigs{
-		Google: &domains.OAuthConfig{
-			Provider:     domains.GoogleOAuthProvider,
-			ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-			RedirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
-			Scopes: []string{
-				"https://www.googleapis.com/auth/userinfo.email",
-				"https://www.googleapis.com/auth/userinfo.profile",
-			},
-		},
-		Facebook: &domains.OAuthConfig{
-			Provider:     domains.FacebookOAuthProvider,
-			ClientID:     os.Getenv("FACEBOOK_CLIENT_ID"),
-			ClientSecret: os.Getenv("FACEBOOK_CLIENT_SECRET"),
-			RedirectURL:  os.Getenv("FACEBOOK_REDIRECT_URL"),
-			Scopes: []string{
-				"email",
-				"public_profile",
-			},
-		},
+// LoadOAuthConfigs loads OAuth configurations from environment variables.
+// Providers without a client id are considered disabled and left out.
+func LoadOAuthConfigs(providers ...domains.OAuthProvider) *OAuthConfigs {
+	configs := make(map[domains.OAuthProvider]*domains.OAuthConfig)
+
+	for _, provider := range providers {
+		if config := LoadOAuthConfig(provider); config.ClientID != "" {
+			configs[provider] = config
+		}
 	}
+
+	return &OAuthConfigs{configs: configs}
+}
+
+// LoadOAuthConfig loads a provider configuration from the <PROVIDER>_CLIENT_ID,
+// <PROVIDER>_CLIENT_SECRET, <PROVIDER>_REDIRECT_URL and optional <PROVIDER>_SCOPES
+// and <PROVIDER>_ISSUER_URL environment variables
+func LoadOAuthConfig(provider domains.OAuthProvider) *domains.OAuthConfig {
+	prefix := strings.ToUpper(string(provider)) + "_"
+
+	return &domains.OAuthConfig{
+		Provider:     provider,
+		ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		Scopes:       splitList(os.Getenv(prefix + "SCOPES")),
+		IssuerURL:    os.Getenv(prefix + "ISSUER_URL"),
+	}
+}
+
+// LoadOIDCProviders returns the names of the generic OpenID Connect providers
+// listed in OIDC_PROVIDERS, each configured through its own <PROVIDER>_* variables
+func LoadOIDCProviders() []domains.OAuthProvider {
+	var names []domains.OAuthProvider
+	for _, name := range splitList(os.Getenv("OIDC_PROVIDERS")) {
+		names = append(names, domains.OAuthProvider(strings.ToLower(name)))
+	}
+	return names
 }
 
 // GetConfig returns the configuration for a specific provider
 func (c *OAuthConfigs) GetConfig(provider domains.OAuthProvider) *domains.OAuthConfig {
-	switch provider {
-	case domains.GoogleOAuthProvider:
-		return c.Google
-	case domains.FacebookOAuthProvider:
-		return c.Facebook
-	default:
-		return nil
-	}
+	return c.configs[provider]
 }
 
 // GetAllConfigs returns a map of all OAuth configurations
 func (c *OAuthConfigs) GetAllConfigs() map[domains.OAuthProvider]*domains.OAuthConfig {
-	return map[domains.OAuthProvider]*domains.OAuthConfig{
-		domains.GoogleOAuthProvider:   c.Google,
-		domains.FacebookOAuthProvider: c.Facebook,
-	}
+	return c.configs
+}
+
+// splitList splits a comma or space separated environment value
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
 }
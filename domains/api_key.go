@@ -0,0 +1,68 @@
+package domains
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidAPIKey  = errors.New("invalid or expired api key")
+	ErrInvalidScope   = errors.New("scope exceeds the granted scopes")
+
+	ErrAPIKeyCreatorMissing = errors.New("service account keys require the user creating them")
+)
+
+// Owners of API keys
+const (
+	UserAPIKeyOwner    = "user"
+	ServiceAPIKeyOwner = "service"
+)
+
+// APIKeyOwner identifies whom a key acts for: a user by id, or a service account
+// by name
+type APIKeyOwner struct {
+	Type string `json:"type" bson:"type"`
+	ID   string `json:"id" bson:"id"`
+}
+
+// Subject returns the subject of the claims requests made with the key carry.
+// Service accounts are prefixed so they never collide with user ids.
+func (o APIKeyOwner) Subject() string {
+	if o.Type == ServiceAPIKeyOwner {
+		return ServiceAPIKeyOwner + ":" + o.ID
+	}
+	return o.ID
+}
+
+// APIKey is a long lived credential for automation. Only the hash of the key is
+// stored, the prefix stays visible so owners can tell their keys apart. Scopes
+// are permissions, a key never grants more than its grantor currently holds.
+type APIKey struct {
+	ID         string      `json:"id" bson:"_id"`
+	Name       string      `json:"name" bson:"name"`
+	Prefix     string      `json:"prefix" bson:"prefix"`
+	KeyHash    string      `json:"-" bson:"keyHash"`
+	Owner      APIKeyOwner `json:"owner" bson:"owner"`
+	Scopes     []string    `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time   `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  *time.Time  `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	LastUsedAt *time.Time  `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	// CreatedBy is the user who created a service account key
+	CreatedBy string `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+}
+
+// Grantor returns the id of the user whose permissions bound the key's scopes:
+// the owner of a user key, the creator of a service account key
+func (k *APIKey) Grantor() string {
+	if k.Owner.Type == UserAPIKeyOwner {
+		return k.Owner.ID
+	}
+	return k.CreatedBy
+}
+
+// CreatedAPIKey is returned once when a key is created, Key is never shown again
+type CreatedAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
@@ -1,6 +1,116 @@
 package domains
 
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidActionToken = errors.New("invalid or expired token")
+	// ErrReauthenticationRequired asks the user to sign in again before a
+	// sensitive change to their account
+	ErrReauthenticationRequired = errors.New("recent sign in required")
+)
+
+// Purposes of action tokens, each purpose is issued for its own audience so a
+// token minted for one action is never accepted by another or as access token
+const (
+	EmailVerificationPurpose = "email_verification"
+	MFAChallengePurpose      = "mfa_challenge"
+)
+
+// AccessClaims represents the claims carried by access tokens issued by AuthService.
+// Roles and the permissions they grant are resolved when the token is issued.
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// ClientID and Scope are set on tokens issued to OAuth2 clients
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// AuthTime and AMR record how the user of a first-party session signed in
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
+}
+
+// Kinds of principal a token acts for
+const (
+	UserPrincipal    = "user"
+	ServicePrincipal = "service"
+)
+
+// Principal is whom a request acts for. Services call with a token obtained
+// through the client credentials grant or with a service account API key.
+type Principal struct {
+	Type string `json:"type"`
+	// ID is the user id, or the subject of the service
+	ID string `json:"id"`
+}
+
+// FirstParty reports whether the token belongs to a session of our own frontend
+// rather than to an OAuth2 client acting for the user
+func (c *AccessClaims) FirstParty() bool {
+	return c.ClientID == ""
+}
+
+// Principal returns whom the token acts for
+func (c *AccessClaims) Principal() Principal {
+	if (c.ClientID != "" && c.Subject == ClientSubject(c.ClientID)) ||
+		strings.HasPrefix(c.Subject, ServiceAPIKeyOwner+":") {
+		return Principal{Type: ServicePrincipal, ID: c.Subject}
+	}
+	return Principal{Type: UserPrincipal, ID: c.Subject}
+}
+
+// Authentication returns how the user of the session signed in
+func (c *AccessClaims) Authentication() Authentication {
+	auth := Authentication{Methods: c.AMR}
+	if c.AuthTime != nil {
+		auth.Time = c.AuthTime.Time
+	}
+	return auth
+}
+
+// HasPermission reports whether the token grants permission
+func (c *AccessClaims) HasPermission(permission string) bool {
+	return HasPermission(c.Permissions, permission)
+}
+
+// ActionClaims represents the claims of single purpose tokens mailed to users,
+// e.g. to verify an email address
+type ActionClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email,omitempty"`
+}
+
 type AuthService interface {
-	Authorize(tokenString string) (bool, error)
-	CreateToken() string
+	// Authorize verifies the signature, issuer and expiry of an access token and
+	// returns its claims. Tokens for any audience pass, resource servers check
+	// the audience themselves.
+	Authorize(tokenString string) (*AccessClaims, error)
+	// CreateToken issues a signed access token, the subject and custom claims are
+	// taken from claims. Tokens are issued for our own API unless claims names
+	// another audience.
+	CreateToken(claims *AccessClaims) (string, error)
+	// CreateIDToken issues a signed OpenID Connect ID token, the subject,
+	// audience and custom claims are taken from claims. It fails with
+	// ErrOpenIDUnavailable unless SupportsIDTokens.
+	CreateIDToken(claims *IDClaims) (string, error)
+	// SupportsIDTokens reports whether tokens are signed with an asymmetric key
+	// published in the JWKS, which ID tokens require
+	SupportsIDTokens() bool
+	// Issuer returns the issuer of every token signed by the service
+	Issuer() string
+	// Audience returns the audience of access tokens for our own API
+	Audience() string
+	// CreateActionToken issues a signed token for purpose expiring after ttl,
+	// the subject and custom claims are taken from claims
+	CreateActionToken(purpose string, claims *ActionClaims, ttl time.Duration) (string, error)
+	// VerifyActionToken verifies a token issued for purpose and returns its claims
+	VerifyActionToken(purpose string, tokenString string) (*ActionClaims, error)
+	// JWKS returns the public keys downstream services verify access tokens with
+	JWKS() *JSONWebKeySet
 }
@@ -0,0 +1,20 @@
+package domains
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidEmailLogin = errors.New("invalid or expired email login")
+
+// EmailLogin is a pending passwordless sign in. The mailed link token and the
+// six digit code both redeem it once, only their hashes are stored. A new
+// request for the same email replaces the pending one.
+type EmailLogin struct {
+	Email     string    `json:"email" bson:"_id"`
+	TokenHash string    `json:"-" bson:"tokenHash"`
+	CodeHash  string    `json:"-" bson:"codeHash"`
+	Attempts  int       `json:"attempts" bson:"attempts"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
@@ -0,0 +1,50 @@
+package domains
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrMFANotEnabled        = errors.New("multi-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled    = errors.New("multi-factor authentication is already enabled")
+	ErrMFAEnrollmentMissing = errors.New("no multi-factor enrollment in progress")
+	ErrInvalidMFACode       = errors.New("invalid multi-factor code")
+	ErrMFALocked            = errors.New("too many failed multi-factor attempts")
+)
+
+// MFA methods a challenge can be answered with
+const (
+	TOTPMFAMethod     = "totp"
+	WebAuthnMFAMethod = "webauthn"
+)
+
+// MFA is the multi-factor state of a user. Enabled covers TOTP, whose secret is
+// stored encrypted, and recovery codes, stored as hashes removed once used.
+// WebAuthn is set when the user chose their passkeys as second factor.
+type MFA struct {
+	Enabled           bool       `json:"enabled" bson:"enabled"`
+	WebAuthn          bool       `json:"webauthn" bson:"webauthn,omitempty"`
+	EnabledAt         *time.Time `json:"enabledAt,omitempty" bson:"enabledAt,omitempty"`
+	TOTPSecret        []byte     `json:"-" bson:"totpSecret,omitempty"`
+	PendingTOTPSecret []byte     `json:"-" bson:"pendingTotpSecret,omitempty"`
+	LastTOTPStep      int64      `json:"-" bson:"lastTotpStep,omitempty"`
+	RecoveryCodes     []string   `json:"-" bson:"recoveryCodes,omitempty"`
+	FailedAttempts    int        `json:"-" bson:"failedAttempts,omitempty"`
+	LockedUntil       *time.Time `json:"-" bson:"lockedUntil,omitempty"`
+}
+
+// MFAEnrollment is handed to the user to register the TOTP secret with an
+// authenticator app, URI is the otpauth:// payload rendered as QR code
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauthUri"`
+}
+
+// MFAChallenge is returned instead of tokens when sign in needs a second factor,
+// the token is presented together with a code to finish the sign in
+type MFAChallenge struct {
+	MFARequired bool     `json:"mfaRequired"`
+	MFAToken    string   `json:"mfaToken"`
+	Methods     []string `json:"methods"`
+}
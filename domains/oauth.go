@@ -1,6 +1,11 @@
 package domains
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+)
 
 // OAuthProvider represents supported OAuth providers
 type OAuthProvider string
@@ -8,11 +13,14 @@ type OAuthProvider string
 const (
 	GoogleOAuthProvider   OAuthProvider = "google"
 	FacebookOAuthProvider OAuthProvider = "facebook"
+	GithubOAuthProvider   OAuthProvider = "github"
+	AppleOAuthProvider    OAuthProvider = "apple"
 )
 
 // OAuthProfile represents user profile data from OAuth providers
 type OAuthProfile struct {
 	ID            string        `json:"id" bson:"_id,omitempty"`
+	UserID        string        `json:"userId" bson:"userId"`
 	Provider      OAuthProvider `json:"provider" bson:"provider"`
 	ProviderID    string        `json:"providerId" bson:"providerId"`
 	Email         string        `json:"email" bson:"email"`
@@ -22,8 +30,26 @@ type OAuthProfile struct {
 	LastName      string        `json:"lastName" bson:"lastName"`
 	Picture       string        `json:"picture" bson:"picture"`
 	Locale        string        `json:"locale" bson:"locale"`
-	CreatedAt     time.Time     `json:"createdAt" bson:"createdAt"`
-	UpdatedAt     time.Time     `json:"updatedAt" bson:"updatedAt"`
+	// RawClaims keeps the unmodified provider response for auditing
+	RawClaims map[string]interface{} `json:"rawClaims,omitempty" bson:"rawClaims,omitempty"`
+	CreatedAt time.Time              `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time              `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Validate checks that a normalized profile identifies its owner at the provider
+func (p *OAuthProfile) Validate() error {
+	if p.Provider == "" {
+		return errors.New("invalid profile: missing provider")
+	}
+	if p.ProviderID == "" {
+		return errors.New("invalid profile: missing provider id")
+	}
+	if p.Email != "" {
+		if _, err := mail.ParseAddress(p.Email); err != nil {
+			return fmt.Errorf("invalid profile: malformed email %q", p.Email)
+		}
+	}
+	return nil
 }
 
 // OAuthToken represents OAuth access tokens and refresh tokens
@@ -38,6 +64,50 @@ type OAuthToken struct {
 	Scope        string        `json:"scope" bson:"scope"`
 	CreatedAt    time.Time     `json:"createdAt" bson:"createdAt"`
 	UpdatedAt    time.Time     `json:"updatedAt" bson:"updatedAt"`
+
+	// IDToken and Nonce are transient values of the current code exchange used
+	// to verify OpenID Connect ID tokens, they are never persisted
+	IDToken string `json:"-" bson:"-"`
+	Nonce   string `json:"-" bson:"-"`
+}
+
+// OAuthIntent tells whether an authorization request signs a user in or links
+// the provider account to the user who started it
+type OAuthIntent string
+
+const (
+	LoginOAuthIntent OAuthIntent = "login"
+	LinkOAuthIntent  OAuthIntent = "link"
+)
+
+var (
+	ErrInvalidOAuthState  = errors.New("invalid or expired state")
+	ErrReturnToNotAllowed = errors.New("return_to url is not allowed")
+)
+
+// OAuthErrorCode is the stable error code reported to the frontend when a callback fails
+type OAuthErrorCode string
+
+const (
+	InvalidStateOAuthError  OAuthErrorCode = "invalid_state"
+	AccessDeniedOAuthError  OAuthErrorCode = "access_denied"
+	ProviderOAuthError      OAuthErrorCode = "provider_error"
+	AccountLinkedOAuthError OAuthErrorCode = "account_linked"
+	EmailTakenOAuthError    OAuthErrorCode = "email_taken"
+	ServerOAuthError        OAuthErrorCode = "server_error"
+)
+
+// OAuthState carries the values bound to an authorization request until its callback
+type OAuthState struct {
+	State        string        `json:"state" bson:"_id"`
+	Provider     OAuthProvider `json:"provider" bson:"provider"`
+	CodeVerifier string        `json:"codeVerifier" bson:"codeVerifier"`
+	Nonce        string        `json:"nonce" bson:"nonce"`
+	Intent       OAuthIntent   `json:"intent" bson:"intent"`
+	UserID       string        `json:"userId,omitempty" bson:"userId,omitempty"`
+	ReturnTo     string        `json:"returnTo,omitempty" bson:"returnTo,omitempty"`
+	CreatedAt    time.Time     `json:"createdAt" bson:"createdAt"`
+	ExpiresAt    time.Time     `json:"expiresAt" bson:"expiresAt"`
 }
 
 // OAuthConfig represents OAuth provider configuration
@@ -47,4 +117,5 @@ type OAuthConfig struct {
 	ClientSecret string        `json:"clientSecret" bson:"clientSecret"`
 	RedirectURL  string        `json:"redirectUrl" bson:"redirectUrl"`
 	Scopes       []string      `json:"scopes" bson:"scopes"`
+	IssuerURL    string        `json:"issuerUrl,omitempty" bson:"issuerUrl,omitempty"`
 }
@@ -0,0 +1,211 @@
+package domains
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrClientNotFound              = errors.New("oauth2 client not found")
+	ErrInvalidClientMetadata       = errors.New("invalid oauth2 client metadata")
+	ErrAuthorizationRequestMissing = errors.New("authorization request not found or expired")
+	ErrInvalidAuthorizationCode    = errors.New("invalid or expired authorization code")
+	ErrConsentNotFound             = errors.New("consent not found")
+)
+
+// Grant types accepted by the token endpoint
+const (
+	AuthorizationCodeGrant = "authorization_code"
+	RefreshTokenGrant      = "refresh_token"
+	ClientCredentialsGrant = "client_credentials"
+)
+
+// clientSubjectPrefix marks the subject of tokens a client obtained for itself
+// with the client credentials grant, so it never collides with user ids
+const clientSubjectPrefix = "client:"
+
+// ClientSubject returns the subject of the tokens a client obtains for itself
+func ClientSubject(clientID string) string {
+	return clientSubjectPrefix + clientID
+}
+
+// OAuth2 error codes of RFC 6749
+const (
+	InvalidRequestError          = "invalid_request"
+	InvalidClientError           = "invalid_client"
+	InvalidGrantError            = "invalid_grant"
+	UnauthorizedClientError      = "unauthorized_client"
+	UnsupportedGrantTypeError    = "unsupported_grant_type"
+	UnsupportedResponseTypeError = "unsupported_response_type"
+	InvalidScopeError            = "invalid_scope"
+	AccessDeniedError            = "access_denied"
+	// InvalidTargetError of RFC 8707 rejects an audience the client may not address
+	InvalidTargetError = "invalid_target"
+)
+
+// Token type hints of the introspection and revocation endpoints
+const (
+	AccessTokenHint  = "access_token"
+	RefreshTokenHint = "refresh_token"
+)
+
+// OAuth2Error is an error reported to clients of the authorization server. When
+// RedirectURI is set the error is delivered to the client by redirect.
+type OAuth2Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	RedirectURI string `json:"-"`
+	State       string `json:"-"`
+}
+
+func (e *OAuth2Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// OAuth2Client is an application signing users in through us. Public clients,
+// such as mobile and single page apps, cannot keep a secret and authenticate
+// with PKCE alone. Only the hash of a confidential client's secret is stored.
+type OAuth2Client struct {
+	ID           string   `json:"clientId" bson:"_id"`
+	Name         string   `json:"name" bson:"name"`
+	SecretHash   string   `json:"-" bson:"secretHash,omitempty"`
+	Public       bool     `json:"public" bson:"public"`
+	RedirectURIs []string `json:"redirectUris" bson:"redirectUris"`
+	Scopes       []string `json:"scopes" bson:"scopes"`
+	GrantTypes   []string `json:"grantTypes" bson:"grantTypes"`
+	// Audiences are the services a machine client may obtain tokens for
+	Audiences []string `json:"audiences,omitempty" bson:"audiences,omitempty"`
+	// FirstParty clients are our own apps, users are not asked for consent
+	FirstParty bool `json:"firstParty" bson:"firstParty"`
+	// ResourceServer clients are our APIs, they may introspect every access token
+	ResourceServer bool      `json:"resourceServer" bson:"resourceServer,omitempty"`
+	CreatedAt      time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// AllowsGrant reports whether the client may use grantType
+func (c *OAuth2Client) AllowsGrant(grantType string) bool {
+	for _, allowed := range c.GrantTypes {
+		if allowed == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisteredClient is returned once when a client is registered, the secret of
+// a confidential client is never shown again
+type RegisteredClient struct {
+	*OAuth2Client
+	Secret string `json:"clientSecret,omitempty"`
+}
+
+// AuthorizationParams holds the parameters of an authorization endpoint request
+type AuthorizationParams struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthorizationRequest is a validated request to /oauth2/authorize waiting for
+// the user to sign in and decide on consent in the frontend
+type AuthorizationRequest struct {
+	ID                  string    `json:"id" bson:"_id"`
+	ClientID            string    `json:"clientId" bson:"clientId"`
+	RedirectURI         string    `json:"redirectUri" bson:"redirectUri"`
+	Scopes              []string  `json:"scopes" bson:"scopes"`
+	State               string    `json:"-" bson:"state,omitempty"`
+	Nonce               string    `json:"-" bson:"nonce,omitempty"`
+	CodeChallenge       string    `json:"-" bson:"codeChallenge"`
+	CodeChallengeMethod string    `json:"-" bson:"codeChallengeMethod"`
+	CreatedAt           time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt           time.Time `json:"expiresAt" bson:"expiresAt"`
+
+	// RedirectURIDefaulted is set when the client omitted redirect_uri and its
+	// only registered one was used, the token request may then omit it as well
+	RedirectURIDefaulted bool `json:"-" bson:"redirectUriDefaulted,omitempty"`
+}
+
+// AuthorizationPrompt describes a pending authorization request to the signed in
+// user, the frontend asks for consent when ConsentRequired is set
+type AuthorizationPrompt struct {
+	RequestID       string   `json:"requestId"`
+	ClientID        string   `json:"clientId"`
+	ClientName      string   `json:"clientName"`
+	Scopes          []string `json:"scopes"`
+	ConsentRequired bool     `json:"consentRequired"`
+}
+
+// AuthorizationCode is the single use code redeemed by a client at the token
+// endpoint. Only its hash is stored.
+type AuthorizationCode struct {
+	CodeHash            string   `json:"-" bson:"_id"`
+	ClientID            string   `json:"clientId" bson:"clientId"`
+	UserID              string   `json:"userId" bson:"userId"`
+	RedirectURI         string   `json:"redirectUri" bson:"redirectUri"`
+	Scopes              []string `json:"scopes" bson:"scopes"`
+	Nonce               string   `json:"-" bson:"nonce,omitempty"`
+	CodeChallenge       string   `json:"-" bson:"codeChallenge"`
+	CodeChallengeMethod string   `json:"-" bson:"codeChallengeMethod"`
+	// Authentication of the session that approved the request, for the ID token
+	Authentication Authentication `json:"authentication" bson:"authentication"`
+	CreatedAt      time.Time      `json:"createdAt" bson:"createdAt"`
+	ExpiresAt      time.Time      `json:"expiresAt" bson:"expiresAt"`
+
+	// RedirectURIDefaulted is carried over from the authorization request
+	RedirectURIDefaulted bool `json:"-" bson:"redirectUriDefaulted,omitempty"`
+}
+
+// OAuth2Consent records the scopes a user granted a client, later requests for
+// the same or fewer scopes skip the consent prompt
+type OAuth2Consent struct {
+	ID        string    `json:"-" bson:"_id"`
+	UserID    string    `json:"userId" bson:"userId"`
+	ClientID  string    `json:"clientId" bson:"clientId"`
+	Scopes    []string  `json:"scopes" bson:"scopes"`
+	GrantedAt time.Time `json:"grantedAt" bson:"grantedAt"`
+}
+
+// TokenRequest holds the parameters of a token endpoint request
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+	Audience     string
+}
+
+// TokenResponse is the RFC 6749 token endpoint response
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// IntrospectionResponse is the RFC 7662 introspection response. Tokens that are
+// unknown, expired, revoked or not visible to the client only report inactive.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
@@ -0,0 +1,100 @@
+package domains
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInsufficientScope = errors.New("token lacks the required scope")
+	// ErrOpenIDUnavailable is returned while tokens are signed with a shared
+	// secret, ID tokens have to be verifiable by clients through the JWKS
+	ErrOpenIDUnavailable = errors.New("openid connect requires an asymmetric signing key")
+)
+
+// Scopes of OpenID Connect, profile and email select the claims of /userinfo
+const (
+	OpenIDScope  = "openid"
+	ProfileScope = "profile"
+	EmailScope   = "email"
+)
+
+// Authentication method references (RFC 8176) recorded when a user signs in.
+// FederatedMethod, a sign in through an external provider, is not registered.
+const (
+	PasswordMethod    = "pwd"
+	OTPMethod         = "otp"
+	HardwareKeyMethod = "hwk"
+	MultiFactorMethod = "mfa"
+	FederatedMethod   = "fed"
+)
+
+// Authentication context class references reported in ID tokens
+const (
+	SingleFactorACR = "urn:diandi:acr:sfa"
+	MultiFactorACR  = "urn:diandi:acr:mfa"
+)
+
+// Authentication records when and how the user of a session signed in. It is
+// kept for the lifetime of the session, refreshed tokens keep the original.
+type Authentication struct {
+	Time    time.Time `json:"time" bson:"time"`
+	Methods []string  `json:"methods" bson:"methods,omitempty"`
+}
+
+// ACR returns the authentication context class reached by the methods. Passkeys
+// verify the user on the device and count as multi-factor.
+func (a Authentication) ACR() string {
+	for _, method := range a.Methods {
+		if method == MultiFactorMethod || method == HardwareKeyMethod {
+			return MultiFactorACR
+		}
+	}
+	return SingleFactorACR
+}
+
+// IDClaims represents the claims of ID tokens issued to OpenID Connect clients
+type IDClaims struct {
+	jwt.RegisteredClaims
+	AuthorizedParty string           `json:"azp,omitempty"`
+	Nonce           string           `json:"nonce,omitempty"`
+	AuthTime        *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR             []string         `json:"amr,omitempty"`
+	ACR             string           `json:"acr,omitempty"`
+}
+
+// UserInfo is the /userinfo response, claims beyond sub depend on the granted scopes
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+	Picture       string `json:"picture,omitempty"`
+	Locale        string `json:"locale,omitempty"`
+	UpdatedAt     int64  `json:"updated_at,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// OpenIDConfiguration is the document served at /.well-known/openid-configuration
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	ACRValuesSupported                []string `json:"acr_values_supported"`
+}
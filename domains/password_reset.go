@@ -0,0 +1,17 @@
+package domains
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// PasswordResetToken is a short lived, single use token mailed to a user who
+// forgot their password. Only its hash is stored.
+type PasswordResetToken struct {
+	TokenHash string    `json:"-" bson:"_id"`
+	UserID    string    `json:"userId" bson:"userId"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
@@ -0,0 +1,14 @@
+package domains
+
+import "time"
+
+// RevokedToken denylists access tokens until they would have expired anyway.
+// An entry either names a single token by its jti or, without TokenID, covers
+// every token issued to UserID up to RevokedAt.
+type RevokedToken struct {
+	ID        string    `json:"id" bson:"_id"`
+	TokenID   string    `json:"tokenId,omitempty" bson:"tokenId,omitempty"`
+	UserID    string    `json:"userId" bson:"userId"`
+	RevokedAt time.Time `json:"revokedAt" bson:"revokedAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
@@ -0,0 +1,65 @@
+package domains
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrRoleNotFound = errors.New("role not found")
+
+// Default roles seeded by the migrations
+const (
+	AdminRole   = "admin"
+	SupportRole = "support"
+)
+
+// Permissions are named "<resource>:<action>". A grant of "<resource>:*" covers
+// every action on the resource and "*" covers everything.
+const (
+	UsersReadPermission    = "users:read"
+	UsersWritePermission   = "users:write"
+	RolesReadPermission    = "roles:read"
+	RolesWritePermission   = "roles:write"
+	ClientsReadPermission  = "clients:read"
+	ClientsWritePermission = "clients:write"
+
+	AllPermissions = "*"
+)
+
+// Role is a named set of permissions assigned to users. The permissions of the
+// user's roles are embedded in the access tokens issued to them.
+type Role struct {
+	Name        string    `json:"name" bson:"_id"`
+	Description string    `json:"description" bson:"description"`
+	Permissions []string  `json:"permissions" bson:"permissions"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// DefaultRoles returns the roles every installation starts with
+func DefaultRoles() []*Role {
+	return []*Role{
+		{
+			Name:        AdminRole,
+			Description: "Full access to every resource",
+			Permissions: []string{AllPermissions},
+		},
+		{
+			Name:        SupportRole,
+			Description: "Read access to user accounts",
+			Permissions: []string{UsersReadPermission, RolesReadPermission},
+		},
+	}
+}
+
+// HasPermission reports whether the granted permissions cover required
+func HasPermission(granted []string, required string) bool {
+	resource, _, _ := strings.Cut(required, ":")
+	for _, permission := range granted {
+		if permission == required || permission == AllPermissions || permission == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
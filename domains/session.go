@@ -0,0 +1,59 @@
+package domains
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidLoginCode    = errors.New("invalid or expired login code")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// LoginCode is a short lived, single use code handed to the frontend after a
+// provider callback and exchanged for our own tokens. Only its hash is stored.
+type LoginCode struct {
+	CodeHash  string    `json:"-" bson:"_id"`
+	UserID    string    `json:"userId" bson:"userId"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// TokenPair is the set of tokens issued to a client for a signed in user
+type TokenPair struct {
+	AccessToken  string   `json:"accessToken"`
+	TokenType    string   `json:"tokenType"`
+	ExpiresIn    int64    `json:"expiresIn"`
+	RefreshToken string   `json:"refreshToken,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+// ClientGrant describes the tokens issued to an OAuth2 client on behalf of a
+// user, limited to the scopes the user granted
+type ClientGrant struct {
+	UserID         string
+	ClientID       string
+	Scopes         []string
+	Authentication Authentication
+	// Refreshable grants also receive a refresh token bound to the client
+	Refreshable bool
+}
+
+// RefreshToken is an opaque, single use token exchanged for a new token pair.
+// Tokens rotated from one another share a family, replaying a rotated token
+// revokes the whole family. Only its hash is stored. Tokens issued to an OAuth2
+// client are bound to it and only refresh at the token endpoint.
+type RefreshToken struct {
+	TokenHash string   `json:"-" bson:"_id"`
+	FamilyID  string   `json:"familyId" bson:"familyId"`
+	UserID    string   `json:"userId" bson:"userId"`
+	ClientID  string   `json:"clientId,omitempty" bson:"clientId,omitempty"`
+	Scopes    []string `json:"scopes,omitempty" bson:"scopes,omitempty"`
+	// Authentication of the sign in that started the family
+	Authentication Authentication `json:"authentication" bson:"authentication"`
+	CreatedAt      time.Time      `json:"createdAt" bson:"createdAt"`
+	ExpiresAt      time.Time      `json:"expiresAt" bson:"expiresAt"`
+	RotatedAt      *time.Time     `json:"rotatedAt,omitempty" bson:"rotatedAt,omitempty"`
+	RevokedAt      *time.Time     `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
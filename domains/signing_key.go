@@ -0,0 +1,37 @@
+package domains
+
+import "time"
+
+// SigningKey is an asymmetric key used to sign access tokens. The private key is
+// stored PKCS#8 encoded and encrypted, the key stays published for verification
+// until PublishUntil once a newer key replaced it.
+type SigningKey struct {
+	ID           string     `json:"kid" bson:"_id"`
+	Algorithm    string     `json:"alg" bson:"algorithm"`
+	PrivateKey   []byte     `json:"-" bson:"privateKey"`
+	CreatedAt    time.Time  `json:"createdAt" bson:"createdAt"`
+	RetiredAt    *time.Time `json:"retiredAt,omitempty" bson:"retiredAt,omitempty"`
+	PublishUntil *time.Time `json:"publishUntil,omitempty" bson:"publishUntil,omitempty"`
+	// Initial marks the key created on first startup, only one can exist
+	Initial bool `json:"-" bson:"initial,omitempty"`
+
+	// Key is the decrypted private key, never persisted
+	Key interface{} `json:"-" bson:"-"`
+}
+
+// JSONWebKey is the public half of a signing key as published in the JWKS document
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet is the document served at /.well-known/jwks.json
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
@@ -0,0 +1,39 @@
+package domains
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrProfileNotFound = errors.New("profile not found")
+	ErrAccountLinked   = errors.New("account is already linked to another user")
+	ErrUserSuspended   = errors.New("user is suspended")
+
+	ErrInvalidCredentials   = errors.New("invalid email or password")
+	ErrEmailAlreadyVerified = errors.New("email is already verified")
+	ErrEmailMissing         = errors.New("user has no email address")
+	ErrEmailTaken           = errors.New("email belongs to another user")
+)
+
+// User represents an account of our own, owning zero or more linked OAuth profiles
+type User struct {
+	ID            string    `json:"id" bson:"_id,omitempty"`
+	Email         string    `json:"email" bson:"email"`
+	EmailVerified bool      `json:"emailVerified" bson:"emailVerified"`
+	Name          string    `json:"name" bson:"name"`
+	FirstName     string    `json:"firstName" bson:"firstName"`
+	LastName      string    `json:"lastName" bson:"lastName"`
+	Picture       string    `json:"picture" bson:"picture"`
+	Locale        string    `json:"locale" bson:"locale"`
+	PasswordHash  string    `json:"-" bson:"passwordHash,omitempty"`
+	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt" bson:"updatedAt"`
+
+	Roles []string `json:"roles" bson:"roles,omitempty"`
+	MFA   MFA      `json:"mfa" bson:"mfa"`
+
+	// SuspendedAt is set while the account is suspended, suspended users cannot sign in
+	SuspendedAt *time.Time `json:"suspendedAt,omitempty" bson:"suspendedAt,omitempty"`
+}
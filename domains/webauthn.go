@@ -0,0 +1,55 @@
+package domains
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrWebAuthnDisabled       = errors.New("passkeys are not configured")
+	ErrInvalidWebAuthnSession = errors.New("invalid or expired passkey ceremony")
+	ErrWebAuthnFailed         = errors.New("passkey verification failed")
+	ErrCredentialNotFound     = errors.New("passkey not found")
+)
+
+// WebAuthn ceremonies, a session started for one kind is never finished as another
+const (
+	WebAuthnRegistration = "registration"
+	WebAuthnLogin        = "login"
+	WebAuthnSecondFactor = "second_factor"
+)
+
+// WebAuthnCredential is a passkey registered by a user. The sign counter is kept
+// to detect cloned authenticators.
+type WebAuthnCredential struct {
+	ID              string     `json:"id" bson:"_id"`
+	UserID          string     `json:"userId" bson:"userId"`
+	Name            string     `json:"name" bson:"name"`
+	PublicKey       []byte     `json:"-" bson:"publicKey"`
+	AttestationType string     `json:"-" bson:"attestationType"`
+	Transports      []string   `json:"transports" bson:"transports"`
+	AAGUID          []byte     `json:"-" bson:"aaguid"`
+	SignCount       uint32     `json:"-" bson:"signCount"`
+	BackupEligible  bool       `json:"backupEligible" bson:"backupEligible"`
+	BackupState     bool       `json:"backupState" bson:"backupState"`
+	CreatedAt       time.Time  `json:"createdAt" bson:"createdAt"`
+	LastUsedAt      *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+}
+
+// WebAuthnSession keeps the challenge of a ceremony between its begin and finish
+// steps, Data holds the serialized session of the WebAuthn library
+type WebAuthnSession struct {
+	ID        string    `json:"-" bson:"_id"`
+	Ceremony  string    `json:"ceremony" bson:"ceremony"`
+	UserID    string    `json:"userId,omitempty" bson:"userId,omitempty"`
+	Data      []byte    `json:"-" bson:"data"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// WebAuthnCeremony is returned by the begin step of a ceremony, Options are
+// passed as is to navigator.credentials.create or get
+type WebAuthnCeremony struct {
+	SessionID string      `json:"sessionId"`
+	Options   interface{} `json:"options"`
+}
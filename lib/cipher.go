@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrCipherNotConfigured = errors.New("ENCRYPTION_KEY is not configured")
+
+// Cipher encrypts secrets stored at rest with AES-256-GCM using ENCRYPTION_KEY
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(env Env) (Cipher, error) {
+	if env.EncryptionKey == "" {
+		return Cipher{}, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(env.EncryptionKey)
+	if err != nil || len(key) != 32 {
+		return Cipher{}, errors.New("ENCRYPTION_KEY must be 32 base64 encoded bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return Cipher{}, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return Cipher{}, err
+	}
+
+	return Cipher{aead: aead}, nil
+}
+
+// Encrypt seals plaintext, the random nonce is prepended to the result
+func (c Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	if c.aead == nil {
+		return nil, ErrCipherNotConfigured
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens a value produced by Encrypt
+func (c Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if c.aead == nil {
+		return nil, ErrCipherNotConfigured
+	}
+
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return plaintext, nil
+}
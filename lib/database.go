@@ -1 +1,36 @@
 package lib
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/fx"
+)
+
+const defaultMongoURI = "mongodb://localhost:27017"
+
+type Database struct {
+	*mongo.Database
+}
+
+func NewDatabase(lc fx.Lifecycle, env Env, logger Logger) (Database, error) {
+	uri := env.MongoDBURI
+	if uri == "" {
+		uri = defaultMongoURI
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		return Database{}, err
+	}
+
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			logger.Info("Disconnecting from MongoDB")
+			return client.Disconnect(ctx)
+		},
+	})
+
+	return Database{Database: client.Database(env.MongoDBDatabase)}, nil
+}
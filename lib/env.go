@@ -3,10 +3,66 @@ package lib
 import (
 	"github.com/spf13/viper"
 	"log"
+	"time"
 )
 
+// DevelopmentEnvironment is the ENV of local setups, which may do without real
+// infrastructure such as a mail server
+const DevelopmentEnvironment = "development"
+
 type Env struct {
-	ServerPort string `mapstructure:"SERVER_PORT"`
+	Environment string `mapstructure:"ENV"`
+	ServerPort  string `mapstructure:"SERVER_PORT"`
+	PublicURL   string `mapstructure:"PUBLIC_URL"`
+	FrontendURL string `mapstructure:"FRONTEND_URL"`
+
+	MongoDBURI      string `mapstructure:"MONGODB_URI"`
+	MongoDBDatabase string `mapstructure:"MONGODB_DATABASE"`
+
+	JWTSecret         string        `mapstructure:"JWT_SECRET"`
+	JWTExpiration     time.Duration `mapstructure:"JWT_EXPIRATION"`
+	JWTAlgorithm      string        `mapstructure:"JWT_ALGORITHM"`
+	JWTPrivateKeyPath string        `mapstructure:"JWT_PRIVATE_KEY_PATH"`
+	JWTIssuer         string        `mapstructure:"JWT_ISSUER"`
+	JWTAudience       string        `mapstructure:"JWT_AUDIENCE"`
+	JWTKeySource      string        `mapstructure:"JWT_KEY_SOURCE"`
+
+	EncryptionKey string `mapstructure:"ENCRYPTION_KEY"`
+
+	PasswordHashMemory      uint32 `mapstructure:"PASSWORD_HASH_MEMORY"`
+	PasswordHashIterations  uint32 `mapstructure:"PASSWORD_HASH_ITERATIONS"`
+	PasswordHashParallelism uint8  `mapstructure:"PASSWORD_HASH_PARALLELISM"`
+
+	RefreshTokenExpiration time.Duration `mapstructure:"REFRESH_TOKEN_EXPIRATION"`
+
+	MailTransport string `mapstructure:"MAIL_TRANSPORT"`
+	MailFrom      string `mapstructure:"MAIL_FROM"`
+	MailDir       string `mapstructure:"MAIL_DIR"`
+	SMTPHost      string `mapstructure:"SMTP_HOST"`
+	SMTPPort      string `mapstructure:"SMTP_PORT"`
+	SMTPUsername  string `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword  string `mapstructure:"SMTP_PASSWORD"`
+
+	EmailVerificationTTL         time.Duration `mapstructure:"EMAIL_VERIFICATION_TTL"`
+	EmailVerificationRedirectURL string        `mapstructure:"EMAIL_VERIFICATION_REDIRECT_URL"`
+
+	PasswordResetTTL time.Duration `mapstructure:"PASSWORD_RESET_TTL"`
+	PasswordResetURL string        `mapstructure:"PASSWORD_RESET_URL"`
+
+	EmailLoginTTL time.Duration `mapstructure:"EMAIL_LOGIN_TTL"`
+	EmailLoginURL string        `mapstructure:"EMAIL_LOGIN_URL"`
+
+	MFAIssuer string `mapstructure:"MFA_ISSUER"`
+
+	WebAuthnRPID      string `mapstructure:"WEBAUTHN_RP_ID"`
+	WebAuthnRPName    string `mapstructure:"WEBAUTHN_RP_NAME"`
+	WebAuthnRPOrigins string `mapstructure:"WEBAUTHN_RP_ORIGINS"`
+
+	OAuthStateStore        string        `mapstructure:"OAUTH_STATE_STORE"`
+	OAuthStateTTL          time.Duration `mapstructure:"OAUTH_STATE_TTL"`
+	OAuthReturnToAllowlist string        `mapstructure:"OAUTH_RETURN_TO_ALLOWLIST"`
+
+	OAuth2ConsentURL string `mapstructure:"OAUTH2_CONSENT_URL"`
 }
 
 func NewEnv() Env {
@@ -26,3 +82,8 @@ func NewEnv() Env {
 
 	return env
 }
+
+// Development reports whether the server runs in a local development setup
+func (e Env) Development() bool {
+	return e.Environment == DevelopmentEnvironment
+}
@@ -6,5 +6,8 @@ var Module = fx.Options(
 	fx.Provide(GetLogger),
 	fx.Provide(NewEnv),
 	fx.Provide(NewRequestHandler),
+	fx.Provide(NewDatabase),
+	fx.Provide(NewCipher),
+	fx.Provide(NewMailer),
 
 )
@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LogMailer logs emails instead of sending them and, when given a directory,
+// also writes each one there as an .eml file that mail clients can open. Only
+// the recipient and subject get logged, bodies carry sign in links and tokens.
+type LogMailer struct {
+	logger Logger
+	from   string
+	dir    string
+}
+
+func NewLogMailer(env Env, logger Logger, dir string) *LogMailer {
+	from := env.MailFrom
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+
+	return &LogMailer{
+		logger: logger,
+		from:   from,
+		dir:    dir,
+	}
+}
+
+func (m *LogMailer) Send(ctx context.Context, message Message) error {
+	m.logger.Info("Email to ", message.To, ": ", message.Subject)
+
+	if m.dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	if err := os.WriteFile(filepath.Join(m.dir, name), formatMessage(m.from, message), 0o644); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	SMTPMailTransport = "smtp"
+	FileMailTransport = "file"
+	LogMailTransport  = "log"
+
+	defaultMailDir = "tmp/mail"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewMailer creates the mail transport selected by MAIL_TRANSPORT. The file and
+// log transports deliver nothing and are meant for local development, which is
+// also the only environment allowed to leave the transport unset.
+func NewMailer(env Env, logger Logger) (Mailer, error) {
+	switch env.MailTransport {
+	case SMTPMailTransport:
+		return NewSMTPMailer(env)
+	case FileMailTransport:
+		dir := env.MailDir
+		if dir == "" {
+			dir = defaultMailDir
+		}
+		return NewLogMailer(env, logger, dir), nil
+	case "":
+		if !env.Development() {
+			return nil, fmt.Errorf("MAIL_TRANSPORT must be set outside %s", DevelopmentEnvironment)
+		}
+		return NewLogMailer(env, logger, ""), nil
+	case LogMailTransport:
+		return NewLogMailer(env, logger, ""), nil
+	default:
+		return nil, fmt.Errorf("unsupported mail transport: %s", env.MailTransport)
+	}
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPMailer sends emails through an SMTP relay, upgrading to TLS with STARTTLS
+// when the server offers it
+type SMTPMailer struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(env Env) (*SMTPMailer, error) {
+	if env.SMTPHost == "" || env.MailFrom == "" {
+		return nil, errors.New("SMTP_HOST and MAIL_FROM are required for the smtp mail transport")
+	}
+
+	port := env.SMTPPort
+	if port == "" {
+		port = "587"
+	}
+
+	mailer := &SMTPMailer{
+		addr: net.JoinHostPort(env.SMTPHost, port),
+		host: env.SMTPHost,
+		from: env.MailFrom,
+	}
+	if env.SMTPUsername != "" {
+		mailer.auth = smtp.PlainAuth("", env.SMTPUsername, env.SMTPPassword, env.SMTPHost)
+	}
+
+	return mailer, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	// net/smtp is not context aware, run it aside so callers are not held past their deadline
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, m.from, []string{message.To}, formatMessage(m.from, message))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to send email: %w", ctx.Err())
+	}
+}
+
+// formatMessage renders an RFC 5322 message with a plain text body
+func formatMessage(from string, message Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + sanitizeHeader(from) + "\r\n")
+	b.WriteString("To: " + sanitizeHeader(message.To) + "\r\n")
+	b.WriteString("Subject: " + sanitizeHeader(message.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// sanitizeHeader strips line breaks so values cannot inject extra headers
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -0,0 +1,190 @@
+package providers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+
+	"diandi-backend/config"
+	"diandi-backend/domains"
+)
+
+const (
+	appleIssuer    = "https://appleid.apple.com"
+	appleAuthURL   = "https://appleid.apple.com/auth/authorize"
+	appleTokenURL  = "https://appleid.apple.com/auth/token"
+	appleRevokeURL = "https://appleid.apple.com/auth/revoke"
+	appleKeysURL   = "https://appleid.apple.com/auth/keys"
+
+	// Apple accepts client secrets valid for up to six months, we renew them well before
+	appleClientSecretTTL = 24 * time.Hour
+)
+
+type appleProvider struct {
+	settings *config.AppleConfig
+	verifier *oidc.IDTokenVerifier
+
+	mu        sync.Mutex
+	key       *ecdsa.PrivateKey
+	secret    string
+	secretExp time.Time
+}
+
+// appleUser is the user form field Apple posts to the callback on first sign in
+// only. It is not signed, its email is never trusted over the ID token.
+type appleUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+}
+
+// NewAppleProvider creates the Sign in with Apple identity provider
+func NewAppleProvider() Provider {
+	clientID := config.LoadOAuthConfig(domains.AppleOAuthProvider).ClientID
+	keySet := oidc.NewRemoteKeySet(context.Background(), appleKeysURL)
+
+	return &appleProvider{
+		settings: config.LoadAppleConfig(),
+		verifier: oidc.NewVerifier(appleIssuer, keySet, &oidc.Config{ClientID: clientID}),
+	}
+}
+
+func (p *appleProvider) Name() domains.OAuthProvider {
+	return domains.AppleOAuthProvider
+}
+
+func (p *appleProvider) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:   appleAuthURL,
+		TokenURL:  appleTokenURL,
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+}
+
+func (p *appleProvider) DefaultScopes() []string {
+	return []string{"name", "email"}
+}
+
+// AuthURLOptions requests form_post, which Apple requires whenever scopes are asked for
+func (p *appleProvider) AuthURLOptions() []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("response_mode", "form_post"),
+	}
+}
+
+// ClientSecret returns an ES256 signed JWT identifying our team and Services ID
+func (p *appleProvider) ClientSecret(config *domains.OAuthConfig) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.secret != "" && now.Before(p.secretExp.Add(-time.Hour)) {
+		return p.secret, nil
+	}
+
+	if p.key == nil {
+		pem, err := os.ReadFile(p.settings.PrivateKeyPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read apple private key: %w", err)
+		}
+		key, err := jwt.ParseECPrivateKeyFromPEM(pem)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse apple private key: %w", err)
+		}
+		p.key = key
+	}
+
+	exp := now.Add(appleClientSecretTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    p.settings.TeamID,
+		Subject:   config.ClientID,
+		Audience:  jwt.ClaimStrings{appleIssuer},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(exp),
+	})
+	token.Header["kid"] = p.settings.KeyID
+
+	secret, err := token.SignedString(p.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign apple client secret: %w", err)
+	}
+
+	p.secret = secret
+	p.secretExp = exp
+
+	return secret, nil
+}
+
+func (p *appleProvider) VerifyIDToken(ctx context.Context, token *domains.OAuthToken) error {
+	_, _, err := verifyIDToken(ctx, p.verifier, token)
+	return err
+}
+
+// FetchProfile builds the profile from the ID token, Apple has no userinfo endpoint
+func (p *appleProvider) FetchProfile(ctx context.Context, token *domains.OAuthToken) (*domains.OAuthProfile, error) {
+	claims, raw, err := verifyIDToken(ctx, p.verifier, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims.profile(domains.AppleOAuthProvider, raw), nil
+}
+
+// ProfileFromCallback fills the name Apple only posts on the first authorization
+func (p *appleProvider) ProfileFromCallback(profile *domains.OAuthProfile, params url.Values) error {
+	data := params.Get("user")
+	if data == "" {
+		return nil
+	}
+
+	var user appleUser
+	if err := json.Unmarshal([]byte(data), &user); err != nil {
+		return fmt.Errorf("failed to parse apple user: %w", err)
+	}
+
+	if profile.FirstName == "" {
+		profile.FirstName = user.Name.FirstName
+	}
+	if profile.LastName == "" {
+		profile.LastName = user.Name.LastName
+	}
+	if profile.Name == "" {
+		profile.Name = strings.TrimSpace(profile.FirstName + " " + profile.LastName)
+	}
+
+	return nil
+}
+
+func (p *appleProvider) RevokeToken(ctx context.Context, config *domains.OAuthConfig, token *domains.OAuthToken) error {
+	secret, err := p.ClientSecret(config)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"client_id":       {config.ClientID},
+		"client_secret":   {secret},
+		"token":           {token.AccessToken},
+		"token_type_hint": {"access_token"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", appleRevokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create revoke request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return doRevoke(req)
+}
@@ -0,0 +1,93 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/facebook"
+
+	"diandi-backend/domains"
+)
+
+const (
+	facebookProfileURL = "https://graph.facebook.com/v12.0/me?fields=id,email,name,first_name,last_name,picture,locale"
+	facebookRevokeURL  = "https://graph.facebook.com/v12.0/me/permissions"
+)
+
+type facebookProvider struct{}
+
+// facebookUser is the response of the Graph API /me endpoint
+type facebookUser struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Locale    string `json:"locale"`
+	Picture   struct {
+		Data struct {
+			URL string `json:"url"`
+		} `json:"data"`
+	} `json:"picture"`
+}
+
+// NewFacebookProvider creates the Facebook identity provider
+func NewFacebookProvider() Provider {
+	return &facebookProvider{}
+}
+
+func (p *facebookProvider) Name() domains.OAuthProvider {
+	return domains.FacebookOAuthProvider
+}
+
+func (p *facebookProvider) Endpoint() oauth2.Endpoint {
+	return facebook.Endpoint
+}
+
+func (p *facebookProvider) DefaultScopes() []string {
+	return []string{
+		"email",
+		"public_profile",
+	}
+}
+
+func (p *facebookProvider) FetchProfile(ctx context.Context, token *domains.OAuthToken) (*domains.OAuthProfile, error) {
+	var user facebookUser
+	raw, err := fetchJSON(ctx, facebookProfileURL, token, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	name := user.Name
+	if name == "" {
+		name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+
+	// Facebook does not report whether the address was confirmed
+	return &domains.OAuthProfile{
+		Provider:   domains.FacebookOAuthProvider,
+		ProviderID: user.ID,
+		Email:      user.Email,
+		Name:       name,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		Picture:    user.Picture.Data.URL,
+		Locale:     user.Locale,
+		RawClaims:  raw,
+	}, nil
+}
+
+func (p *facebookProvider) RevokeToken(ctx context.Context, config *domains.OAuthConfig, token *domains.OAuthToken) error {
+	revokeURL := fmt.Sprintf("%s?access_token=%s", facebookRevokeURL, url.QueryEscape(token.AccessToken))
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", revokeURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create revoke request: %w", err)
+	}
+
+	return doRevoke(req)
+}
@@ -0,0 +1,120 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+
+	"diandi-backend/domains"
+)
+
+const (
+	githubProfileURL = "https://api.github.com/user"
+	githubEmailsURL  = "https://api.github.com/user/emails"
+	githubRevokeURL  = "https://api.github.com/applications/%s/grant"
+)
+
+type githubProvider struct{}
+
+// githubUser is the response of the GitHub /user endpoint
+type githubUser struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+// githubEmail is an entry of the GitHub /user/emails endpoint
+type githubEmail struct {
+	Email      string `json:"email"`
+	Primary    bool   `json:"primary"`
+	Verified   bool   `json:"verified"`
+	Visibility string `json:"visibility"`
+}
+
+// NewGithubProvider creates the GitHub identity provider
+func NewGithubProvider() Provider {
+	return &githubProvider{}
+}
+
+func (p *githubProvider) Name() domains.OAuthProvider {
+	return domains.GithubOAuthProvider
+}
+
+func (p *githubProvider) Endpoint() oauth2.Endpoint {
+	return github.Endpoint
+}
+
+func (p *githubProvider) DefaultScopes() []string {
+	return []string{
+		"read:user",
+		"user:email",
+	}
+}
+
+func (p *githubProvider) FetchProfile(ctx context.Context, token *domains.OAuthToken) (*domains.OAuthProfile, error) {
+	var user githubUser
+	raw, err := fetchJSON(ctx, githubProfileURL, token, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	// The public profile email is optional, resolve the primary verified address instead
+	body, err := fetchBody(ctx, githubEmailsURL, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []githubEmail
+	if err := json.Unmarshal(body, &emails); err != nil {
+		return nil, fmt.Errorf("failed to parse emails: %w", err)
+	}
+	raw["emails"] = emails
+
+	profile := &domains.OAuthProfile{
+		Provider:   domains.GithubOAuthProvider,
+		ProviderID: strconv.FormatInt(user.ID, 10),
+		Name:       user.Name,
+		Picture:    user.AvatarURL,
+		RawClaims:  raw,
+	}
+	if profile.Name == "" {
+		profile.Name = user.Login
+	}
+
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			profile.Email = email.Email
+			profile.EmailVerified = true
+			break
+		}
+	}
+
+	return profile, nil
+}
+
+func (p *githubProvider) RevokeToken(ctx context.Context, config *domains.OAuthConfig, token *domains.OAuthToken) error {
+	payload, err := json.Marshal(map[string]string{"access_token": token.AccessToken})
+	if err != nil {
+		return fmt.Errorf("failed to create revoke request: %w", err)
+	}
+
+	revokeURL := fmt.Sprintf(githubRevokeURL, url.PathEscape(config.ClientID))
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", revokeURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create revoke request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(config.ClientID, config.ClientSecret)
+
+	return doRevoke(req)
+}
@@ -0,0 +1,84 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"diandi-backend/domains"
+)
+
+const (
+	googleProfileURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+	googleRevokeURL  = "https://accounts.google.com/o/oauth2/revoke"
+)
+
+type googleProvider struct{}
+
+// googleUserInfo is the response of the Google v2 userinfo endpoint
+type googleUserInfo struct {
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	VerifiedEmail bool   `json:"verified_email"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Picture       string `json:"picture"`
+	Locale        string `json:"locale"`
+}
+
+// NewGoogleProvider creates the Google identity provider
+func NewGoogleProvider() Provider {
+	return &googleProvider{}
+}
+
+func (p *googleProvider) Name() domains.OAuthProvider {
+	return domains.GoogleOAuthProvider
+}
+
+func (p *googleProvider) Endpoint() oauth2.Endpoint {
+	return google.Endpoint
+}
+
+func (p *googleProvider) DefaultScopes() []string {
+	return []string{
+		"https://www.googleapis.com/auth/userinfo.email",
+		"https://www.googleapis.com/auth/userinfo.profile",
+	}
+}
+
+func (p *googleProvider) FetchProfile(ctx context.Context, token *domains.OAuthToken) (*domains.OAuthProfile, error) {
+	var info googleUserInfo
+	raw, err := fetchJSON(ctx, googleProfileURL, token, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domains.OAuthProfile{
+		Provider:      domains.GoogleOAuthProvider,
+		ProviderID:    info.ID,
+		Email:         info.Email,
+		EmailVerified: info.VerifiedEmail,
+		Name:          info.Name,
+		FirstName:     info.GivenName,
+		LastName:      info.FamilyName,
+		Picture:       info.Picture,
+		Locale:        info.Locale,
+		RawClaims:     raw,
+	}, nil
+}
+
+func (p *googleProvider) RevokeToken(ctx context.Context, config *domains.OAuthConfig, token *domains.OAuthToken) error {
+	revokeURL := fmt.Sprintf("%s?token=%s", googleRevokeURL, url.QueryEscape(token.AccessToken))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", revokeURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create revoke request: %w", err)
+	}
+
+	return doRevoke(req)
+}
@@ -0,0 +1,246 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"diandi-backend/config"
+	"diandi-backend/domains"
+)
+
+const oidcDiscoveryTimeout = 10 * time.Second
+
+type oidcProvider struct {
+	name            domains.OAuthProvider
+	provider        *oidc.Provider
+	verifier        *oidc.IDTokenVerifier
+	revocationURL   string
+	userInfoEnabled bool
+}
+
+// oidcClaims holds the standard claims we map into an OAuthProfile
+type oidcClaims struct {
+	Subject       string    `json:"sub"`
+	Email         string    `json:"email"`
+	EmailVerified claimBool `json:"email_verified"`
+	Name          string    `json:"name"`
+	GivenName     string    `json:"given_name"`
+	FamilyName    string    `json:"family_name"`
+	Picture       string    `json:"picture"`
+	Locale        string    `json:"locale"`
+}
+
+// claimBool decodes boolean claims that some issuers, Apple among them, send as strings
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case bool:
+		*b = claimBool(v)
+	case string:
+		*b = claimBool(v == "true")
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// oidcMetadata holds the discovery fields not exposed by oidc.Provider
+type oidcMetadata struct {
+	RevocationEndpoint string `json:"revocation_endpoint"`
+	UserInfoEndpoint   string `json:"userinfo_endpoint"`
+}
+
+// NewOIDCProviders creates a generic OpenID Connect provider for every name in
+// OIDC_PROVIDERS using the issuer discovery document
+func NewOIDCProviders() ([]Provider, error) {
+	var result []Provider
+
+	for _, name := range config.LoadOIDCProviders() {
+		cfg := config.LoadOAuthConfig(name)
+		if cfg.IssuerURL == "" || cfg.ClientID == "" {
+			return nil, fmt.Errorf("oidc provider %s: issuer url and client id are required", name)
+		}
+
+		provider, err := NewOIDCProvider(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, provider)
+	}
+
+	return result, nil
+}
+
+// NewOIDCProvider discovers the issuer configuration and signing keys of an
+// OpenID Connect provider
+func NewOIDCProvider(name domains.OAuthProvider, cfg *domains.OAuthConfig) (Provider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), oidcDiscoveryTimeout)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("oidc provider %s: failed to discover issuer: %w", name, err)
+	}
+
+	var metadata oidcMetadata
+	if err := provider.Claims(&metadata); err != nil {
+		return nil, fmt.Errorf("oidc provider %s: failed to parse discovery document: %w", name, err)
+	}
+
+	return &oidcProvider{
+		name:            name,
+		provider:        provider,
+		verifier:        provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		revocationURL:   metadata.RevocationEndpoint,
+		userInfoEnabled: metadata.UserInfoEndpoint != "",
+	}, nil
+}
+
+func (p *oidcProvider) Name() domains.OAuthProvider {
+	return p.name
+}
+
+func (p *oidcProvider) Endpoint() oauth2.Endpoint {
+	return p.provider.Endpoint()
+}
+
+func (p *oidcProvider) DefaultScopes() []string {
+	return []string{oidc.ScopeOpenID, "email", "profile"}
+}
+
+func (p *oidcProvider) VerifyIDToken(ctx context.Context, token *domains.OAuthToken) error {
+	_, _, err := verifyIDToken(ctx, p.verifier, token)
+	return err
+}
+
+func (p *oidcProvider) FetchProfile(ctx context.Context, token *domains.OAuthToken) (*domains.OAuthProfile, error) {
+	claims, raw, err := verifyIDToken(ctx, p.verifier, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// ID tokens may carry only the subject, fill the rest from userinfo
+	if p.userInfoEnabled {
+		userInfo, err := p.provider.UserInfo(ctx, oauth2.StaticTokenSource(&oauth2.Token{
+			AccessToken: token.AccessToken,
+			TokenType:   token.TokenType,
+		}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user info: %w", err)
+		}
+		if userInfo.Subject != claims.Subject {
+			return nil, errors.New("user info subject does not match id token")
+		}
+
+		var extra oidcClaims
+		if err := userInfo.Claims(&extra); err != nil {
+			return nil, fmt.Errorf("failed to parse user info: %w", err)
+		}
+		mergeOIDCClaims(claims, &extra)
+	}
+
+	return claims.profile(p.name, raw), nil
+}
+
+func (p *oidcProvider) RevokeToken(ctx context.Context, config *domains.OAuthConfig, token *domains.OAuthToken) error {
+	// Revocation (RFC 7009) is optional for OpenID providers
+	if p.revocationURL == "" {
+		return nil
+	}
+
+	form := url.Values{
+		"token":           {token.AccessToken},
+		"token_type_hint": {"access_token"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.revocationURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create revoke request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+
+	return doRevoke(req)
+}
+
+// verifyIDToken checks the signature against the issuer JWKS together with the
+// iss, aud, exp and nonce claims and decodes the standard claims
+func verifyIDToken(ctx context.Context, verifier *oidc.IDTokenVerifier, token *domains.OAuthToken) (*oidcClaims, map[string]interface{}, error) {
+	if token.IDToken == "" {
+		return nil, nil, errors.New("missing id token in token response")
+	}
+
+	idToken, err := verifier.Verify(ctx, token.IDToken)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if idToken.Nonce != token.Nonce {
+		return nil, nil, errors.New("invalid id token: nonce mismatch")
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse id token claims: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := idToken.Claims(&raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse id token claims: %w", err)
+	}
+
+	return &claims, raw, nil
+}
+
+// mergeOIDCClaims fills the claims missing from the ID token
+func mergeOIDCClaims(claims, extra *oidcClaims) {
+	if claims.Email == "" {
+		claims.Email = extra.Email
+		claims.EmailVerified = extra.EmailVerified
+	}
+	if claims.Name == "" {
+		claims.Name = extra.Name
+	}
+	if claims.GivenName == "" {
+		claims.GivenName = extra.GivenName
+	}
+	if claims.FamilyName == "" {
+		claims.FamilyName = extra.FamilyName
+	}
+	if claims.Picture == "" {
+		claims.Picture = extra.Picture
+	}
+	if claims.Locale == "" {
+		claims.Locale = extra.Locale
+	}
+}
+
+func (c *oidcClaims) profile(provider domains.OAuthProvider, raw map[string]interface{}) *domains.OAuthProfile {
+	return &domains.OAuthProfile{
+		Provider:      provider,
+		ProviderID:    c.Subject,
+		Email:         c.Email,
+		EmailVerified: bool(c.EmailVerified),
+		Name:          c.Name,
+		FirstName:     c.GivenName,
+		LastName:      c.FamilyName,
+		Picture:       c.Picture,
+		Locale:        c.Locale,
+		RawClaims:     raw,
+	}
+}
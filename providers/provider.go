@@ -0,0 +1,118 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+
+	"diandi-backend/domains"
+)
+
+// Provider describes an external identity provider the OAuth service can dispatch to
+type Provider interface {
+	// Name returns the identifier used in routes and persisted records
+	Name() domains.OAuthProvider
+
+	// Endpoint returns the authorization and token endpoints
+	Endpoint() oauth2.Endpoint
+
+	// DefaultScopes returns the scopes requested when none are configured
+	DefaultScopes() []string
+
+	// FetchProfile retrieves the user profile and normalizes it into an OAuthProfile
+	FetchProfile(ctx context.Context, token *domains.OAuthToken) (*domains.OAuthProfile, error)
+
+	// RevokeToken revokes the token at the provider
+	RevokeToken(ctx context.Context, config *domains.OAuthConfig, token *domains.OAuthToken) error
+}
+
+// AuthURLOptioner is implemented by providers requiring extra authorization request parameters
+type AuthURLOptioner interface {
+	AuthURLOptions() []oauth2.AuthCodeOption
+}
+
+// ClientSecretGenerator is implemented by providers whose client secret is derived
+// from key material rather than configured
+type ClientSecretGenerator interface {
+	ClientSecret(config *domains.OAuthConfig) (string, error)
+}
+
+// IDTokenVerifier is implemented by providers returning OpenID Connect ID tokens
+// from the code exchange
+type IDTokenVerifier interface {
+	VerifyIDToken(ctx context.Context, token *domains.OAuthToken) error
+}
+
+// CallbackProfiler is implemented by providers delivering profile data along with
+// the callback request instead of through their API
+type CallbackProfiler interface {
+	ProfileFromCallback(profile *domains.OAuthProfile, params url.Values) error
+}
+
+// fetchJSON performs an authorized GET request, decodes the JSON response into out
+// and returns the undecoded claims for auditing
+func fetchJSON(ctx context.Context, url string, token *domains.OAuthToken, out interface{}) (map[string]interface{}, error) {
+	body, err := fetchBody(ctx, url, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return nil, fmt.Errorf("failed to parse profile data: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse profile data: %w", err)
+	}
+
+	return raw, nil
+}
+
+// fetchBody performs an authorized GET request and returns the response body
+func fetchBody(ctx context.Context, url string, token *domains.OAuthToken) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s %s", token.TokenType, token.AccessToken))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user profile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get user profile: %s", string(body))
+	}
+
+	return body, nil
+}
+
+// doRevoke sends a revocation request and treats any non 2xx response as failure
+func doRevoke(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to revoke token: %s", string(body))
+	}
+
+	return nil
+}
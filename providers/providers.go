@@ -0,0 +1,31 @@
+package providers
+
+import "go.uber.org/fx"
+
+var Module = fx.Options(
+	fx.Provide(AsProvider(NewGoogleProvider)),
+	fx.Provide(AsProvider(NewFacebookProvider)),
+	fx.Provide(AsProvider(NewGithubProvider)),
+	fx.Provide(AsProvider(NewAppleProvider)),
+	fx.Provide(AsProviders(NewOIDCProviders)),
+	fx.Provide(NewRegistry),
+	fx.Provide(NewOAuthConfigs),
+)
+
+// AsProvider annotates a provider constructor so its result joins the
+// oauth_providers group consumed by NewRegistry
+func AsProvider(constructor interface{}) interface{} {
+	return fx.Annotate(
+		constructor,
+		fx.ResultTags(`group:"oauth_providers"`),
+	)
+}
+
+// AsProviders annotates a constructor returning several providers so each of
+// them joins the oauth_providers group
+func AsProviders(constructor interface{}) interface{} {
+	return fx.Annotate(
+		constructor,
+		fx.ResultTags(`group:"oauth_providers,flatten"`),
+	)
+}
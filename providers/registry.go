@@ -0,0 +1,62 @@
+package providers
+
+import (
+	"fmt"
+	"sort"
+
+	"go.uber.org/fx"
+
+	"diandi-backend/config"
+	"diandi-backend/domains"
+)
+
+// Registry indexes the registered identity providers by name
+type Registry struct {
+	providers map[domains.OAuthProvider]Provider
+}
+
+// RegistryParams collects every provider registered in the oauth_providers group
+type RegistryParams struct {
+	fx.In
+
+	Providers []Provider `group:"oauth_providers"`
+}
+
+// NewRegistry creates a registry from the providers registered via fx. Two
+// providers registered under one name fail startup instead of one silently
+// replacing the other.
+func NewRegistry(params RegistryParams) (*Registry, error) {
+	registry := &Registry{
+		providers: make(map[domains.OAuthProvider]Provider),
+	}
+
+	for _, provider := range params.Providers {
+		if _, ok := registry.providers[provider.Name()]; ok {
+			return nil, fmt.Errorf("oauth provider %q is registered twice", provider.Name())
+		}
+		registry.providers[provider.Name()] = provider
+	}
+
+	return registry, nil
+}
+
+// Get returns the provider registered under name
+func (r *Registry) Get(name domains.OAuthProvider) (Provider, bool) {
+	provider, ok := r.providers[name]
+	return provider, ok
+}
+
+// Names returns the names of all registered providers in a stable order
+func (r *Registry) Names() []domains.OAuthProvider {
+	names := make([]domains.OAuthProvider, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// NewOAuthConfigs loads the configuration of every registered provider
+func NewOAuthConfigs(registry *Registry) *config.OAuthConfigs {
+	return config.LoadOAuthConfigs(registry.Names()...)
+}
@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	apiKeysCollection = "api_keys"
+)
+
+type mongoAPIKeyRepository struct {
+	db lib.Database
+}
+
+// NewMongoAPIKeyRepository creates a MongoDB repository for API keys
+func NewMongoAPIKeyRepository(db lib.Database) (services.APIKeyRepository, error) {
+	collection := db.Collection(apiKeysCollection)
+
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "keyHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "owner.type", Value: 1}, {Key: "owner.id", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create api key indexes: %w", err)
+	}
+
+	return &mongoAPIKeyRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoAPIKeyRepository) Create(ctx context.Context, key *domains.APIKey) error {
+	collection := r.db.Collection(apiKeysCollection)
+
+	if key.ID == "" {
+		key.ID = primitive.NewObjectID().Hex()
+	}
+
+	_, err := collection.InsertOne(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoAPIKeyRepository) GetByHash(ctx context.Context, keyHash string) (*domains.APIKey, error) {
+	return r.findOne(ctx, bson.M{"keyHash": keyHash})
+}
+
+func (r *mongoAPIKeyRepository) Get(ctx context.Context, owner domains.APIKeyOwner, id string) (*domains.APIKey, error) {
+	return r.findOne(ctx, bson.M{"_id": id, "owner.type": owner.Type, "owner.id": owner.ID})
+}
+
+func (r *mongoAPIKeyRepository) ListByOwner(ctx context.Context, owner domains.APIKeyOwner) ([]*domains.APIKey, error) {
+	collection := r.db.Collection(apiKeysCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.M{"owner.type": owner.Type, "owner.id": owner.ID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+
+	keys := []*domains.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (r *mongoAPIKeyRepository) Update(ctx context.Context, key *domains.APIKey) error {
+	collection := r.db.Collection(apiKeysCollection)
+
+	update := bson.M{
+		"$set": bson.M{
+			"name":   key.Name,
+			"scopes": key.Scopes,
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": key.ID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update api key: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return domains.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (r *mongoAPIKeyRepository) Delete(ctx context.Context, owner domains.APIKeyOwner, id string) error {
+	collection := r.db.Collection(apiKeysCollection)
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id, "owner.type": owner.Type, "owner.id": owner.ID})
+	if err != nil {
+		return fmt.Errorf("failed to delete api key: %w", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return domains.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (r *mongoAPIKeyRepository) TouchLastUsed(ctx context.Context, id string, usedAt time.Time) error {
+	collection := r.db.Collection(apiKeysCollection)
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"lastUsedAt": usedAt}})
+	if err != nil {
+		return fmt.Errorf("failed to update api key: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoAPIKeyRepository) findOne(ctx context.Context, filter bson.M) (*domains.APIKey, error) {
+	collection := r.db.Collection(apiKeysCollection)
+
+	var key domains.APIKey
+	err := collection.FindOne(ctx, filter).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+
+	return &key, nil
+}
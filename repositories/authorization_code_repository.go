@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	authorizationCodesCollection = "oauth2_authorization_codes"
+)
+
+type mongoAuthorizationCodeRepository struct {
+	db lib.Database
+}
+
+// NewMongoAuthorizationCodeRepository creates a MongoDB repository for
+// authorization codes whose documents are removed by a TTL index once expired
+func NewMongoAuthorizationCodeRepository(db lib.Database) (services.AuthorizationCodeRepository, error) {
+	collection := db.Collection(authorizationCodesCollection)
+
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorization code index: %w", err)
+	}
+
+	return &mongoAuthorizationCodeRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoAuthorizationCodeRepository) Save(ctx context.Context, code *domains.AuthorizationCode) error {
+	collection := r.db.Collection(authorizationCodesCollection)
+
+	_, err := collection.InsertOne(ctx, code)
+	if err != nil {
+		return fmt.Errorf("failed to save authorization code: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoAuthorizationCodeRepository) Consume(ctx context.Context, codeHash string) (*domains.AuthorizationCode, error) {
+	collection := r.db.Collection(authorizationCodesCollection)
+
+	var code domains.AuthorizationCode
+	err := collection.FindOneAndDelete(ctx, bson.M{"_id": codeHash}).Decode(&code)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrInvalidAuthorizationCode
+		}
+		return nil, fmt.Errorf("failed to get authorization code: %w", err)
+	}
+
+	if time.Now().After(code.ExpiresAt) {
+		return nil, domains.ErrInvalidAuthorizationCode
+	}
+
+	return &code, nil
+}
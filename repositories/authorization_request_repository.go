@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	authorizationRequestsCollection = "oauth2_authorization_requests"
+)
+
+type mongoAuthorizationRequestRepository struct {
+	db lib.Database
+}
+
+// NewMongoAuthorizationRequestRepository creates a MongoDB repository for pending
+// authorization requests whose documents are removed by a TTL index once expired
+func NewMongoAuthorizationRequestRepository(db lib.Database) (services.AuthorizationRequestRepository, error) {
+	collection := db.Collection(authorizationRequestsCollection)
+
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorization request index: %w", err)
+	}
+
+	return &mongoAuthorizationRequestRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoAuthorizationRequestRepository) Save(ctx context.Context, request *domains.AuthorizationRequest) error {
+	collection := r.db.Collection(authorizationRequestsCollection)
+
+	_, err := collection.InsertOne(ctx, request)
+	if err != nil {
+		return fmt.Errorf("failed to save authorization request: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoAuthorizationRequestRepository) Get(ctx context.Context, id string) (*domains.AuthorizationRequest, error) {
+	collection := r.db.Collection(authorizationRequestsCollection)
+
+	var request domains.AuthorizationRequest
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&request)
+	return r.valid(&request, err)
+}
+
+func (r *mongoAuthorizationRequestRepository) Consume(ctx context.Context, id string) (*domains.AuthorizationRequest, error) {
+	collection := r.db.Collection(authorizationRequestsCollection)
+
+	var request domains.AuthorizationRequest
+	err := collection.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&request)
+	return r.valid(&request, err)
+}
+
+func (r *mongoAuthorizationRequestRepository) valid(request *domains.AuthorizationRequest, err error) (*domains.AuthorizationRequest, error) {
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrAuthorizationRequestMissing
+		}
+		return nil, fmt.Errorf("failed to get authorization request: %w", err)
+	}
+
+	if time.Now().After(request.ExpiresAt) {
+		return nil, domains.ErrAuthorizationRequestMissing
+	}
+
+	return request, nil
+}
@@ -0,0 +1,120 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	emailLoginsCollection = "email_logins"
+)
+
+type mongoEmailLoginRepository struct {
+	db lib.Database
+}
+
+// NewMongoEmailLoginRepository creates a MongoDB repository for pending email
+// logins whose documents are removed by a TTL index once expired
+func NewMongoEmailLoginRepository(db lib.Database) (services.EmailLoginRepository, error) {
+	collection := db.Collection(emailLoginsCollection)
+
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create email login indexes: %w", err)
+	}
+
+	return &mongoEmailLoginRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoEmailLoginRepository) Save(ctx context.Context, login *domains.EmailLogin) error {
+	collection := r.db.Collection(emailLoginsCollection)
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": login.Email}, login, opts)
+	if err != nil {
+		return fmt.Errorf("failed to save email login: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoEmailLoginRepository) Get(ctx context.Context, email string) (*domains.EmailLogin, error) {
+	collection := r.db.Collection(emailLoginsCollection)
+
+	var login domains.EmailLogin
+	err := collection.FindOne(ctx, bson.M{"_id": email, "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&login)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrInvalidEmailLogin
+		}
+		return nil, fmt.Errorf("failed to get email login: %w", err)
+	}
+
+	return &login, nil
+}
+
+func (r *mongoEmailLoginRepository) ConsumeToken(ctx context.Context, tokenHash string) (*domains.EmailLogin, error) {
+	return r.consume(ctx, bson.M{"tokenHash": tokenHash})
+}
+
+func (r *mongoEmailLoginRepository) ConsumeCode(ctx context.Context, email string, codeHash string, maxAttempts int) (*domains.EmailLogin, error) {
+	return r.consume(ctx, bson.M{
+		"_id":      email,
+		"codeHash": codeHash,
+		"attempts": bson.M{"$lt": maxAttempts},
+	})
+}
+
+func (r *mongoEmailLoginRepository) RecordFailure(ctx context.Context, email string, maxAttempts int) error {
+	collection := r.db.Collection(emailLoginsCollection)
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": email}, bson.M{"$inc": bson.M{"attempts": 1}})
+	if err != nil {
+		return fmt.Errorf("failed to record email login attempt: %w", err)
+	}
+
+	// Spent logins are removed so neither the code nor the link works any more
+	_, err = collection.DeleteOne(ctx, bson.M{"_id": email, "attempts": bson.M{"$gte": maxAttempts}})
+	if err != nil {
+		return fmt.Errorf("failed to delete email login: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoEmailLoginRepository) consume(ctx context.Context, filter bson.M) (*domains.EmailLogin, error) {
+	collection := r.db.Collection(emailLoginsCollection)
+
+	filter["expiresAt"] = bson.M{"$gt": time.Now()}
+
+	var login domains.EmailLogin
+	err := collection.FindOneAndDelete(ctx, filter).Decode(&login)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrInvalidEmailLogin
+		}
+		return nil, fmt.Errorf("failed to get email login: %w", err)
+	}
+
+	return &login, nil
+}
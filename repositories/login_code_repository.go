@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	loginCodesCollection = "login_codes"
+)
+
+type mongoLoginCodeRepository struct {
+	db lib.Database
+}
+
+// NewMongoLoginCodeRepository creates a MongoDB repository for login codes whose
+// documents are removed by a TTL index once expired
+func NewMongoLoginCodeRepository(db lib.Database) (services.LoginCodeRepository, error) {
+	collection := db.Collection(loginCodesCollection)
+
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create login code ttl index: %w", err)
+	}
+
+	return &mongoLoginCodeRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoLoginCodeRepository) Save(ctx context.Context, code *domains.LoginCode) error {
+	collection := r.db.Collection(loginCodesCollection)
+
+	_, err := collection.InsertOne(ctx, code)
+	if err != nil {
+		return fmt.Errorf("failed to save login code: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoLoginCodeRepository) Consume(ctx context.Context, codeHash string) (*domains.LoginCode, error) {
+	collection := r.db.Collection(loginCodesCollection)
+
+	var code domains.LoginCode
+	err := collection.FindOneAndDelete(ctx, bson.M{"_id": codeHash}).Decode(&code)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrInvalidLoginCode
+		}
+		return nil, fmt.Errorf("failed to get login code: %w", err)
+	}
+
+	if time.Now().After(code.ExpiresAt) {
+		return nil, domains.ErrInvalidLoginCode
+	}
+
+	return &code, nil
+}
@@ -0,0 +1,55 @@
+package repositories
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/services"
+)
+
+type memoryOAuthStateStore struct {
+	mu     sync.Mutex
+	states map[string]domains.OAuthState
+}
+
+// NewMemoryOAuthStateStore creates a process local state store, suitable for
+// development and single instance deployments
+func NewMemoryOAuthStateStore() services.OAuthStateStore {
+	return &memoryOAuthStateStore{
+		states: make(map[string]domains.OAuthState),
+	}
+}
+
+func (r *memoryOAuthStateStore) Save(ctx context.Context, state *domains.OAuthState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for key, saved := range r.states {
+		if now.After(saved.ExpiresAt) {
+			delete(r.states, key)
+		}
+	}
+
+	r.states[state.State] = *state
+	return nil
+}
+
+func (r *memoryOAuthStateStore) Consume(ctx context.Context, state string) (*domains.OAuthState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, ok := r.states[state]
+	if !ok {
+		return nil, domains.ErrInvalidOAuthState
+	}
+	delete(r.states, state)
+
+	if time.Now().After(saved.ExpiresAt) {
+		return nil, domains.ErrInvalidOAuthState
+	}
+
+	return &saved, nil
+}
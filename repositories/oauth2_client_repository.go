@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	oauth2ClientsCollection = "oauth2_clients"
+)
+
+type mongoOAuth2ClientRepository struct {
+	db lib.Database
+}
+
+// NewMongoOAuth2ClientRepository creates a new MongoDB repository for OAuth2 clients
+func NewMongoOAuth2ClientRepository(db lib.Database) services.OAuth2ClientRepository {
+	return &mongoOAuth2ClientRepository{
+		db: db,
+	}
+}
+
+func (r *mongoOAuth2ClientRepository) Create(ctx context.Context, client *domains.OAuth2Client) error {
+	collection := r.db.Collection(oauth2ClientsCollection)
+
+	_, err := collection.InsertOne(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to create oauth2 client: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoOAuth2ClientRepository) GetByID(ctx context.Context, id string) (*domains.OAuth2Client, error) {
+	collection := r.db.Collection(oauth2ClientsCollection)
+
+	var client domains.OAuth2Client
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&client)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrClientNotFound
+		}
+		return nil, fmt.Errorf("failed to get oauth2 client: %w", err)
+	}
+
+	return &client, nil
+}
+
+func (r *mongoOAuth2ClientRepository) List(ctx context.Context) ([]*domains.OAuth2Client, error) {
+	collection := r.db.Collection(oauth2ClientsCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list oauth2 clients: %w", err)
+	}
+
+	clients := []*domains.OAuth2Client{}
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, fmt.Errorf("failed to list oauth2 clients: %w", err)
+	}
+
+	return clients, nil
+}
+
+func (r *mongoOAuth2ClientRepository) Delete(ctx context.Context, id string) error {
+	collection := r.db.Collection(oauth2ClientsCollection)
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete oauth2 client: %w", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return domains.ErrClientNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	oauth2ConsentsCollection = "oauth2_consents"
+)
+
+type mongoOAuth2ConsentRepository struct {
+	db lib.Database
+}
+
+// NewMongoOAuth2ConsentRepository creates a new MongoDB repository for the
+// consents users gave OAuth2 clients
+func NewMongoOAuth2ConsentRepository(db lib.Database) services.OAuth2ConsentRepository {
+	return &mongoOAuth2ConsentRepository{
+		db: db,
+	}
+}
+
+func (r *mongoOAuth2ConsentRepository) Get(ctx context.Context, userID string, clientID string) (*domains.OAuth2Consent, error) {
+	collection := r.db.Collection(oauth2ConsentsCollection)
+
+	var consent domains.OAuth2Consent
+	err := collection.FindOne(ctx, bson.M{"_id": userID + ":" + clientID}).Decode(&consent)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrConsentNotFound
+		}
+		return nil, fmt.Errorf("failed to get oauth2 consent: %w", err)
+	}
+
+	return &consent, nil
+}
+
+func (r *mongoOAuth2ConsentRepository) Save(ctx context.Context, consent *domains.OAuth2Consent) error {
+	collection := r.db.Collection(oauth2ConsentsCollection)
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": consent.ID}, consent, opts)
+	if err != nil {
+		return fmt.Errorf("failed to save oauth2 consent: %w", err)
+	}
+
+	return nil
+}
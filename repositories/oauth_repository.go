@@ -9,6 +9,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"diandi-backend/domains"
+	"diandi-backend/lib"
 	"diandi-backend/services"
 )
 
@@ -18,11 +19,11 @@ const (
 )
 
 type mongoOAuthRepository struct {
-	db *mongo.Database
+	db lib.Database
 }
 
 // NewMongoOAuthRepository creates a new MongoDB repository for OAuth data
-func NewMongoOAuthRepository(db *mongo.Database) services.OAuthRepository {
+func NewMongoOAuthRepository(db lib.Database) services.OAuthRepository {
 	return &mongoOAuthRepository{
 		db: db,
 	}
@@ -94,8 +95,34 @@ func (r *mongoOAuthRepository) SaveProfile(ctx context.Context, profile *domains
 		"provider":   profile.Provider,
 	}
 
+	// Providers leave out fields on later sign ins, e.g. Apple only sends the name
+	// on the first one, so only what the provider sent replaces stored values
+	set := bson.M{
+		"userId":    profile.UserID,
+		"updatedAt": profile.UpdatedAt,
+	}
+	if profile.Email != "" {
+		set["email"] = profile.Email
+		set["emailVerified"] = profile.EmailVerified
+	}
+	for field, value := range map[string]string{
+		"name":      profile.Name,
+		"firstName": profile.FirstName,
+		"lastName":  profile.LastName,
+		"picture":   profile.Picture,
+		"locale":    profile.Locale,
+	} {
+		if value != "" {
+			set[field] = value
+		}
+	}
+	if profile.RawClaims != nil {
+		set["rawClaims"] = profile.RawClaims
+	}
+
 	update := bson.M{
-		"$set": profile,
+		"$set":         set,
+		"$setOnInsert": bson.M{"createdAt": profile.CreatedAt},
 	}
 
 	opts := options.Update().SetUpsert(true)
@@ -120,10 +147,27 @@ func (r *mongoOAuthRepository) GetProfile(ctx context.Context, providerID string
 	err := collection.FindOne(ctx, filter).Decode(&profile)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("profile not found")
+			return nil, domains.ErrProfileNotFound
 		}
 		return nil, fmt.Errorf("failed to get profile: %w", err)
 	}
 
 	return &profile, nil
 }
+
+func (r *mongoOAuthRepository) ListProfiles(ctx context.Context, userID string) ([]*domains.OAuthProfile, error) {
+	collection := r.db.Collection(oauthProfilesCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+
+	profiles := []*domains.OAuthProfile{}
+	if err := cursor.All(ctx, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	oauthStatesCollection = "oauth_states"
+)
+
+type mongoOAuthStateStore struct {
+	db lib.Database
+}
+
+// NewOAuthStateStore selects the state store configured by OAUTH_STATE_STORE,
+// MongoDB by default so every instance can complete any callback
+func NewOAuthStateStore(env lib.Env, db lib.Database) (services.OAuthStateStore, error) {
+	switch env.OAuthStateStore {
+	case "", "mongo":
+		return NewMongoOAuthStateStore(db)
+	case "memory":
+		return NewMemoryOAuthStateStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported oauth state store: %s", env.OAuthStateStore)
+	}
+}
+
+// NewMongoOAuthStateStore creates a MongoDB state store whose documents are
+// removed by a TTL index once expired
+func NewMongoOAuthStateStore(db lib.Database) (services.OAuthStateStore, error) {
+	collection := db.Collection(oauthStatesCollection)
+
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state ttl index: %w", err)
+	}
+
+	return &mongoOAuthStateStore{
+		db: db,
+	}, nil
+}
+
+func (r *mongoOAuthStateStore) Save(ctx context.Context, state *domains.OAuthState) error {
+	collection := r.db.Collection(oauthStatesCollection)
+
+	_, err := collection.InsertOne(ctx, state)
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoOAuthStateStore) Consume(ctx context.Context, state string) (*domains.OAuthState, error) {
+	collection := r.db.Collection(oauthStatesCollection)
+
+	// Deleting while reading guarantees a state is redeemed at most once
+	var saved domains.OAuthState
+	err := collection.FindOneAndDelete(ctx, bson.M{"_id": state}).Decode(&saved)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrInvalidOAuthState
+		}
+		return nil, fmt.Errorf("failed to get state: %w", err)
+	}
+
+	// The TTL monitor runs periodically, expired documents may still be around
+	if time.Now().After(saved.ExpiresAt) {
+		return nil, domains.ErrInvalidOAuthState
+	}
+
+	return &saved, nil
+}
@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	passwordResetTokensCollection = "password_reset_tokens"
+)
+
+type mongoPasswordResetRepository struct {
+	db lib.Database
+}
+
+// NewMongoPasswordResetRepository creates a MongoDB repository for password reset
+// tokens whose documents are removed by a TTL index once expired
+func NewMongoPasswordResetRepository(db lib.Database) (services.PasswordResetRepository, error) {
+	collection := db.Collection(passwordResetTokensCollection)
+
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create password reset token indexes: %w", err)
+	}
+
+	return &mongoPasswordResetRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoPasswordResetRepository) Save(ctx context.Context, token *domains.PasswordResetToken) error {
+	collection := r.db.Collection(passwordResetTokensCollection)
+
+	_, err := collection.InsertOne(ctx, token)
+	if err != nil {
+		return fmt.Errorf("failed to save password reset token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoPasswordResetRepository) Consume(ctx context.Context, tokenHash string) (*domains.PasswordResetToken, error) {
+	collection := r.db.Collection(passwordResetTokensCollection)
+
+	var token domains.PasswordResetToken
+	err := collection.FindOneAndDelete(ctx, bson.M{"_id": tokenHash}).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrInvalidResetToken
+		}
+		return nil, fmt.Errorf("failed to get password reset token: %w", err)
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		return nil, domains.ErrInvalidResetToken
+	}
+
+	return &token, nil
+}
+
+func (r *mongoPasswordResetRepository) DeleteUser(ctx context.Context, userID string) error {
+	collection := r.db.Collection(passwordResetTokensCollection)
+
+	_, err := collection.DeleteMany(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete password reset tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	refreshTokensCollection = "refresh_tokens"
+)
+
+type mongoRefreshTokenRepository struct {
+	db lib.Database
+}
+
+// NewMongoRefreshTokenRepository creates a MongoDB repository for refresh tokens
+// whose documents are removed by a TTL index once expired
+func NewMongoRefreshTokenRepository(db lib.Database) (services.RefreshTokenRepository, error) {
+	collection := db.Collection(refreshTokensCollection)
+
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{Keys: bson.D{{Key: "familyId", Value: 1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token indexes: %w", err)
+	}
+
+	return &mongoRefreshTokenRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoRefreshTokenRepository) Save(ctx context.Context, token *domains.RefreshToken) error {
+	collection := r.db.Collection(refreshTokensCollection)
+
+	_, err := collection.InsertOne(ctx, token)
+	if err != nil {
+		return fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoRefreshTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*domains.RefreshToken, error) {
+	collection := r.db.Collection(refreshTokensCollection)
+
+	var token domains.RefreshToken
+	err := collection.FindOne(ctx, bson.M{"_id": tokenHash}).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (r *mongoRefreshTokenRepository) MarkRotated(ctx context.Context, tokenHash string, rotatedAt time.Time) (bool, error) {
+	collection := r.db.Collection(refreshTokensCollection)
+
+	// Only the first caller flips rotatedAt, concurrent replays see no match
+	filter := bson.M{
+		"_id":       tokenHash,
+		"rotatedAt": bson.M{"$exists": false},
+		"revokedAt": bson.M{"$exists": false},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"rotatedAt": rotatedAt}})
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *mongoRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	return r.revoke(ctx, bson.M{"familyId": familyID}, revokedAt)
+}
+
+func (r *mongoRefreshTokenRepository) RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error {
+	return r.revoke(ctx, bson.M{"userId": userID}, revokedAt)
+}
+
+func (r *mongoRefreshTokenRepository) RevokeClient(ctx context.Context, clientID string, revokedAt time.Time) error {
+	return r.revoke(ctx, bson.M{"clientId": clientID}, revokedAt)
+}
+
+func (r *mongoRefreshTokenRepository) revoke(ctx context.Context, filter bson.M, revokedAt time.Time) error {
+	collection := r.db.Collection(refreshTokensCollection)
+
+	filter["revokedAt"] = bson.M{"$exists": false}
+
+	_, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedAt": revokedAt}})
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package repositories
+
+import "go.uber.org/fx"
+
+var Module = fx.Options(
+	fx.Provide(NewMongoOAuthRepository),
+	fx.Provide(NewMongoUserRepository),
+	fx.Provide(NewOAuthStateStore),
+	fx.Provide(NewMongoLoginCodeRepository),
+	fx.Provide(NewMongoRefreshTokenRepository),
+	fx.Provide(NewMongoSigningKeyRepository),
+	fx.Provide(NewMongoRevokedTokenRepository),
+	fx.Provide(NewMongoPasswordResetRepository),
+	fx.Provide(NewMongoWebAuthnCredentialRepository),
+	fx.Provide(NewMongoWebAuthnSessionRepository),
+	fx.Provide(NewMongoEmailLoginRepository),
+	fx.Provide(NewMongoRoleRepository),
+	fx.Provide(NewMongoAPIKeyRepository),
+	fx.Provide(NewMongoOAuth2ClientRepository),
+	fx.Provide(NewMongoAuthorizationRequestRepository),
+	fx.Provide(NewMongoAuthorizationCodeRepository),
+	fx.Provide(NewMongoOAuth2ConsentRepository),
+)
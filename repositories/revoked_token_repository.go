@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	revokedTokensCollection = "revoked_tokens"
+)
+
+type mongoRevokedTokenRepository struct {
+	db lib.Database
+}
+
+// NewMongoRevokedTokenRepository creates a MongoDB repository for the access token
+// denylist whose entries are removed by a TTL index once the tokens have expired
+func NewMongoRevokedTokenRepository(db lib.Database) (services.RevokedTokenRepository, error) {
+	collection := db.Collection(revokedTokensCollection)
+
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{Keys: bson.D{{Key: "revokedAt", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create revoked token indexes: %w", err)
+	}
+
+	return &mongoRevokedTokenRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoRevokedTokenRepository) Save(ctx context.Context, token *domains.RevokedToken) error {
+	collection := r.db.Collection(revokedTokensCollection)
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": token.ID}, token, opts)
+	if err != nil {
+		return fmt.Errorf("failed to save revoked token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoRevokedTokenRepository) ListSince(ctx context.Context, since time.Time) ([]*domains.RevokedToken, error) {
+	collection := r.db.Collection(revokedTokensCollection)
+
+	filter := bson.M{
+		"revokedAt": bson.M{"$gte": since},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revoked tokens: %w", err)
+	}
+
+	var tokens []*domains.RevokedToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to list revoked tokens: %w", err)
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	rolesCollection = "roles"
+)
+
+type mongoRoleRepository struct {
+	db lib.Database
+}
+
+// NewMongoRoleRepository creates a new MongoDB repository for roles
+func NewMongoRoleRepository(db lib.Database) services.RoleRepository {
+	return &mongoRoleRepository{
+		db: db,
+	}
+}
+
+func (r *mongoRoleRepository) List(ctx context.Context) ([]*domains.Role, error) {
+	return r.find(ctx, bson.M{})
+}
+
+func (r *mongoRoleRepository) GetMany(ctx context.Context, names []string) ([]*domains.Role, error) {
+	if len(names) == 0 {
+		return []*domains.Role{}, nil
+	}
+	return r.find(ctx, bson.M{"_id": bson.M{"$in": names}})
+}
+
+func (r *mongoRoleRepository) CreateIfMissing(ctx context.Context, role *domains.Role) (bool, error) {
+	collection := r.db.Collection(rolesCollection)
+
+	opts := options.Update().SetUpsert(true)
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": role.Name}, bson.M{"$setOnInsert": role}, opts)
+	if err != nil {
+		return false, fmt.Errorf("failed to create role: %w", err)
+	}
+
+	return result.UpsertedCount > 0, nil
+}
+
+func (r *mongoRoleRepository) find(ctx context.Context, filter bson.M) ([]*domains.Role, error) {
+	collection := r.db.Collection(rolesCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	roles := []*domains.Role{}
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	return roles, nil
+}
@@ -0,0 +1,121 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	signingKeysCollection = "signing_keys"
+)
+
+type mongoSigningKeyRepository struct {
+	db lib.Database
+}
+
+// NewMongoSigningKeyRepository creates a MongoDB repository for signing keys,
+// retired keys are removed by a TTL index once no longer published
+func NewMongoSigningKeyRepository(db lib.Database) (services.SigningKeyRepository, error) {
+	collection := db.Collection(signingKeysCollection)
+
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "publishUntil", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			// Concurrent startups insert at most one initial key
+			Keys: bson.D{{Key: "initial", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"initial": true}),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing key indexes: %w", err)
+	}
+
+	return &mongoSigningKeyRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoSigningKeyRepository) Save(ctx context.Context, key *domains.SigningKey) error {
+	collection := r.db.Collection(signingKeysCollection)
+
+	_, err := collection.InsertOne(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to save signing key: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoSigningKeyRepository) SaveInitial(ctx context.Context, key *domains.SigningKey) (bool, error) {
+	collection := r.db.Collection(signingKeysCollection)
+
+	_, err := collection.InsertOne(ctx, key)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to save signing key: %w", err)
+	}
+
+	return true, nil
+}
+
+func (r *mongoSigningKeyRepository) ListPublished(ctx context.Context, now time.Time) ([]*domains.SigningKey, error) {
+	collection := r.db.Collection(signingKeysCollection)
+
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"publishUntil": bson.M{"$exists": false}},
+			bson.M{"publishUntil": bson.M{"$gt": now}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+
+	var keys []*domains.SigningKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (r *mongoSigningKeyRepository) Retire(ctx context.Context, exceptID string, retiredAt time.Time, publishUntil time.Time) error {
+	collection := r.db.Collection(signingKeysCollection)
+
+	filter := bson.M{
+		"_id":       bson.M{"$ne": exceptID},
+		"retiredAt": bson.M{"$exists": false},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"retiredAt":    retiredAt,
+			"publishUntil": publishUntil,
+		},
+	}
+
+	_, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to retire signing keys: %w", err)
+	}
+
+	return nil
+}
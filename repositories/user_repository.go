@@ -0,0 +1,307 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	usersCollection = "users"
+)
+
+type mongoUserRepository struct {
+	db lib.Database
+}
+
+// NewMongoUserRepository creates a new MongoDB repository for users
+func NewMongoUserRepository(db lib.Database) (services.UserRepository, error) {
+	collection := db.Collection(usersCollection)
+
+	// Emails are stored normalized, so one address belongs to a single user.
+	// Accounts without email, e.g. from providers not sharing it, are left out.
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string", "$gt": ""}}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user email index: %w", err)
+	}
+
+	return &mongoUserRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoUserRepository) Create(ctx context.Context, user *domains.User) error {
+	collection := r.db.Collection(usersCollection)
+
+	if user.ID == "" {
+		user.ID = primitive.NewObjectID().Hex()
+	}
+
+	_, err := collection.InsertOne(ctx, user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return domains.ErrEmailTaken
+		}
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoUserRepository) GetByID(ctx context.Context, id string) (*domains.User, error) {
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+func (r *mongoUserRepository) GetByEmail(ctx context.Context, email string) (*domains.User, error) {
+	return r.findOne(ctx, bson.M{"email": email})
+}
+
+func (r *mongoUserRepository) SetPasswordHash(ctx context.Context, userID string, passwordHash string) error {
+	return r.updateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"passwordHash": passwordHash}})
+}
+
+func (r *mongoUserRepository) MarkEmailVerified(ctx context.Context, userID string, email string) error {
+	// Matching the email keeps a concurrent change of address unverified
+	return r.updateOne(ctx, bson.M{"_id": userID, "email": email}, bson.M{"$set": bson.M{"emailVerified": true}})
+}
+
+func (r *mongoUserRepository) ClaimEmail(ctx context.Context, userID string, email string) (bool, error) {
+	collection := r.db.Collection(usersCollection)
+
+	// Only the first claim of a still unverified address matches
+	filter := bson.M{"_id": userID, "email": email, "emailVerified": bson.M{"$ne": true}}
+	update := bson.M{
+		"$set": bson.M{
+			"emailVerified": true,
+			"mfa.enabled":   false,
+			"mfa.webauthn":  false,
+			"updatedAt":     time.Now(),
+		},
+		"$unset": bson.M{
+			"passwordHash":          "",
+			"mfa.enabledAt":         "",
+			"mfa.totpSecret":        "",
+			"mfa.pendingTotpSecret": "",
+			"mfa.lastTotpStep":      "",
+			"mfa.recoveryCodes":     "",
+			"mfa.failedAttempts":    "",
+			"mfa.lockedUntil":       "",
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim email: %w", err)
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *mongoUserRepository) SetRoles(ctx context.Context, userID string, roles []string) error {
+	update := bson.M{"$set": bson.M{"roles": roles}}
+	if len(roles) == 0 {
+		update = bson.M{"$unset": bson.M{"roles": ""}}
+	}
+	return r.updateOne(ctx, bson.M{"_id": userID}, update)
+}
+
+func (r *mongoUserRepository) SetSuspended(ctx context.Context, userID string, suspendedAt *time.Time) error {
+	update := bson.M{"$set": bson.M{"suspendedAt": suspendedAt}}
+	if suspendedAt == nil {
+		update = bson.M{"$unset": bson.M{"suspendedAt": ""}}
+	}
+	return r.updateOne(ctx, bson.M{"_id": userID}, update)
+}
+
+func (r *mongoUserRepository) SetWebAuthnMFA(ctx context.Context, userID string, enabled bool) error {
+	update := bson.M{"$set": bson.M{"mfa.webauthn": true}}
+	if !enabled {
+		update = bson.M{"$unset": bson.M{"mfa.webauthn": ""}}
+	}
+	return r.updateOne(ctx, bson.M{"_id": userID}, update)
+}
+
+func (r *mongoUserRepository) SetPendingTOTPSecret(ctx context.Context, userID string, secret []byte) (bool, error) {
+	filter := bson.M{"_id": userID, "mfa.enabled": bson.M{"$ne": true}}
+	update := bson.M{"$set": bson.M{"mfa.pendingTotpSecret": secret, "updatedAt": time.Now()}}
+
+	result, err := r.db.Collection(usersCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+func (r *mongoUserRepository) EnableTOTP(ctx context.Context, userID string, pendingSecret []byte, step int64, recoveryCodes []string, enabledAt time.Time) (bool, error) {
+	// Matching the pending secret makes a concurrent enrollment void this confirmation
+	filter := bson.M{
+		"_id":                   userID,
+		"mfa.enabled":           bson.M{"$ne": true},
+		"mfa.pendingTotpSecret": pendingSecret,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"mfa.enabled":       true,
+			"mfa.enabledAt":     enabledAt,
+			"mfa.totpSecret":    pendingSecret,
+			"mfa.lastTotpStep":  step,
+			"mfa.recoveryCodes": recoveryCodes,
+			"updatedAt":         enabledAt,
+		},
+		"$unset": bson.M{
+			"mfa.pendingTotpSecret": "",
+			"mfa.failedAttempts":    "",
+			"mfa.lockedUntil":       "",
+		},
+	}
+
+	result, err := r.db.Collection(usersCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to enable totp: %w", err)
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+func (r *mongoUserRepository) SetRecoveryCodes(ctx context.Context, userID string, recoveryCodes []string) error {
+	return r.updateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"mfa.recoveryCodes": recoveryCodes}})
+}
+
+func (r *mongoUserRepository) AdvanceTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	collection := r.db.Collection(usersCollection)
+
+	// Matching only older steps makes concurrent uses of one code race for a single update
+	filter := bson.M{
+		"_id": userID,
+		"$or": bson.A{
+			bson.M{"mfa.lastTotpStep": bson.M{"$exists": false}},
+			bson.M{"mfa.lastTotpStep": bson.M{"$lt": step}},
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa.lastTotpStep": step}})
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp step: %w", err)
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *mongoUserRepository) ConsumeRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	collection := r.db.Collection(usersCollection)
+
+	filter := bson.M{"_id": userID, "mfa.recoveryCodes": codeHash}
+	update := bson.M{"$pull": bson.M{"mfa.recoveryCodes": codeHash}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to consume recovery code: %w", err)
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *mongoUserRepository) RecordMFAFailure(ctx context.Context, userID string, maxFailures int, lockedUntil time.Time) (bool, error) {
+	collection := r.db.Collection(usersCollection)
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$inc": bson.M{"mfa.failedAttempts": 1}})
+	if err != nil {
+		return false, fmt.Errorf("failed to record mfa failure: %w", err)
+	}
+
+	// Only one of several concurrent failures still matches once the counter is reset
+	filter := bson.M{"_id": userID, "mfa.failedAttempts": bson.M{"$gte": maxFailures}}
+	update := bson.M{
+		"$set":   bson.M{"mfa.lockedUntil": lockedUntil},
+		"$unset": bson.M{"mfa.failedAttempts": ""},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to lock mfa: %w", err)
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *mongoUserRepository) ResetMFAAttempts(ctx context.Context, userID string) error {
+	collection := r.db.Collection(usersCollection)
+
+	update := bson.M{"$unset": bson.M{"mfa.failedAttempts": "", "mfa.lockedUntil": ""}}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to reset mfa attempts: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoUserRepository) ClearTOTP(ctx context.Context, userID string) error {
+	update := bson.M{
+		"$set": bson.M{"mfa.enabled": false},
+		"$unset": bson.M{
+			"mfa.enabledAt":         "",
+			"mfa.totpSecret":        "",
+			"mfa.pendingTotpSecret": "",
+			"mfa.lastTotpStep":      "",
+			"mfa.recoveryCodes":     "",
+			"mfa.failedAttempts":    "",
+			"mfa.lockedUntil":       "",
+		},
+	}
+
+	return r.updateOne(ctx, bson.M{"_id": userID}, update)
+}
+
+// updateOne applies update to the matching user and bumps updatedAt, only the
+// fields named by update are written so concurrent changes to others survive
+func (r *mongoUserRepository) updateOne(ctx context.Context, filter bson.M, update bson.M) error {
+	set, _ := update["$set"].(bson.M)
+	if set == nil {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	set["updatedAt"] = time.Now()
+
+	result, err := r.db.Collection(usersCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return domains.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *mongoUserRepository) findOne(ctx context.Context, filter bson.M) (*domains.User, error) {
+	collection := r.db.Collection(usersCollection)
+
+	var user domains.User
+	err := collection.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	webAuthnCredentialsCollection = "webauthn_credentials"
+)
+
+type mongoWebAuthnCredentialRepository struct {
+	db lib.Database
+}
+
+// NewMongoWebAuthnCredentialRepository creates a MongoDB repository for passkeys
+func NewMongoWebAuthnCredentialRepository(db lib.Database) (services.WebAuthnCredentialRepository, error) {
+	collection := db.Collection(webAuthnCredentialsCollection)
+
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webauthn credential index: %w", err)
+	}
+
+	return &mongoWebAuthnCredentialRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoWebAuthnCredentialRepository) Save(ctx context.Context, credential *domains.WebAuthnCredential) error {
+	collection := r.db.Collection(webAuthnCredentialsCollection)
+
+	_, err := collection.InsertOne(ctx, credential)
+	if err != nil {
+		return fmt.Errorf("failed to save webauthn credential: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoWebAuthnCredentialRepository) ListByUser(ctx context.Context, userID string) ([]*domains.WebAuthnCredential, error) {
+	collection := r.db.Collection(webAuthnCredentialsCollection)
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webauthn credentials: %w", err)
+	}
+
+	credentials := []*domains.WebAuthnCredential{}
+	if err := cursor.All(ctx, &credentials); err != nil {
+		return nil, fmt.Errorf("failed to list webauthn credentials: %w", err)
+	}
+
+	return credentials, nil
+}
+
+func (r *mongoWebAuthnCredentialRepository) UpdateUsage(ctx context.Context, id string, signCount uint32, backupState bool, usedAt time.Time) error {
+	collection := r.db.Collection(webAuthnCredentialsCollection)
+
+	update := bson.M{
+		"$set": bson.M{
+			"signCount":   signCount,
+			"backupState": backupState,
+			"lastUsedAt":  usedAt,
+		},
+	}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update webauthn credential: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoWebAuthnCredentialRepository) Delete(ctx context.Context, userID string, id string) error {
+	collection := r.db.Collection(webAuthnCredentialsCollection)
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id, "userId": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete webauthn credential: %w", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return domains.ErrCredentialNotFound
+	}
+
+	return nil
+}
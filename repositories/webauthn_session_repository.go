@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/services"
+)
+
+const (
+	webAuthnSessionsCollection = "webauthn_sessions"
+)
+
+type mongoWebAuthnSessionRepository struct {
+	db lib.Database
+}
+
+// NewMongoWebAuthnSessionRepository creates a MongoDB repository for pending
+// WebAuthn ceremonies whose documents are removed by a TTL index once expired
+func NewMongoWebAuthnSessionRepository(db lib.Database) (services.WebAuthnSessionRepository, error) {
+	collection := db.Collection(webAuthnSessionsCollection)
+
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webauthn session ttl index: %w", err)
+	}
+
+	return &mongoWebAuthnSessionRepository{
+		db: db,
+	}, nil
+}
+
+func (r *mongoWebAuthnSessionRepository) Save(ctx context.Context, session *domains.WebAuthnSession) error {
+	collection := r.db.Collection(webAuthnSessionsCollection)
+
+	_, err := collection.InsertOne(ctx, session)
+	if err != nil {
+		return fmt.Errorf("failed to save webauthn session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *mongoWebAuthnSessionRepository) Consume(ctx context.Context, id string) (*domains.WebAuthnSession, error) {
+	collection := r.db.Collection(webAuthnSessionsCollection)
+
+	var session domains.WebAuthnSession
+	err := collection.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, domains.ErrInvalidWebAuthnSession
+		}
+		return nil, fmt.Errorf("failed to get webauthn session: %w", err)
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return nil, domains.ErrInvalidWebAuthnSession
+	}
+
+	return &session, nil
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+)
+
+const (
+	apiKeyTokenPrefix  = "dk_"
+	apiKeyPrefixLength = 12
+	// apiKeyUsageInterval limits how often last use is written for a busy key
+	apiKeyUsageInterval = time.Minute
+)
+
+// APIKeyService manages API keys and authenticates requests made with them
+type APIKeyService interface {
+	// Create issues a key for owner on behalf of the user creatorID, the owner
+	// itself for user keys. The key may only carry scopes the creator holds, a
+	// ttl of zero creates a key that never expires.
+	Create(ctx context.Context, owner domains.APIKeyOwner, creatorID string, name string, scopes []string, ttl time.Duration) (*domains.CreatedAPIKey, error)
+	List(ctx context.Context, owner domains.APIKeyOwner) ([]*domains.APIKey, error)
+	Get(ctx context.Context, owner domains.APIKeyOwner, id string) (*domains.APIKey, error)
+	// Update renames the key and replaces its scopes, empty values are left unchanged
+	Update(ctx context.Context, owner domains.APIKeyOwner, id string, name string, scopes []string) (*domains.APIKey, error)
+	Delete(ctx context.Context, owner domains.APIKeyOwner, id string) error
+
+	// Authenticate resolves a presented key into the claims of the request. The
+	// permissions are the key's scopes still held by its grantor.
+	Authenticate(ctx context.Context, key string) (*domains.APIKey, *domains.AccessClaims, error)
+}
+
+// APIKeyRepository defines the interface for API key persistence
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *domains.APIKey) error
+	GetByHash(ctx context.Context, keyHash string) (*domains.APIKey, error)
+	Get(ctx context.Context, owner domains.APIKeyOwner, id string) (*domains.APIKey, error)
+	ListByOwner(ctx context.Context, owner domains.APIKeyOwner) ([]*domains.APIKey, error)
+	Update(ctx context.Context, key *domains.APIKey) error
+	Delete(ctx context.Context, owner domains.APIKeyOwner, id string) error
+	TouchLastUsed(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// apiKeyService implements APIKeyService
+type apiKeyService struct {
+	logger lib.Logger
+	keys   APIKeyRepository
+	users  UserRepository
+	roles  RoleService
+}
+
+// NewAPIKeyService creates a new API key service
+func NewAPIKeyService(
+	logger lib.Logger,
+	keys APIKeyRepository,
+	users UserRepository,
+	roles RoleService,
+) APIKeyService {
+	return &apiKeyService{
+		logger: logger,
+		keys:   keys,
+		users:  users,
+		roles:  roles,
+	}
+}
+
+func (s *apiKeyService) Create(ctx context.Context, owner domains.APIKeyOwner, creatorID string, name string, scopes []string, ttl time.Duration) (*domains.CreatedAPIKey, error) {
+	if owner.Type == domains.UserAPIKeyOwner {
+		creatorID = owner.ID
+	}
+
+	scopes, err := s.checkScopes(ctx, creatorID, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate api key: %w", err)
+	}
+	key := apiKeyTokenPrefix + secret
+
+	now := time.Now()
+	apiKey := &domains.APIKey{
+		Name:      name,
+		Prefix:    key[:apiKeyPrefixLength],
+		KeyHash:   hashToken(key),
+		Owner:     owner,
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if owner.Type != domains.UserAPIKeyOwner {
+		apiKey.CreatedBy = creatorID
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if err := s.keys.Create(ctx, apiKey); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Created api key ", apiKey.ID, " for ", owner.Subject())
+
+	return &domains.CreatedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+func (s *apiKeyService) List(ctx context.Context, owner domains.APIKeyOwner) ([]*domains.APIKey, error) {
+	return s.keys.ListByOwner(ctx, owner)
+}
+
+func (s *apiKeyService) Get(ctx context.Context, owner domains.APIKeyOwner, id string) (*domains.APIKey, error) {
+	return s.keys.Get(ctx, owner, id)
+}
+
+func (s *apiKeyService) Update(ctx context.Context, owner domains.APIKeyOwner, id string, name string, scopes []string) (*domains.APIKey, error) {
+	apiKey, err := s.keys.Get(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != "" {
+		apiKey.Name = name
+	}
+	if scopes != nil {
+		apiKey.Scopes, err = s.checkScopes(ctx, apiKey.Grantor(), scopes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.keys.Update(ctx, apiKey); err != nil {
+		return nil, err
+	}
+
+	return apiKey, nil
+}
+
+func (s *apiKeyService) Delete(ctx context.Context, owner domains.APIKeyOwner, id string) error {
+	return s.keys.Delete(ctx, owner, id)
+}
+
+func (s *apiKeyService) Authenticate(ctx context.Context, key string) (*domains.APIKey, *domains.AccessClaims, error) {
+	if !strings.HasPrefix(key, apiKeyTokenPrefix) {
+		return nil, nil, domains.ErrInvalidAPIKey
+	}
+
+	apiKey, err := s.keys.GetByHash(ctx, hashToken(key))
+	if err != nil {
+		if errors.Is(err, domains.ErrAPIKeyNotFound) {
+			return nil, nil, domains.ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+		return nil, nil, domains.ErrInvalidAPIKey
+	}
+
+	// Service account keys created before they recorded their creator grant nothing
+	if apiKey.Grantor() == "" {
+		return nil, nil, domains.ErrInvalidAPIKey
+	}
+	user, err := s.users.GetByID(ctx, apiKey.Grantor())
+	if err != nil {
+		if errors.Is(err, domains.ErrUserNotFound) {
+			return nil, nil, domains.ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	if user.SuspendedAt != nil {
+		return nil, nil, domains.ErrInvalidAPIKey
+	}
+
+	// Scopes the grantor lost since the key was created are not granted
+	held, err := s.roles.Permissions(ctx, user.Roles)
+	if err != nil {
+		return nil, nil, err
+	}
+	permissions := grantedScopes(apiKey.Scopes, held)
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyUsageInterval {
+		if err := s.keys.TouchLastUsed(ctx, apiKey.ID, now); err != nil {
+			// Tracking use must not fail the request
+			s.logger.Error("Failed to record api key use: ", err)
+		}
+	}
+
+	claims := &domains.AccessClaims{Permissions: permissions}
+	claims.Subject = apiKey.Owner.Subject()
+
+	return apiKey, claims, nil
+}
+
+// checkScopes normalizes scopes, requiring the grantor of a key to hold every
+// scope of it. Service accounts have no permissions of their own, so their keys
+// are bound by the user who created them.
+func (s *apiKeyService) checkScopes(ctx context.Context, grantorID string, scopes []string) ([]string, error) {
+	scopes = uniqueSorted(scopes)
+	for _, scope := range scopes {
+		if strings.ContainsAny(scope, " \t") {
+			return nil, domains.ErrInvalidScope
+		}
+	}
+
+	if grantorID == "" {
+		return nil, domains.ErrAPIKeyCreatorMissing
+	}
+
+	user, err := s.users.GetByID(ctx, grantorID)
+	if err != nil {
+		return nil, err
+	}
+	held, err := s.roles.Permissions(ctx, user.Roles)
+	if err != nil {
+		return nil, err
+	}
+	if len(grantedScopes(scopes, held)) != len(scopes) {
+		return nil, domains.ErrInvalidScope
+	}
+
+	return scopes, nil
+}
+
+// grantedScopes returns the scopes covered by the held permissions
+func grantedScopes(scopes []string, held []string) []string {
+	granted := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if domains.HasPermission(held, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted
+}
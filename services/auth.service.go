@@ -1,26 +1,246 @@
 package services
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
 	"diandi-backend/domains"
 	"diandi-backend/lib"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultTokenExpiration = 15 * time.Minute
+	defaultTokenIssuer     = "diandi"
+	defaultTokenAudience   = "diandi"
+
+	// accessTokenType marks access tokens in the typ header (RFC 9068) so they
+	// are not mistaken for ID or action tokens signed with the same keys
+	accessTokenType = "at+jwt"
 )
 
 type AuthService struct {
-	logger lib.Logger
-	env    lib.Env
+	logger     lib.Logger
+	env        lib.Env
+	keys       *keyRing
+	expiration time.Duration
+	issuer     string
+	audience   string
+}
+
+func NewAuthService(env lib.Env, logger lib.Logger, signingKeys SigningKeyService) (domains.AuthService, error) {
+	keys, err := newKeyRing(env, signingKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	service := AuthService{
+		env:        env,
+		logger:     logger,
+		keys:       keys,
+		expiration: tokenExpiration(env),
+		issuer:     env.JWTIssuer,
+		audience:   env.JWTAudience,
+	}
+	if service.issuer == "" {
+		service.issuer = defaultTokenIssuer
+	}
+	if service.audience == "" {
+		service.audience = defaultTokenAudience
+	}
+
+	return service, nil
 }
 
-func NewAuthService(env lib.Env, logger lib.Logger) domains.AuthService {
-	return AuthService{
-		env:    env,
-		logger: logger,
+func (as AuthService) Authorize(tokenString string) (*domains.AccessClaims, error) {
+	claims := &domains.AccessClaims{}
+	token, err := as.parse(tokenString, claims, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
 	}
+
+	// Access tokens issued before they were typed all carry our own audience
+	typ, _ := token.Header["typ"].(string)
+	if typ != accessTokenType && !slices.Contains(claims.Audience, as.audience) {
+		return nil, errors.New("invalid token: not an access token")
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("invalid token: missing subject")
+	}
+
+	return claims, nil
+}
+
+func (as AuthService) CreateToken(claims *domains.AccessClaims) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	now := time.Now()
+	claims.ID = jti
+	claims.Issuer = as.issuer
+	if len(claims.Audience) == 0 {
+		claims.Audience = jwt.ClaimStrings{as.audience}
+	}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(as.expiration))
+
+	return as.sign(claims, accessTokenType)
+}
+
+func (as AuthService) CreateIDToken(claims *domains.IDClaims) (string, error) {
+	if !as.SupportsIDTokens() {
+		return "", domains.ErrOpenIDUnavailable
+	}
+
+	jti, err := newTokenID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	now := time.Now()
+	claims.ID = jti
+	claims.Issuer = as.issuer
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(as.expiration))
+
+	return as.sign(claims, "")
+}
+
+func (as AuthService) SupportsIDTokens() bool {
+	key, err := as.keys.signing()
+	return err == nil && key.method != jwt.SigningMethodHS256
+}
+
+func (as AuthService) Issuer() string {
+	return as.issuer
 }
 
-func (as AuthService) Authorize(tokenString string) (bool, error) {
-	return true, nil
+func (as AuthService) Audience() string {
+	return as.audience
 }
 
-func (as AuthService) CreateToken() string {
-	return ""
+func (as AuthService) CreateActionToken(purpose string, claims *domains.ActionClaims, ttl time.Duration) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	now := time.Now()
+	claims.ID = jti
+	claims.Issuer = as.issuer
+	claims.Audience = jwt.ClaimStrings{as.actionAudience(purpose)}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+
+	return as.sign(claims, "")
+}
+
+func (as AuthService) VerifyActionToken(purpose string, tokenString string) (*domains.ActionClaims, error) {
+	claims := &domains.ActionClaims{}
+	if _, err := as.parse(tokenString, claims, as.actionAudience(purpose)); err != nil {
+		as.logger.Debug("Invalid action token: ", err)
+		return nil, domains.ErrInvalidActionToken
+	}
+
+	if claims.Subject == "" {
+		return nil, domains.ErrInvalidActionToken
+	}
+
+	return claims, nil
+}
+
+// actionAudience scopes action tokens to their purpose
+func (as AuthService) actionAudience(purpose string) string {
+	return as.audience + ":" + purpose
+}
+
+// sign signs claims with the current signing key, naming it in the kid header.
+// The typ header defaults to JWT.
+func (as AuthService) sign(claims jwt.Claims, typ string) (string, error) {
+	key, err := as.keys.signing()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	if typ != "" {
+		token.Header["typ"] = typ
+	}
+	if key.id != "" {
+		token.Header["kid"] = key.id
+	}
+
+	signed, err := token.SignedString(key.signKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return signed, nil
+}
+
+// parse verifies the signature and the standard claims of a token issued for
+// audience, any audience is accepted when it is empty
+func (as AuthService) parse(tokenString string, claims jwt.Claims, audience string) (*jwt.Token, error) {
+	options := []jwt.ParserOption{
+		jwt.WithIssuer(as.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+
+	return jwt.ParseWithClaims(
+		tokenString,
+		claims,
+		func(token *jwt.Token) (interface{}, error) {
+			// Tokens without a kid were signed by a static HS256 secret
+			kid, _ := token.Header["kid"].(string)
+			key, ok := as.keys.lookup(kid)
+			if !ok {
+				return nil, fmt.Errorf("unknown signing key %q", kid)
+			}
+			if token.Method.Alg() != key.method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+			}
+			return key.verifyKey, nil
+		},
+		options...,
+	)
+}
+
+func (as AuthService) JWKS() *domains.JSONWebKeySet {
+	set := &domains.JSONWebKeySet{Keys: []domains.JSONWebKey{}}
+	for _, key := range as.keys.all() {
+		// A shared HS256 secret is never published
+		if key.method == jwt.SigningMethodHS256 {
+			continue
+		}
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	return set
+}
+
+// tokenExpiration returns the configured access token lifetime
+func tokenExpiration(env lib.Env) time.Duration {
+	if env.JWTExpiration <= 0 {
+		return defaultTokenExpiration
+	}
+	return env.JWTExpiration
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
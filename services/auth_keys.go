@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// DatabaseKeySource signs with the rotating keys managed by SigningKeyService
+	DatabaseKeySource = "database"
+
+	keyRefreshInterval    = time.Minute
+	keyMinRefreshInterval = 10 * time.Second
+	keyLoadTimeout        = 10 * time.Second
+)
+
+// authKey is a signing key together with its verification half
+type authKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// keyRing holds the keys used to sign and verify access tokens, the first key
+// signs new tokens. Database backed rings reload periodically and whenever a
+// token carries an unknown kid, so rotations reach every instance.
+type keyRing struct {
+	mu       sync.RWMutex
+	keys     []authKey
+	load     func() ([]authKey, error)
+	loadedAt time.Time
+}
+
+// newKeyRing creates the key ring selected by JWT_KEY_SOURCE
+func newKeyRing(env lib.Env, signingKeys SigningKeyService) (*keyRing, error) {
+	if env.JWTKeySource != DatabaseKeySource {
+		key, err := loadSigningKey(env)
+		if err != nil {
+			return nil, err
+		}
+		return &keyRing{keys: []authKey{key}}, nil
+	}
+
+	ring := &keyRing{
+		load: func() ([]authKey, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), keyLoadTimeout)
+			defer cancel()
+
+			published, err := signingKeys.PublishedKeys(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			keys := make([]authKey, 0, len(published))
+			for _, key := range published {
+				authKey, err := newAuthKey(key.ID, key.Key)
+				if err != nil {
+					return nil, err
+				}
+				keys = append(keys, authKey)
+			}
+			return keys, nil
+		},
+	}
+	if err := ring.reload(); err != nil {
+		return nil, fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	return ring, nil
+}
+
+// signing returns the key new tokens are signed with
+func (r *keyRing) signing() (authKey, error) {
+	r.refresh(keyRefreshInterval)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.keys) == 0 {
+		return authKey{}, errors.New("no signing key available")
+	}
+	return r.keys[0], nil
+}
+
+// lookup returns the key matching kid, reloading once if the kid is unknown
+func (r *keyRing) lookup(kid string) (authKey, bool) {
+	r.refresh(keyRefreshInterval)
+	if key, ok := r.find(kid); ok {
+		return key, true
+	}
+
+	r.refresh(keyMinRefreshInterval)
+	return r.find(kid)
+}
+
+func (r *keyRing) find(kid string) (authKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, key := range r.keys {
+		if key.id == kid {
+			return key, true
+		}
+	}
+	return authKey{}, false
+}
+
+// all returns every key still valid for verification
+func (r *keyRing) all() []authKey {
+	r.refresh(keyRefreshInterval)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]authKey(nil), r.keys...)
+}
+
+// refresh reloads the keys when they are older than maxAge, keeping the current
+// keys if the reload fails
+func (r *keyRing) refresh(maxAge time.Duration) {
+	if r.load == nil {
+		return
+	}
+
+	r.mu.RLock()
+	fresh := time.Since(r.loadedAt) < maxAge
+	r.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	_ = r.reload()
+}
+
+func (r *keyRing) reload() error {
+	keys, err := r.load()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Failed reloads also count so a database outage is not hammered on every request
+	r.loadedAt = time.Now()
+	if err != nil {
+		return err
+	}
+	r.keys = keys
+	return nil
+}
+
+// loadSigningKey resolves the signing method and key pair from the JWT_* settings.
+// HS256 uses JWT_SECRET for both signing and verification, RS256 and EdDSA read a
+// PEM encoded private key from JWT_PRIVATE_KEY_PATH and verify with its public half.
+func loadSigningKey(env lib.Env) (authKey, error) {
+	switch env.JWTAlgorithm {
+	case "", jwt.SigningMethodHS256.Alg():
+		if env.JWTSecret == "" {
+			return authKey{}, errors.New("JWT_SECRET is required for HS256")
+		}
+		secret := []byte(env.JWTSecret)
+		return authKey{method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}, nil
+	case jwt.SigningMethodRS256.Alg():
+		pem, err := os.ReadFile(env.JWTPrivateKeyPath)
+		if err != nil {
+			return authKey{}, fmt.Errorf("failed to read JWT private key: %w", err)
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return authKey{}, fmt.Errorf("failed to parse JWT private key: %w", err)
+		}
+		return newAuthKey("", key)
+	case jwt.SigningMethodEdDSA.Alg():
+		pem, err := os.ReadFile(env.JWTPrivateKeyPath)
+		if err != nil {
+			return authKey{}, fmt.Errorf("failed to read JWT private key: %w", err)
+		}
+		key, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+		if err != nil {
+			return authKey{}, fmt.Errorf("failed to parse JWT private key: %w", err)
+		}
+		return newAuthKey("", key)
+	default:
+		return authKey{}, fmt.Errorf("unsupported JWT algorithm: %s", env.JWTAlgorithm)
+	}
+}
+
+// newAuthKey wraps an RSA or Ed25519 private key, an empty kid defaults to the
+// RFC 7638 thumbprint of the public key
+func newAuthKey(kid string, private interface{}) (authKey, error) {
+	key := authKey{id: kid, signKey: private}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+		key.verifyKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.verifyKey = k.Public().(ed25519.PublicKey)
+	default:
+		return authKey{}, errors.New("unsupported signing key type")
+	}
+
+	if key.id == "" {
+		thumbprint, err := jwkThumbprint(key.jwk())
+		if err != nil {
+			return authKey{}, err
+		}
+		key.id = thumbprint
+	}
+
+	return key, nil
+}
+
+// jwk returns the public half of an asymmetric key as a JSON Web Key
+func (k authKey) jwk() domains.JSONWebKey {
+	jwk := domains.JSONWebKey{
+		KeyID:     k.id,
+		Use:       "sig",
+		Algorithm: k.method.Alg(),
+	}
+
+	switch public := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+
+	return jwk
+}
+
+// jwkThumbprint computes the RFC 7638 thumbprint over the required members
+func jwkThumbprint(jwk domains.JSONWebKey) (string, error) {
+	var members interface{}
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	default:
+		return "", errors.New("unsupported key type for thumbprint")
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+)
+
+const (
+	defaultEmailLoginTTL = 10 * time.Minute
+	emailLoginTimeout    = 30 * time.Second
+	// emailLoginCooldown keeps a pending login from being replaced, and mailed
+	// again, right after it was requested
+	emailLoginCooldown    = time.Minute
+	emailLoginMaxAttempts = 5
+	emailLoginCodeDigits  = 6
+)
+
+// EmailLoginService signs users in with a link or code mailed to them, creating
+// an account on first sign in
+type EmailLoginService interface {
+	// RequestLogin mails a magic link and a one-time code to email. It returns
+	// before any lookup happens, so callers learn nothing about the email.
+	RequestLogin(email string)
+
+	// VerifyToken redeems the token of a magic link and returns the user to sign in
+	VerifyToken(ctx context.Context, token string) (*domains.User, error)
+	// VerifyCode redeems the code mailed to email and returns the user to sign
+	// in. Wrong codes count against the pending login until it is spent.
+	VerifyCode(ctx context.Context, email string, code string) (*domains.User, error)
+}
+
+// EmailLoginRepository defines the interface for pending email login persistence
+type EmailLoginRepository interface {
+	// Save stores the login, replacing any pending login for the same email
+	Save(ctx context.Context, login *domains.EmailLogin) error
+	Get(ctx context.Context, email string) (*domains.EmailLogin, error)
+	// ConsumeToken returns and removes the login matching the link token
+	ConsumeToken(ctx context.Context, tokenHash string) (*domains.EmailLogin, error)
+	// ConsumeCode returns and removes the login of email when the code matches
+	// and fewer than maxAttempts wrong codes were tried
+	ConsumeCode(ctx context.Context, email string, codeHash string, maxAttempts int) (*domains.EmailLogin, error)
+	// RecordFailure counts a wrong code, removing the login after maxAttempts
+	RecordFailure(ctx context.Context, email string, maxAttempts int) error
+}
+
+// emailLoginService implements EmailLoginService
+type emailLoginService struct {
+	logger        lib.Logger
+	users         UserRepository
+	logins        EmailLoginRepository
+	credentials   WebAuthnCredentialRepository
+	refreshTokens RefreshTokenRepository
+	revocations   TokenRevocationService
+	mailer        lib.Mailer
+	loginURL      string
+	ttl           time.Duration
+}
+
+// NewEmailLoginService creates a new email login service
+func NewEmailLoginService(
+	env lib.Env,
+	logger lib.Logger,
+	users UserRepository,
+	logins EmailLoginRepository,
+	credentials WebAuthnCredentialRepository,
+	refreshTokens RefreshTokenRepository,
+	revocations TokenRevocationService,
+	mailer lib.Mailer,
+) EmailLoginService {
+	ttl := env.EmailLoginTTL
+	if ttl <= 0 {
+		ttl = defaultEmailLoginTTL
+	}
+
+	return &emailLoginService{
+		logger:        logger,
+		users:         users,
+		logins:        logins,
+		credentials:   credentials,
+		refreshTokens: refreshTokens,
+		revocations:   revocations,
+		mailer:        mailer,
+		loginURL:      env.EmailLoginURL,
+		ttl:           ttl,
+	}
+}
+
+func (s *emailLoginService) RequestLogin(email string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailLoginTimeout)
+		defer cancel()
+
+		if err := s.requestLogin(ctx, normalizeEmail(email)); err != nil {
+			s.logger.Error("Failed to request email login: ", err)
+		}
+	}()
+}
+
+func (s *emailLoginService) requestLogin(ctx context.Context, email string) error {
+	if email == "" || !strings.Contains(email, "@") {
+		return nil
+	}
+
+	pending, err := s.logins.Get(ctx, email)
+	if err != nil && !errors.Is(err, domains.ErrInvalidEmailLogin) {
+		return err
+	}
+	if pending != nil && time.Since(pending.CreatedAt) < emailLoginCooldown {
+		s.logger.Info("Email login requested again within the cooldown")
+		return nil
+	}
+
+	user, err := s.users.GetByEmail(ctx, email)
+	if err != nil && !errors.Is(err, domains.ErrUserNotFound) {
+		return err
+	}
+	if user != nil && user.SuspendedAt != nil {
+		s.logger.Info("Email login requested for suspended user ", user.ID)
+		return nil
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate email login token: %w", err)
+	}
+	code, err := randomCode(emailLoginCodeDigits)
+	if err != nil {
+		return fmt.Errorf("failed to generate email login code: %w", err)
+	}
+
+	now := time.Now()
+	err = s.logins.Save(ctx, &domains.EmailLogin{
+		Email:     email,
+		TokenHash: hashToken(token),
+		CodeHash:  hashEmailLoginCode(email, code),
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, lib.Message{
+		To:      email,
+		Subject: "Your sign in code: " + code,
+		Body: fmt.Sprintf(
+			"Enter this code to sign in:\n\n%s\n\nOr %s\n\nThe code and link expire in %s and work once. If you did not ask to sign in, ignore this email.\n",
+			code, s.loginInstructions(token), s.ttl,
+		),
+	})
+}
+
+// loginInstructions points to the frontend sign in page, or hands out the bare
+// token when EMAIL_LOGIN_URL is not configured
+func (s *emailLoginService) loginInstructions(token string) string {
+	target, err := url.Parse(s.loginURL)
+	if s.loginURL == "" || err != nil {
+		return "use this sign in token:\n\n" + token
+	}
+
+	query := target.Query()
+	query.Set("token", token)
+	target.RawQuery = query.Encode()
+
+	return "open the link below:\n\n" + target.String()
+}
+
+func (s *emailLoginService) VerifyToken(ctx context.Context, token string) (*domains.User, error) {
+	if token == "" {
+		return nil, domains.ErrInvalidEmailLogin
+	}
+
+	login, err := s.logins.ConsumeToken(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.signIn(ctx, login.Email)
+}
+
+func (s *emailLoginService) VerifyCode(ctx context.Context, email string, code string) (*domains.User, error) {
+	email = normalizeEmail(email)
+	code = strings.TrimSpace(code)
+	if email == "" || len(code) != emailLoginCodeDigits {
+		return nil, domains.ErrInvalidEmailLogin
+	}
+
+	login, err := s.logins.ConsumeCode(ctx, email, hashEmailLoginCode(email, code), emailLoginMaxAttempts)
+	if err != nil {
+		if errors.Is(err, domains.ErrInvalidEmailLogin) {
+			if err := s.logins.RecordFailure(ctx, email, emailLoginMaxAttempts); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	return s.signIn(ctx, login.Email)
+}
+
+// signIn returns the user owning email, creating one on first sign in. Redeeming
+// the mailed login proves ownership of the address.
+func (s *emailLoginService) signIn(ctx context.Context, email string) (*domains.User, error) {
+	now := time.Now()
+
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, domains.ErrUserNotFound) {
+		user = &domains.User{
+			Email:         email,
+			EmailVerified: true,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		err = s.users.Create(ctx, user)
+		if err == nil {
+			s.logger.Info("Created user ", user.ID, " through email login")
+			return user, nil
+		}
+		// Another sign in created the user in the meantime
+		if !errors.Is(err, domains.ErrEmailTaken) {
+			return nil, err
+		}
+		user, err = s.users.GetByEmail(ctx, email)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.EmailVerified {
+		if err := s.claim(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// claim hands an account registered with an unverified email over to the owner
+// of the address. Whoever registered it may have been someone else, so their
+// password, second factors and sessions must not outlive the claim.
+func (s *emailLoginService) claim(ctx context.Context, user *domains.User) error {
+	claimed, err := s.users.ClaimEmail(ctx, user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+	if claimed {
+		s.logger.Info("User ", user.ID, " claimed through email login, signing out previous sessions")
+
+		credentials, err := s.credentials.ListByUser(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		for _, credential := range credentials {
+			if err := s.credentials.Delete(ctx, user.ID, credential.ID); err != nil && !errors.Is(err, domains.ErrCredentialNotFound) {
+				return err
+			}
+		}
+
+		if err := s.refreshTokens.RevokeUser(ctx, user.ID, time.Now()); err != nil {
+			return err
+		}
+		if err := s.revocations.RevokeUser(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	// Reload the account as the claim, ours or a concurrent one, left it
+	claimedUser, err := s.users.GetByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	*user = *claimedUser
+
+	return nil
+}
+
+// randomCode returns a uniformly random numeric code of the given length
+func randomCode(digits int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < digits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
+
+// hashEmailLoginCode binds the short code to its email so equal codes of
+// different logins never share a hash
+func hashEmailLoginCode(email string, code string) string {
+	return hashToken(email + ":" + code)
+}
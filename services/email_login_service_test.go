@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testEmail = "jane@example.com"
+
+// discardVerification stands in for the verification mail sent on registration
+type discardVerification struct{}
+
+func (discardVerification) SendVerification(ctx context.Context, user *domains.User) error {
+	return nil
+}
+
+func (discardVerification) ConfirmEmail(ctx context.Context, token string) (*domains.User, error) {
+	return nil, domains.ErrInvalidActionToken
+}
+
+type emailLoginTest struct {
+	service       *emailLoginService
+	users         *fakeUserRepository
+	accounts      UserService
+	credentials   *fakeWebAuthnCredentialRepository
+	refreshTokens *fakeRefreshTokenRepository
+	revocations   TokenRevocationService
+	mailer        *fakeMailer
+}
+
+func newEmailLoginTest(t *testing.T) *emailLoginTest {
+	logger := testLogger()
+	env := lib.Env{PasswordHashMemory: 1024, PasswordHashIterations: 1, PasswordHashParallelism: 1}
+
+	et := &emailLoginTest{
+		users:         newFakeUserRepository(),
+		credentials:   &fakeWebAuthnCredentialRepository{},
+		refreshTokens: &fakeRefreshTokenRepository{},
+		mailer:        &fakeMailer{},
+	}
+	et.revocations = NewTokenRevocationService(env, logger, &fakeRevokedTokenRepository{})
+	et.accounts = NewUserService(
+		logger,
+		et.users,
+		&fakeOAuthRepository{},
+		et.refreshTokens,
+		et.revocations,
+		discardVerification{},
+		NewPasswordHasher(env),
+	)
+	et.service = NewEmailLoginService(
+		env,
+		logger,
+		et.users,
+		&fakeEmailLoginRepository{},
+		et.credentials,
+		et.refreshTokens,
+		et.revocations,
+		et.mailer,
+	).(*emailLoginService)
+
+	return et
+}
+
+// signInByCode requests an email login and redeems the mailed code
+func (et *emailLoginTest) signInByCode(t *testing.T, email string) *domains.User {
+	ctx := context.Background()
+
+	if err := et.service.requestLogin(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+	code := strings.TrimPrefix(et.mailer.last().Subject, "Your sign in code: ")
+
+	user, err := et.service.VerifyCode(ctx, email, code)
+	if err != nil {
+		t.Fatalf("email login failed: %v", err)
+	}
+	return user
+}
+
+func TestEmailLoginClaimsUnverifiedAccount(t *testing.T) {
+	et := newEmailLoginTest(t)
+	ctx := context.Background()
+
+	// Someone registers the address before its owner and sets up the account
+	if err := et.accounts.Register(ctx, testEmail, "registrant password", "Mallory"); err != nil {
+		t.Fatal(err)
+	}
+	registered, err := et.accounts.Authenticate(ctx, testEmail, "registrant password")
+	if err != nil {
+		t.Fatalf("registrant cannot sign in: %v", err)
+	}
+	et.users.update(registered.ID, func(user *domains.User) {
+		user.MFA = domains.MFA{Enabled: true, TOTPSecret: []byte("secret"), WebAuthn: true}
+	})
+	et.credentials.Save(ctx, &domains.WebAuthnCredential{ID: "passkey-1", UserID: registered.ID})
+	et.refreshTokens.Save(ctx, &domains.RefreshToken{TokenHash: "refresh-1", UserID: registered.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	session := &domains.AccessClaims{}
+	session.Subject = registered.ID
+	session.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	user := et.signInByCode(t, testEmail)
+
+	if user.ID != registered.ID || !user.EmailVerified {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	if user.PasswordHash != "" || user.MFA.Enabled || user.MFA.WebAuthn {
+		t.Fatalf("claimed account kept the registrant's credentials: %+v", user)
+	}
+
+	if _, err := et.accounts.Authenticate(ctx, testEmail, "registrant password"); !errors.Is(err, domains.ErrInvalidCredentials) {
+		t.Fatalf("registrant password after the claim: err = %v, want ErrInvalidCredentials", err)
+	}
+	if credentials, _ := et.credentials.ListByUser(ctx, user.ID); len(credentials) != 0 {
+		t.Fatalf("%d passkeys survived the claim", len(credentials))
+	}
+	if token, _ := et.refreshTokens.GetByHash(ctx, "refresh-1"); token.RevokedAt == nil {
+		t.Fatal("refresh token of the registrant was not revoked")
+	}
+	if !et.revocations.IsRevoked(session) {
+		t.Fatal("access token of the registrant was not revoked")
+	}
+}
+
+func TestEmailLoginKeepsVerifiedAccount(t *testing.T) {
+	et := newEmailLoginTest(t)
+	ctx := context.Background()
+
+	if err := et.accounts.Register(ctx, testEmail, "owner password", "Jane"); err != nil {
+		t.Fatal(err)
+	}
+	owner, _ := et.users.GetByEmail(ctx, testEmail)
+	if err := et.users.MarkEmailVerified(ctx, owner.ID, testEmail); err != nil {
+		t.Fatal(err)
+	}
+
+	et.signInByCode(t, testEmail)
+
+	if _, err := et.accounts.Authenticate(ctx, testEmail, "owner password"); err != nil {
+		t.Fatalf("password of a verified account stopped working: %v", err)
+	}
+}
+
+func TestEmailLoginCreatesVerifiedUser(t *testing.T) {
+	et := newEmailLoginTest(t)
+
+	user := et.signInByCode(t, testEmail)
+
+	if user.ID == "" || user.Email != testEmail || !user.EmailVerified || user.PasswordHash != "" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+}
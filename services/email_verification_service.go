@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+)
+
+const defaultEmailVerificationTTL = 24 * time.Hour
+
+// EmailVerificationService proves users own the email address of their account
+type EmailVerificationService interface {
+	// SendVerification mails a verification link for the current email of the user
+	SendVerification(ctx context.Context, user *domains.User) error
+
+	// ConfirmEmail marks the email named by a verification token as verified
+	ConfirmEmail(ctx context.Context, token string) (*domains.User, error)
+}
+
+// emailVerificationService implements EmailVerificationService
+type emailVerificationService struct {
+	logger      lib.Logger
+	authService domains.AuthService
+	users       UserRepository
+	mailer      lib.Mailer
+	confirmURL  string
+	ttl         time.Duration
+}
+
+// NewEmailVerificationService creates a new email verification service
+func NewEmailVerificationService(
+	env lib.Env,
+	logger lib.Logger,
+	authService domains.AuthService,
+	users UserRepository,
+	mailer lib.Mailer,
+) EmailVerificationService {
+	return &emailVerificationService{
+		logger:      logger,
+		authService: authService,
+		users:       users,
+		mailer:      mailer,
+		confirmURL:  strings.TrimRight(env.PublicURL, "/") + "/api/v1/auth/verify-email/confirm",
+		ttl:         emailVerificationTTL(env),
+	}
+}
+
+// emailVerificationTTL returns how long verification links stay valid
+func emailVerificationTTL(env lib.Env) time.Duration {
+	if env.EmailVerificationTTL <= 0 {
+		return defaultEmailVerificationTTL
+	}
+	return env.EmailVerificationTTL
+}
+
+func (s *emailVerificationService) SendVerification(ctx context.Context, user *domains.User) error {
+	if user.EmailVerified {
+		return domains.ErrEmailAlreadyVerified
+	}
+	if user.Email == "" {
+		return domains.ErrEmailMissing
+	}
+
+	// The token names the address so it is void once the user changes email
+	claims := &domains.ActionClaims{Email: user.Email}
+	claims.Subject = user.ID
+	token, err := s.authService.CreateActionToken(domains.EmailVerificationPurpose, claims, s.ttl)
+	if err != nil {
+		return err
+	}
+
+	link := s.confirmURL + "?" + url.Values{"token": {token}}.Encode()
+
+	return s.mailer.Send(ctx, lib.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Confirm that %s is your email address by opening the link below:\n\n%s\n\nThe link expires in %s. If you did not create an account, ignore this email.\n",
+			user.Email, link, s.ttl,
+		),
+	})
+}
+
+func (s *emailVerificationService) ConfirmEmail(ctx context.Context, token string) (*domains.User, error) {
+	claims, err := s.authService.VerifyActionToken(domains.EmailVerificationPurpose, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.GetByID(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, domains.ErrUserNotFound) {
+			return nil, domains.ErrInvalidActionToken
+		}
+		return nil, err
+	}
+
+	if !strings.EqualFold(user.Email, claims.Email) {
+		return nil, domains.ErrInvalidActionToken
+	}
+
+	if user.EmailVerified {
+		return user, nil
+	}
+
+	if err := s.users.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+		return nil, err
+	}
+	user.EmailVerified = true
+
+	return user, nil
+}
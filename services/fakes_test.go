@@ -0,0 +1,821 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"go.uber.org/zap"
+)
+
+// In-memory repositories standing in for MongoDB in service tests
+
+func testLogger() lib.Logger {
+	return lib.Logger{SugaredLogger: zap.NewNop().Sugar()}
+}
+
+type fakeUserRepository struct {
+	mu    sync.Mutex
+	users map[string]*domains.User
+}
+
+func newFakeUserRepository(users ...*domains.User) *fakeUserRepository {
+	r := &fakeUserRepository{users: make(map[string]*domains.User)}
+	for _, user := range users {
+		r.users[user.ID] = user
+	}
+	return r
+}
+
+// get returns a copy of the stored user so callers cannot change it behind the repository
+func (r *fakeUserRepository) get(id string) (*domains.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return nil, domains.ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (r *fakeUserRepository) update(id string, apply func(user *domains.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return domains.ErrUserNotFound
+	}
+	apply(user)
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *fakeUserRepository) Create(ctx context.Context, user *domains.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if user.Email != "" && existing.Email == user.Email {
+			return domains.ErrEmailTaken
+		}
+	}
+	if user.ID == "" {
+		user.ID = newTestID()
+	}
+	copied := *user
+	r.users[user.ID] = &copied
+	return nil
+}
+
+func (r *fakeUserRepository) GetByID(ctx context.Context, id string) (*domains.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.get(id)
+}
+
+func (r *fakeUserRepository) GetByEmail(ctx context.Context, email string) (*domains.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, user := range r.users {
+		if user.Email == email {
+			return r.get(id)
+		}
+	}
+	return nil, domains.ErrUserNotFound
+}
+
+func (r *fakeUserRepository) SetPasswordHash(ctx context.Context, userID string, passwordHash string) error {
+	return r.update(userID, func(user *domains.User) { user.PasswordHash = passwordHash })
+}
+
+func (r *fakeUserRepository) MarkEmailVerified(ctx context.Context, userID string, email string) error {
+	return r.update(userID, func(user *domains.User) {
+		if user.Email == email {
+			user.EmailVerified = true
+		}
+	})
+}
+
+func (r *fakeUserRepository) ClaimEmail(ctx context.Context, userID string, email string) (bool, error) {
+	claimed := false
+	err := r.update(userID, func(user *domains.User) {
+		if user.Email != email || user.EmailVerified {
+			return
+		}
+		user.EmailVerified = true
+		user.PasswordHash = ""
+		user.MFA = domains.MFA{}
+		claimed = true
+	})
+	return claimed, err
+}
+
+func (r *fakeUserRepository) SetRoles(ctx context.Context, userID string, roles []string) error {
+	return r.update(userID, func(user *domains.User) { user.Roles = roles })
+}
+
+func (r *fakeUserRepository) SetSuspended(ctx context.Context, userID string, suspendedAt *time.Time) error {
+	return r.update(userID, func(user *domains.User) { user.SuspendedAt = suspendedAt })
+}
+
+func (r *fakeUserRepository) SetWebAuthnMFA(ctx context.Context, userID string, enabled bool) error {
+	return r.update(userID, func(user *domains.User) { user.MFA.WebAuthn = enabled })
+}
+
+func (r *fakeUserRepository) SetPendingTOTPSecret(ctx context.Context, userID string, secret []byte) (bool, error) {
+	stored := false
+	err := r.update(userID, func(user *domains.User) {
+		if !user.MFA.Enabled {
+			user.MFA.PendingTOTPSecret = secret
+			stored = true
+		}
+	})
+	return stored, err
+}
+
+func (r *fakeUserRepository) EnableTOTP(ctx context.Context, userID string, pendingSecret []byte, step int64, recoveryCodes []string, enabledAt time.Time) (bool, error) {
+	enabled := false
+	err := r.update(userID, func(user *domains.User) {
+		if user.MFA.Enabled || string(user.MFA.PendingTOTPSecret) != string(pendingSecret) {
+			return
+		}
+		user.MFA.Enabled = true
+		user.MFA.EnabledAt = &enabledAt
+		user.MFA.TOTPSecret = pendingSecret
+		user.MFA.PendingTOTPSecret = nil
+		user.MFA.LastTOTPStep = step
+		user.MFA.RecoveryCodes = recoveryCodes
+		enabled = true
+	})
+	return enabled, err
+}
+
+func (r *fakeUserRepository) SetRecoveryCodes(ctx context.Context, userID string, recoveryCodes []string) error {
+	return r.update(userID, func(user *domains.User) { user.MFA.RecoveryCodes = recoveryCodes })
+}
+
+func (r *fakeUserRepository) AdvanceTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	advanced := false
+	err := r.update(userID, func(user *domains.User) {
+		if user.MFA.LastTOTPStep < step {
+			user.MFA.LastTOTPStep = step
+			advanced = true
+		}
+	})
+	return advanced, err
+}
+
+func (r *fakeUserRepository) ConsumeRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	consumed := false
+	err := r.update(userID, func(user *domains.User) {
+		remaining := []string{}
+		for _, hash := range user.MFA.RecoveryCodes {
+			if hash == codeHash {
+				consumed = true
+				continue
+			}
+			remaining = append(remaining, hash)
+		}
+		user.MFA.RecoveryCodes = remaining
+	})
+	return consumed, err
+}
+
+func (r *fakeUserRepository) RecordMFAFailure(ctx context.Context, userID string, maxFailures int, lockedUntil time.Time) (bool, error) {
+	locked := false
+	err := r.update(userID, func(user *domains.User) {
+		user.MFA.FailedAttempts++
+		if user.MFA.FailedAttempts >= maxFailures {
+			user.MFA.LockedUntil = &lockedUntil
+			user.MFA.FailedAttempts = 0
+			locked = true
+		}
+	})
+	return locked, err
+}
+
+func (r *fakeUserRepository) ResetMFAAttempts(ctx context.Context, userID string) error {
+	return r.update(userID, func(user *domains.User) {
+		user.MFA.FailedAttempts = 0
+		user.MFA.LockedUntil = nil
+	})
+}
+
+func (r *fakeUserRepository) ClearTOTP(ctx context.Context, userID string) error {
+	return r.update(userID, func(user *domains.User) {
+		user.MFA = domains.MFA{WebAuthn: user.MFA.WebAuthn}
+	})
+}
+
+type fakeWebAuthnCredentialRepository struct {
+	mu          sync.Mutex
+	credentials []*domains.WebAuthnCredential
+}
+
+func (r *fakeWebAuthnCredentialRepository) Save(ctx context.Context, credential *domains.WebAuthnCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *credential
+	r.credentials = append(r.credentials, &copied)
+	return nil
+}
+
+func (r *fakeWebAuthnCredentialRepository) ListByUser(ctx context.Context, userID string) ([]*domains.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credentials := []*domains.WebAuthnCredential{}
+	for _, credential := range r.credentials {
+		if credential.UserID == userID {
+			copied := *credential
+			credentials = append(credentials, &copied)
+		}
+	}
+	return credentials, nil
+}
+
+func (r *fakeWebAuthnCredentialRepository) UpdateUsage(ctx context.Context, id string, signCount uint32, backupState bool, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, credential := range r.credentials {
+		if credential.ID == id {
+			credential.SignCount = signCount
+			credential.BackupState = backupState
+			credential.LastUsedAt = &usedAt
+		}
+	}
+	return nil
+}
+
+func (r *fakeWebAuthnCredentialRepository) Delete(ctx context.Context, userID string, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, credential := range r.credentials {
+		if credential.ID == id && credential.UserID == userID {
+			r.credentials = append(r.credentials[:i], r.credentials[i+1:]...)
+			return nil
+		}
+	}
+	return domains.ErrCredentialNotFound
+}
+
+type fakeWebAuthnSessionRepository struct {
+	mu       sync.Mutex
+	sessions map[string]*domains.WebAuthnSession
+}
+
+func (r *fakeWebAuthnSessionRepository) Save(ctx context.Context, session *domains.WebAuthnSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions == nil {
+		r.sessions = make(map[string]*domains.WebAuthnSession)
+	}
+	r.sessions[session.ID] = session
+	return nil
+}
+
+func (r *fakeWebAuthnSessionRepository) Consume(ctx context.Context, id string) (*domains.WebAuthnSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[id]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return nil, domains.ErrInvalidWebAuthnSession
+	}
+	delete(r.sessions, id)
+	return session, nil
+}
+
+type fakeOAuth2ClientRepository struct {
+	mu      sync.Mutex
+	clients map[string]*domains.OAuth2Client
+}
+
+func (r *fakeOAuth2ClientRepository) Create(ctx context.Context, client *domains.OAuth2Client) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.clients == nil {
+		r.clients = make(map[string]*domains.OAuth2Client)
+	}
+	copied := *client
+	r.clients[client.ID] = &copied
+	return nil
+}
+
+func (r *fakeOAuth2ClientRepository) GetByID(ctx context.Context, id string) (*domains.OAuth2Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client, ok := r.clients[id]
+	if !ok {
+		return nil, domains.ErrClientNotFound
+	}
+	copied := *client
+	return &copied, nil
+}
+
+func (r *fakeOAuth2ClientRepository) List(ctx context.Context) ([]*domains.OAuth2Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clients := []*domains.OAuth2Client{}
+	for _, client := range r.clients {
+		copied := *client
+		clients = append(clients, &copied)
+	}
+	return clients, nil
+}
+
+func (r *fakeOAuth2ClientRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clients[id]; !ok {
+		return domains.ErrClientNotFound
+	}
+	delete(r.clients, id)
+	return nil
+}
+
+type fakeRefreshTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]*domains.RefreshToken
+}
+
+func (r *fakeRefreshTokenRepository) Save(ctx context.Context, token *domains.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tokens == nil {
+		r.tokens = make(map[string]*domains.RefreshToken)
+	}
+	copied := *token
+	r.tokens[token.TokenHash] = &copied
+	return nil
+}
+
+func (r *fakeRefreshTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*domains.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok {
+		return nil, domains.ErrInvalidRefreshToken
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (r *fakeRefreshTokenRepository) MarkRotated(ctx context.Context, tokenHash string, rotatedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.RotatedAt != nil || token.RevokedAt != nil {
+		return false, nil
+	}
+	token.RotatedAt = &rotatedAt
+	return true, nil
+}
+
+func (r *fakeRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	return r.revoke(func(token *domains.RefreshToken) bool { return token.FamilyID == familyID }, revokedAt)
+}
+
+func (r *fakeRefreshTokenRepository) RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error {
+	return r.revoke(func(token *domains.RefreshToken) bool { return token.UserID == userID }, revokedAt)
+}
+
+func (r *fakeRefreshTokenRepository) RevokeClient(ctx context.Context, clientID string, revokedAt time.Time) error {
+	return r.revoke(func(token *domains.RefreshToken) bool { return token.ClientID == clientID }, revokedAt)
+}
+
+func (r *fakeRefreshTokenRepository) revoke(matches func(token *domains.RefreshToken) bool, revokedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.tokens {
+		if token.RevokedAt == nil && matches(token) {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+type fakeRevokedTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]*domains.RevokedToken
+}
+
+func (r *fakeRevokedTokenRepository) Save(ctx context.Context, token *domains.RevokedToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tokens == nil {
+		r.tokens = make(map[string]*domains.RevokedToken)
+	}
+	copied := *token
+	r.tokens[token.ID] = &copied
+	return nil
+}
+
+func (r *fakeRevokedTokenRepository) ListSince(ctx context.Context, since time.Time) ([]*domains.RevokedToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	tokens := []*domains.RevokedToken{}
+	for _, token := range r.tokens {
+		if !token.RevokedAt.Before(since) && token.ExpiresAt.After(now) {
+			copied := *token
+			tokens = append(tokens, &copied)
+		}
+	}
+	return tokens, nil
+}
+
+type fakeRoleRepository struct {
+	mu    sync.Mutex
+	roles map[string]*domains.Role
+}
+
+func (r *fakeRoleRepository) List(ctx context.Context) ([]*domains.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	roles := []*domains.Role{}
+	for _, role := range r.roles {
+		copied := *role
+		roles = append(roles, &copied)
+	}
+	return roles, nil
+}
+
+func (r *fakeRoleRepository) GetMany(ctx context.Context, names []string) ([]*domains.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	roles := []*domains.Role{}
+	for _, name := range names {
+		if role, ok := r.roles[name]; ok {
+			copied := *role
+			roles = append(roles, &copied)
+		}
+	}
+	return roles, nil
+}
+
+func (r *fakeRoleRepository) CreateIfMissing(ctx context.Context, role *domains.Role) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.roles == nil {
+		r.roles = make(map[string]*domains.Role)
+	}
+	if _, ok := r.roles[role.Name]; ok {
+		return false, nil
+	}
+	copied := *role
+	r.roles[role.Name] = &copied
+	return true, nil
+}
+
+type fakeAuthorizationRequestRepository struct {
+	mu       sync.Mutex
+	requests map[string]*domains.AuthorizationRequest
+}
+
+func (r *fakeAuthorizationRequestRepository) Save(ctx context.Context, request *domains.AuthorizationRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.requests == nil {
+		r.requests = make(map[string]*domains.AuthorizationRequest)
+	}
+	copied := *request
+	r.requests[request.ID] = &copied
+	return nil
+}
+
+func (r *fakeAuthorizationRequestRepository) Get(ctx context.Context, id string) (*domains.AuthorizationRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	request, ok := r.requests[id]
+	if !ok || time.Now().After(request.ExpiresAt) {
+		return nil, domains.ErrAuthorizationRequestMissing
+	}
+	copied := *request
+	return &copied, nil
+}
+
+func (r *fakeAuthorizationRequestRepository) Consume(ctx context.Context, id string) (*domains.AuthorizationRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	request, ok := r.requests[id]
+	if !ok || time.Now().After(request.ExpiresAt) {
+		return nil, domains.ErrAuthorizationRequestMissing
+	}
+	delete(r.requests, id)
+	return request, nil
+}
+
+type fakeAuthorizationCodeRepository struct {
+	mu    sync.Mutex
+	codes map[string]*domains.AuthorizationCode
+}
+
+func (r *fakeAuthorizationCodeRepository) Save(ctx context.Context, code *domains.AuthorizationCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.codes == nil {
+		r.codes = make(map[string]*domains.AuthorizationCode)
+	}
+	copied := *code
+	r.codes[code.CodeHash] = &copied
+	return nil
+}
+
+func (r *fakeAuthorizationCodeRepository) Consume(ctx context.Context, codeHash string) (*domains.AuthorizationCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[codeHash]
+	if !ok || time.Now().After(code.ExpiresAt) {
+		return nil, domains.ErrInvalidAuthorizationCode
+	}
+	delete(r.codes, codeHash)
+	return code, nil
+}
+
+type fakeOAuth2ConsentRepository struct {
+	mu       sync.Mutex
+	consents map[string]*domains.OAuth2Consent
+}
+
+func (r *fakeOAuth2ConsentRepository) Get(ctx context.Context, userID string, clientID string) (*domains.OAuth2Consent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	consent, ok := r.consents[userID+consentIDSeparator+clientID]
+	if !ok {
+		return nil, domains.ErrConsentNotFound
+	}
+	copied := *consent
+	return &copied, nil
+}
+
+func (r *fakeOAuth2ConsentRepository) Save(ctx context.Context, consent *domains.OAuth2Consent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.consents == nil {
+		r.consents = make(map[string]*domains.OAuth2Consent)
+	}
+	copied := *consent
+	r.consents[consent.ID] = &copied
+	return nil
+}
+
+type fakeOAuthRepository struct {
+	mu       sync.Mutex
+	tokens   []*domains.OAuthToken
+	profiles []*domains.OAuthProfile
+}
+
+func (r *fakeOAuthRepository) SaveToken(ctx context.Context, token *domains.OAuthToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens = append(r.tokens, token)
+	return nil
+}
+
+func (r *fakeOAuthRepository) GetToken(ctx context.Context, userID string, provider domains.OAuthProvider) (*domains.OAuthToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.tokens) - 1; i >= 0; i-- {
+		if r.tokens[i].UserID == userID && r.tokens[i].Provider == provider {
+			return r.tokens[i], nil
+		}
+	}
+	return nil, errors.New("token not found")
+}
+
+func (r *fakeOAuthRepository) DeleteToken(ctx context.Context, userID string, provider domains.OAuthProvider) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remaining := []*domains.OAuthToken{}
+	for _, token := range r.tokens {
+		if token.UserID != userID || token.Provider != provider {
+			remaining = append(remaining, token)
+		}
+	}
+	r.tokens = remaining
+	return nil
+}
+
+func (r *fakeOAuthRepository) SaveProfile(ctx context.Context, profile *domains.OAuthProfile) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, existing := range r.profiles {
+		if existing.ProviderID == profile.ProviderID && existing.Provider == profile.Provider {
+			r.profiles[i] = profile
+			return nil
+		}
+	}
+	r.profiles = append(r.profiles, profile)
+	return nil
+}
+
+func (r *fakeOAuthRepository) GetProfile(ctx context.Context, providerID string, provider domains.OAuthProvider) (*domains.OAuthProfile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, profile := range r.profiles {
+		if profile.ProviderID == providerID && profile.Provider == provider {
+			return profile, nil
+		}
+	}
+	return nil, domains.ErrProfileNotFound
+}
+
+func (r *fakeOAuthRepository) ListProfiles(ctx context.Context, userID string) ([]*domains.OAuthProfile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	profiles := []*domains.OAuthProfile{}
+	for _, profile := range r.profiles {
+		if profile.UserID == userID {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles, nil
+}
+
+type fakeEmailLoginRepository struct {
+	mu     sync.Mutex
+	logins map[string]*domains.EmailLogin
+}
+
+func (r *fakeEmailLoginRepository) Save(ctx context.Context, login *domains.EmailLogin) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.logins == nil {
+		r.logins = make(map[string]*domains.EmailLogin)
+	}
+	copied := *login
+	r.logins[login.Email] = &copied
+	return nil
+}
+
+func (r *fakeEmailLoginRepository) Get(ctx context.Context, email string) (*domains.EmailLogin, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	login, ok := r.logins[email]
+	if !ok || time.Now().After(login.ExpiresAt) {
+		return nil, domains.ErrInvalidEmailLogin
+	}
+	copied := *login
+	return &copied, nil
+}
+
+func (r *fakeEmailLoginRepository) ConsumeToken(ctx context.Context, tokenHash string) (*domains.EmailLogin, error) {
+	return r.consume(func(login *domains.EmailLogin) bool { return login.TokenHash == tokenHash })
+}
+
+func (r *fakeEmailLoginRepository) ConsumeCode(ctx context.Context, email string, codeHash string, maxAttempts int) (*domains.EmailLogin, error) {
+	return r.consume(func(login *domains.EmailLogin) bool {
+		return login.Email == email && login.CodeHash == codeHash && login.Attempts < maxAttempts
+	})
+}
+
+func (r *fakeEmailLoginRepository) RecordFailure(ctx context.Context, email string, maxAttempts int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if login, ok := r.logins[email]; ok {
+		login.Attempts++
+		if login.Attempts >= maxAttempts {
+			delete(r.logins, email)
+		}
+	}
+	return nil
+}
+
+func (r *fakeEmailLoginRepository) consume(matches func(login *domains.EmailLogin) bool) (*domains.EmailLogin, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for email, login := range r.logins {
+		if matches(login) && time.Now().Before(login.ExpiresAt) {
+			delete(r.logins, email)
+			return login, nil
+		}
+	}
+	return nil, domains.ErrInvalidEmailLogin
+}
+
+// fakeMailer keeps the messages it was asked to send
+type fakeMailer struct {
+	mu       sync.Mutex
+	messages []lib.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, message lib.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *fakeMailer) last() lib.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.messages) == 0 {
+		return lib.Message{}
+	}
+	return m.messages[len(m.messages)-1]
+}
+
+type fakeSigningKeyRepository struct {
+	mu   sync.Mutex
+	keys []*domains.SigningKey
+}
+
+func (r *fakeSigningKeyRepository) Save(ctx context.Context, key *domains.SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *key
+	copied.Key = nil
+	r.keys = append(r.keys, &copied)
+	return nil
+}
+
+func (r *fakeSigningKeyRepository) SaveInitial(ctx context.Context, key *domains.SigningKey) (bool, error) {
+	r.mu.Lock()
+	for _, existing := range r.keys {
+		if existing.Initial {
+			r.mu.Unlock()
+			return false, nil
+		}
+	}
+	r.mu.Unlock()
+	return true, r.Save(ctx, key)
+}
+
+func (r *fakeSigningKeyRepository) ListPublished(ctx context.Context, now time.Time) ([]*domains.SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Newest first, like the repository
+	keys := []*domains.SigningKey{}
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		if key := r.keys[i]; key.PublishUntil == nil || key.PublishUntil.After(now) {
+			copied := *key
+			keys = append(keys, &copied)
+		}
+	}
+	return keys, nil
+}
+
+func (r *fakeSigningKeyRepository) Retire(ctx context.Context, exceptID string, retiredAt time.Time, publishUntil time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range r.keys {
+		if key.ID != exceptID && key.RetiredAt == nil {
+			key.RetiredAt = &retiredAt
+			key.PublishUntil = &publishUntil
+		}
+	}
+	return nil
+}
+
+func newTestID() string {
+	id, err := randomToken()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
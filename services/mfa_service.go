@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+)
+
+const (
+	defaultMFAIssuer = "Diandi"
+
+	mfaChallengeTTL     = 5 * time.Minute
+	mfaMaxFailures      = 5
+	mfaLockout          = 15 * time.Minute
+	recoveryCodeCount   = 10
+	recoveryCodeLength  = 10
+	recoveryCodeGroupAt = 5
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAService manages TOTP second factors and the sign in challenge they add
+type MFAService interface {
+	// BeginEnrollment generates a TOTP secret awaiting confirmation
+	BeginEnrollment(ctx context.Context, userID string) (*domains.MFAEnrollment, error)
+	// ConfirmEnrollment enables MFA once the user proves the authenticator app
+	// works and returns the recovery codes, shown to the user only this once
+	ConfirmEnrollment(ctx context.Context, userID string, code string) ([]string, error)
+	// RegenerateRecoveryCodes replaces the recovery codes of the user
+	RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, error)
+	// Disable turns MFA off for a user who presents a valid code
+	Disable(ctx context.Context, userID string, code string) error
+	// Reset turns MFA off without a code, for administrators helping a locked out
+	// user, and signs the user out everywhere
+	Reset(ctx context.Context, userID string) error
+
+	// Challenge returns the challenge handed out instead of tokens when the user
+	// has a second factor, nil when the user can be signed in right away
+	Challenge(user *domains.User) (*domains.MFAChallenge, error)
+	// VerifyChallenge checks a TOTP or recovery code against a challenge and
+	// returns the user to sign in
+	VerifyChallenge(ctx context.Context, mfaToken string, code string) (*domains.User, error)
+}
+
+// mfaService implements MFAService
+type mfaService struct {
+	logger        lib.Logger
+	authService   domains.AuthService
+	users         UserRepository
+	refreshTokens RefreshTokenRepository
+	revocations   TokenRevocationService
+	cipher        lib.Cipher
+	issuer        string
+}
+
+// NewMFAService creates a new MFA service
+func NewMFAService(
+	env lib.Env,
+	logger lib.Logger,
+	authService domains.AuthService,
+	users UserRepository,
+	refreshTokens RefreshTokenRepository,
+	revocations TokenRevocationService,
+	cipher lib.Cipher,
+) MFAService {
+	issuer := env.MFAIssuer
+	if issuer == "" {
+		issuer = defaultMFAIssuer
+	}
+
+	return &mfaService{
+		logger:        logger,
+		authService:   authService,
+		users:         users,
+		refreshTokens: refreshTokens,
+		revocations:   revocations,
+		cipher:        cipher,
+		issuer:        issuer,
+	}
+}
+
+func (s *mfaService) BeginEnrollment(ctx context.Context, userID string) (*domains.MFAEnrollment, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFA.Enabled {
+		return nil, domains.ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate totp secret: %w", err)
+	}
+
+	encrypted, err := s.cipher.Encrypt(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt totp secret: %w", err)
+	}
+
+	stored, err := s.users.SetPendingTOTPSecret(ctx, userID, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if !stored {
+		return nil, domains.ErrMFAAlreadyEnabled
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.ID
+	}
+
+	return &domains.MFAEnrollment{
+		Secret: totpEncoding.EncodeToString(secret),
+		URI:    totpURI(s.issuer, account, secret),
+	}, nil
+}
+
+func (s *mfaService) ConfirmEnrollment(ctx context.Context, userID string, code string) ([]string, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFA.Enabled {
+		return nil, domains.ErrMFAAlreadyEnabled
+	}
+	if len(user.MFA.PendingTOTPSecret) == 0 {
+		return nil, domains.ErrMFAEnrollmentMissing
+	}
+
+	secret, err := s.cipher.Decrypt(user.MFA.PendingTOTPSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt totp secret: %w", err)
+	}
+
+	step, ok := validateTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, domains.ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	enabled, err := s.users.EnableTOTP(ctx, userID, user.MFA.PendingTOTPSecret, step, hashes, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, domains.ErrMFAEnrollmentMissing
+	}
+
+	return codes, nil
+}
+
+func (s *mfaService) RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifyCode(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.SetRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *mfaService) Disable(ctx context.Context, userID string, code string) error {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.verifyCode(ctx, user, code); err != nil {
+		return err
+	}
+
+	return s.clear(ctx, user)
+}
+
+func (s *mfaService) Reset(ctx context.Context, userID string) error {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("Resetting MFA of user ", userID)
+
+	if err := s.clear(ctx, user); err != nil {
+		return err
+	}
+
+	// Whoever got locked out may not be the only one holding the account, sessions
+	// are started over once the user enrolls again
+	now := time.Now()
+	if err := s.refreshTokens.RevokeUser(ctx, userID, now); err != nil {
+		return err
+	}
+
+	return s.revocations.RevokeUser(ctx, userID)
+}
+
+func (s *mfaService) Challenge(user *domains.User) (*domains.MFAChallenge, error) {
+	var methods []string
+	if user.MFA.Enabled {
+		methods = append(methods, domains.TOTPMFAMethod)
+	}
+	if user.MFA.WebAuthn {
+		methods = append(methods, domains.WebAuthnMFAMethod)
+	}
+	if len(methods) == 0 {
+		return nil, nil
+	}
+
+	claims := &domains.ActionClaims{}
+	claims.Subject = user.ID
+	token, err := s.authService.CreateActionToken(domains.MFAChallengePurpose, claims, mfaChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domains.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		Methods:     methods,
+	}, nil
+}
+
+func (s *mfaService) VerifyChallenge(ctx context.Context, mfaToken string, code string) (*domains.User, error) {
+	claims, err := s.authService.VerifyActionToken(domains.MFAChallengePurpose, mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.GetByID(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyCode(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// verifyCode accepts a current TOTP code or an unused recovery code, locking
+// the second factor for a while after repeated failures
+func (s *mfaService) verifyCode(ctx context.Context, user *domains.User, code string) error {
+	if !user.MFA.Enabled {
+		return domains.ErrMFANotEnabled
+	}
+
+	now := time.Now()
+	if user.MFA.LockedUntil != nil && now.Before(*user.MFA.LockedUntil) {
+		return domains.ErrMFALocked
+	}
+
+	ok, err := s.matchCode(ctx, user, strings.TrimSpace(code), now)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		locked, err := s.users.RecordMFAFailure(ctx, user.ID, mfaMaxFailures, now.Add(mfaLockout))
+		if err != nil {
+			return err
+		}
+		if locked {
+			s.logger.Warn("Locking MFA of user ", user.ID, " after repeated failures")
+		}
+		return domains.ErrInvalidMFACode
+	}
+
+	if user.MFA.FailedAttempts > 0 || user.MFA.LockedUntil != nil {
+		user.MFA.FailedAttempts = 0
+		user.MFA.LockedUntil = nil
+		if err := s.users.ResetMFAAttempts(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchCode checks code and atomically marks it used so it cannot be replayed
+func (s *mfaService) matchCode(ctx context.Context, user *domains.User, code string, now time.Time) (bool, error) {
+	if len(code) == totpDigits {
+		secret, err := s.cipher.Decrypt(user.MFA.TOTPSecret)
+		if err != nil {
+			return false, fmt.Errorf("failed to decrypt totp secret: %w", err)
+		}
+
+		step, ok := validateTOTP(secret, code, now, user.MFA.LastTOTPStep)
+		if !ok {
+			return false, nil
+		}
+
+		advanced, err := s.users.AdvanceTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return false, err
+		}
+		user.MFA.LastTOTPStep = step
+		return advanced, nil
+	}
+
+	codeHash := hashToken(normalizeRecoveryCode(code))
+	consumed, err := s.users.ConsumeRecoveryCode(ctx, user.ID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	if consumed {
+		s.logger.Info("Recovery code used by user ", user.ID)
+		remaining := user.MFA.RecoveryCodes[:0]
+		for _, hash := range user.MFA.RecoveryCodes {
+			if hash != codeHash {
+				remaining = append(remaining, hash)
+			}
+		}
+		user.MFA.RecoveryCodes = remaining
+	}
+
+	return consumed, nil
+}
+
+// clear turns TOTP off, passkeys are managed by the WebAuthn service
+func (s *mfaService) clear(ctx context.Context, user *domains.User) error {
+	return s.users.ClearTOTP(ctx, user.ID)
+}
+
+// generateRecoveryCodes returns recovery codes formatted for display together
+// with the hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, recoveryCodeLength*5/8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = code[:recoveryCodeGroupAt] + "-" + code[recoveryCodeGroupAt:]
+		hashes[i] = hashToken(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed with any case and grouping
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+)
+
+// OAuth2ClientService keeps the registry of apps signing users in through us
+type OAuth2ClientService interface {
+	// Register validates and stores a client. Confidential clients receive a
+	// secret, returned only this once.
+	Register(ctx context.Context, client *domains.OAuth2Client) (*domains.RegisteredClient, error)
+	List(ctx context.Context) ([]*domains.OAuth2Client, error)
+	Get(ctx context.Context, clientID string) (*domains.OAuth2Client, error)
+	// Delete removes the client and revokes the refresh tokens issued to it
+	Delete(ctx context.Context, clientID string) error
+
+	// Authenticate verifies the credentials a client presents to the token
+	// endpoint. Public clients present their id alone.
+	Authenticate(ctx context.Context, clientID string, secret string) (*domains.OAuth2Client, error)
+}
+
+// OAuth2ClientRepository defines the interface for OAuth2 client persistence
+type OAuth2ClientRepository interface {
+	Create(ctx context.Context, client *domains.OAuth2Client) error
+	GetByID(ctx context.Context, id string) (*domains.OAuth2Client, error)
+	List(ctx context.Context) ([]*domains.OAuth2Client, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// oauth2ClientService implements OAuth2ClientService
+type oauth2ClientService struct {
+	logger        lib.Logger
+	clients       OAuth2ClientRepository
+	refreshTokens RefreshTokenRepository
+}
+
+// NewOAuth2ClientService creates a new OAuth2 client service
+func NewOAuth2ClientService(
+	logger lib.Logger,
+	clients OAuth2ClientRepository,
+	refreshTokens RefreshTokenRepository,
+) OAuth2ClientService {
+	return &oauth2ClientService{
+		logger:        logger,
+		clients:       clients,
+		refreshTokens: refreshTokens,
+	}
+}
+
+func (s *oauth2ClientService) Register(ctx context.Context, client *domains.OAuth2Client) (*domains.RegisteredClient, error) {
+	client.Name = strings.TrimSpace(client.Name)
+	client.Scopes = uniqueSorted(client.Scopes)
+	client.GrantTypes = uniqueSorted(client.GrantTypes)
+	client.Audiences = uniqueSorted(client.Audiences)
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{domains.AuthorizationCodeGrant, domains.RefreshTokenGrant}
+	}
+
+	if err := validateClient(client); err != nil {
+		return nil, err
+	}
+
+	id, err := newTokenID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate client id: %w", err)
+	}
+	client.ID = id
+
+	registered := &domains.RegisteredClient{OAuth2Client: client}
+	if !client.Public {
+		secret, err := randomToken()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate client secret: %w", err)
+		}
+		client.SecretHash = hashToken(secret)
+		registered.Secret = secret
+	}
+
+	now := time.Now()
+	client.CreatedAt = now
+	client.UpdatedAt = now
+	if err := s.clients.Create(ctx, client); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Registered oauth2 client ", client.ID, " (", client.Name, ")")
+
+	return registered, nil
+}
+
+func (s *oauth2ClientService) List(ctx context.Context) ([]*domains.OAuth2Client, error) {
+	return s.clients.List(ctx)
+}
+
+func (s *oauth2ClientService) Get(ctx context.Context, clientID string) (*domains.OAuth2Client, error) {
+	return s.clients.GetByID(ctx, clientID)
+}
+
+func (s *oauth2ClientService) Delete(ctx context.Context, clientID string) error {
+	if err := s.clients.Delete(ctx, clientID); err != nil {
+		return err
+	}
+
+	s.logger.Info("Deleted oauth2 client ", clientID)
+
+	return s.refreshTokens.RevokeClient(ctx, clientID, time.Now())
+}
+
+func (s *oauth2ClientService) Authenticate(ctx context.Context, clientID string, secret string) (*domains.OAuth2Client, error) {
+	if clientID == "" {
+		return nil, domains.ErrClientNotFound
+	}
+
+	client, err := s.clients.GetByID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public {
+		if secret != "" {
+			return nil, domains.ErrClientNotFound
+		}
+		return client, nil
+	}
+
+	if secret == "" || subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, domains.ErrClientNotFound
+	}
+
+	return client, nil
+}
+
+// validateClient checks the metadata of a client about to be registered
+func validateClient(client *domains.OAuth2Client) error {
+	if client.Name == "" {
+		return fmt.Errorf("%w: name is required", domains.ErrInvalidClientMetadata)
+	}
+
+	for _, grantType := range client.GrantTypes {
+		switch grantType {
+		case domains.AuthorizationCodeGrant, domains.RefreshTokenGrant, domains.ClientCredentialsGrant:
+		default:
+			return fmt.Errorf("%w: unsupported grant type %q", domains.ErrInvalidClientMetadata, grantType)
+		}
+	}
+
+	if client.AllowsGrant(domains.AuthorizationCodeGrant) && len(client.RedirectURIs) == 0 {
+		return fmt.Errorf("%w: at least one redirect uri is required", domains.ErrInvalidClientMetadata)
+	}
+	for _, redirectURI := range client.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			return fmt.Errorf("%w: %s", domains.ErrInvalidClientMetadata, err)
+		}
+	}
+
+	for _, scope := range client.Scopes {
+		if strings.ContainsAny(scope, " \t\"\\") {
+			return fmt.Errorf("%w: invalid scope %q", domains.ErrInvalidClientMetadata, scope)
+		}
+	}
+
+	// Machine clients authenticate with their secret alone, so they must have one
+	if client.AllowsGrant(domains.ClientCredentialsGrant) {
+		if client.Public {
+			return fmt.Errorf("%w: public clients cannot use the client credentials grant", domains.ErrInvalidClientMetadata)
+		}
+		if len(client.Audiences) == 0 {
+			return fmt.Errorf("%w: at least one audience is required", domains.ErrInvalidClientMetadata)
+		}
+	}
+	// Introspection is authenticated with the secret
+	if client.ResourceServer && client.Public {
+		return fmt.Errorf("%w: resource servers must be confidential clients", domains.ErrInvalidClientMetadata)
+	}
+	for _, audience := range client.Audiences {
+		if strings.ContainsAny(audience, " \t\"\\") {
+			return fmt.Errorf("%w: invalid audience %q", domains.ErrInvalidClientMetadata, audience)
+		}
+	}
+
+	return nil
+}
+
+// validateRedirectURI accepts absolute URIs without fragment. Plain http is only
+// allowed for loopback hosts during development, mobile apps may register
+// their own private-use scheme.
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || !u.IsAbs() {
+		return fmt.Errorf("redirect uri %q must be absolute", raw)
+	}
+	if u.Fragment != "" || strings.Contains(raw, "#") {
+		return fmt.Errorf("redirect uri %q must not contain a fragment", raw)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		if u.Host == "" {
+			return fmt.Errorf("redirect uri %q has no host", raw)
+		}
+	case "http":
+		switch u.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+		default:
+			return fmt.Errorf("redirect uri %q must use https", raw)
+		}
+	case "javascript", "data", "file":
+		return fmt.Errorf("redirect uri %q uses a forbidden scheme", raw)
+	}
+
+	return nil
+}
@@ -0,0 +1,650 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	authorizationRequestTTL = 10 * time.Minute
+	authorizationCodeTTL    = 5 * time.Minute
+
+	pkceMethodS256     = "S256"
+	pkceMinLength      = 43
+	pkceMaxLength      = 128
+	codeResponseType   = "code"
+	scopeSeparator     = " "
+	consentIDSeparator = ":"
+)
+
+// OAuth2Service lets our own and partner apps sign users in, acting as OAuth 2.0
+// authorization server for the authorization code grant with PKCE
+type OAuth2Service interface {
+	// Authorize validates a request to the authorization endpoint and keeps it
+	// until the user decided in the frontend. Errors are *domains.OAuth2Error,
+	// delivered by redirect once the redirect uri is known to be registered.
+	Authorize(ctx context.Context, params *domains.AuthorizationParams) (*domains.AuthorizationRequest, error)
+	// Prompt describes a pending request to the signed in user
+	Prompt(ctx context.Context, requestID string, userID string) (*domains.AuthorizationPrompt, error)
+	// Decide records the decision of the user signed in with claims and returns
+	// the client redirect, with an authorization code when approved
+	Decide(ctx context.Context, requestID string, claims *domains.AccessClaims, approved bool) (string, error)
+
+	// Token serves the token endpoint for an authenticated client. Errors are
+	// *domains.OAuth2Error unless the server failed.
+	Token(ctx context.Context, client *domains.OAuth2Client, request *domains.TokenRequest) (*domains.TokenResponse, error)
+
+	// Introspect reports whether an access or refresh token is active and what
+	// it grants. Public clients only see the tokens issued to them.
+	Introspect(ctx context.Context, client *domains.OAuth2Client, token string, hint string) (*domains.IntrospectionResponse, error)
+	// Revoke revokes a token issued to the client, revoking a refresh token ends
+	// its whole family. Unknown tokens are ignored.
+	Revoke(ctx context.Context, client *domains.OAuth2Client, token string, hint string) error
+}
+
+// AuthorizationRequestRepository defines the interface for pending authorization request persistence
+type AuthorizationRequestRepository interface {
+	Save(ctx context.Context, request *domains.AuthorizationRequest) error
+	Get(ctx context.Context, id string) (*domains.AuthorizationRequest, error)
+	// Consume returns and removes the request, so each request is decided once
+	Consume(ctx context.Context, id string) (*domains.AuthorizationRequest, error)
+}
+
+// AuthorizationCodeRepository defines the interface for authorization code persistence
+type AuthorizationCodeRepository interface {
+	Save(ctx context.Context, code *domains.AuthorizationCode) error
+	// Consume returns and removes the code, so each code is redeemed at most once
+	Consume(ctx context.Context, codeHash string) (*domains.AuthorizationCode, error)
+}
+
+// OAuth2ConsentRepository defines the interface for consent persistence
+type OAuth2ConsentRepository interface {
+	Get(ctx context.Context, userID string, clientID string) (*domains.OAuth2Consent, error)
+	Save(ctx context.Context, consent *domains.OAuth2Consent) error
+}
+
+// oauth2Service implements OAuth2Service
+type oauth2Service struct {
+	logger        lib.Logger
+	authService   domains.AuthService
+	revocations   TokenRevocationService
+	clients       OAuth2ClientService
+	sessions      SessionService
+	refreshTokens RefreshTokenRepository
+	requests      AuthorizationRequestRepository
+	codes         AuthorizationCodeRepository
+	consents      OAuth2ConsentRepository
+	expiration    time.Duration
+}
+
+// NewOAuth2Service creates a new OAuth2 authorization server service
+func NewOAuth2Service(
+	env lib.Env,
+	logger lib.Logger,
+	authService domains.AuthService,
+	revocations TokenRevocationService,
+	clients OAuth2ClientService,
+	sessions SessionService,
+	refreshTokens RefreshTokenRepository,
+	requests AuthorizationRequestRepository,
+	codes AuthorizationCodeRepository,
+	consents OAuth2ConsentRepository,
+) OAuth2Service {
+	return &oauth2Service{
+		logger:        logger,
+		authService:   authService,
+		revocations:   revocations,
+		clients:       clients,
+		sessions:      sessions,
+		refreshTokens: refreshTokens,
+		requests:      requests,
+		codes:         codes,
+		consents:      consents,
+		expiration:    tokenExpiration(env),
+	}
+}
+
+func (s *oauth2Service) Authorize(ctx context.Context, params *domains.AuthorizationParams) (*domains.AuthorizationRequest, error) {
+	client, err := s.clients.Get(ctx, params.ClientID)
+	if err != nil {
+		if errors.Is(err, domains.ErrClientNotFound) {
+			return nil, &domains.OAuth2Error{Code: domains.InvalidRequestError, Description: "unknown client_id"}
+		}
+		return nil, err
+	}
+
+	// Until the redirect uri matches a registered one exactly, errors are shown
+	// to the user instead of redirecting to a possibly foreign site
+	redirectURI := params.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !containsString(client.RedirectURIs, redirectURI) {
+		return nil, &domains.OAuth2Error{Code: domains.InvalidRequestError, Description: "redirect_uri is not registered for the client"}
+	}
+
+	redirectError := func(code string, description string) error {
+		return &domains.OAuth2Error{Code: code, Description: description, RedirectURI: redirectURI, State: params.State}
+	}
+
+	if params.ResponseType != codeResponseType {
+		return nil, redirectError(domains.UnsupportedResponseTypeError, "only the code response type is supported")
+	}
+	if !client.AllowsGrant(domains.AuthorizationCodeGrant) {
+		return nil, redirectError(domains.UnauthorizedClientError, "client may not use the authorization code grant")
+	}
+
+	scopes := parseScope(params.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !containsString(client.Scopes, scope) {
+			return nil, redirectError(domains.InvalidScopeError, fmt.Sprintf("scope %q is not allowed for the client", scope))
+		}
+	}
+	if containsString(scopes, domains.OpenIDScope) && !s.authService.SupportsIDTokens() {
+		return nil, redirectError(domains.InvalidScopeError, "openid is unavailable while tokens are signed with a shared secret")
+	}
+
+	// PKCE is required from every client, confidential ones included
+	if params.CodeChallengeMethod != pkceMethodS256 {
+		return nil, redirectError(domains.InvalidRequestError, "code_challenge_method must be S256")
+	}
+	if !validPKCEValue(params.CodeChallenge) {
+		return nil, redirectError(domains.InvalidRequestError, "code_challenge is missing or malformed")
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate authorization request id: %w", err)
+	}
+
+	now := time.Now()
+	request := &domains.AuthorizationRequest{
+		ID:                   id,
+		ClientID:             client.ID,
+		RedirectURI:          redirectURI,
+		RedirectURIDefaulted: params.RedirectURI == "",
+		Scopes:               scopes,
+		State:                params.State,
+		Nonce:                params.Nonce,
+		CodeChallenge:        params.CodeChallenge,
+		CodeChallengeMethod:  params.CodeChallengeMethod,
+		CreatedAt:            now,
+		ExpiresAt:            now.Add(authorizationRequestTTL),
+	}
+	if err := s.requests.Save(ctx, request); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+func (s *oauth2Service) Prompt(ctx context.Context, requestID string, userID string) (*domains.AuthorizationPrompt, error) {
+	request, err := s.requests.Get(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.clients.Get(ctx, request.ClientID)
+	if err != nil {
+		if errors.Is(err, domains.ErrClientNotFound) {
+			return nil, domains.ErrAuthorizationRequestMissing
+		}
+		return nil, err
+	}
+
+	consentRequired, err := s.consentRequired(ctx, client, userID, request.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domains.AuthorizationPrompt{
+		RequestID:       request.ID,
+		ClientID:        client.ID,
+		ClientName:      client.Name,
+		Scopes:          request.Scopes,
+		ConsentRequired: consentRequired,
+	}, nil
+}
+
+func (s *oauth2Service) Decide(ctx context.Context, requestID string, claims *domains.AccessClaims, approved bool) (string, error) {
+	userID := claims.Subject
+
+	request, err := s.requests.Consume(ctx, requestID)
+	if err != nil {
+		return "", err
+	}
+
+	if !approved {
+		return authorizationRedirect(request.RedirectURI, url.Values{
+			"error":             {domains.AccessDeniedError},
+			"error_description": {"the user denied the request"},
+		}, request.State), nil
+	}
+
+	now := time.Now()
+	if err := s.grantConsent(ctx, userID, request.ClientID, request.Scopes, now); err != nil {
+		return "", err
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate authorization code: %w", err)
+	}
+
+	err = s.codes.Save(ctx, &domains.AuthorizationCode{
+		CodeHash:             hashToken(code),
+		ClientID:             request.ClientID,
+		UserID:               userID,
+		RedirectURI:          request.RedirectURI,
+		RedirectURIDefaulted: request.RedirectURIDefaulted,
+		Scopes:               request.Scopes,
+		Nonce:                request.Nonce,
+		CodeChallenge:        request.CodeChallenge,
+		CodeChallengeMethod:  request.CodeChallengeMethod,
+		Authentication:       claims.Authentication(),
+		CreatedAt:            now,
+		ExpiresAt:            now.Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return authorizationRedirect(request.RedirectURI, url.Values{"code": {code}}, request.State), nil
+}
+
+func (s *oauth2Service) Token(ctx context.Context, client *domains.OAuth2Client, request *domains.TokenRequest) (*domains.TokenResponse, error) {
+	switch request.GrantType {
+	case domains.AuthorizationCodeGrant, domains.RefreshTokenGrant, domains.ClientCredentialsGrant:
+	default:
+		return nil, &domains.OAuth2Error{Code: domains.UnsupportedGrantTypeError}
+	}
+	if !client.AllowsGrant(request.GrantType) {
+		return nil, &domains.OAuth2Error{Code: domains.UnauthorizedClientError, Description: "client may not use this grant type"}
+	}
+
+	if request.GrantType == domains.ClientCredentialsGrant {
+		return s.clientCredentials(client, request)
+	}
+
+	var pair *domains.TokenPair
+	var code *domains.AuthorizationCode
+	var err error
+	if request.GrantType == domains.AuthorizationCodeGrant {
+		pair, code, err = s.exchangeCode(ctx, client, request)
+	} else {
+		pair, err = s.sessions.RefreshClient(ctx, client.ID, request.RefreshToken, parseScope(request.Scope))
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, domains.ErrInvalidRefreshToken), errors.Is(err, domains.ErrRefreshTokenReused):
+			return nil, &domains.OAuth2Error{Code: domains.InvalidGrantError, Description: "refresh token is invalid or expired"}
+		case errors.Is(err, domains.ErrInvalidScope):
+			return nil, &domains.OAuth2Error{Code: domains.InvalidScopeError, Description: "scope exceeds the granted scopes"}
+		case errors.Is(err, domains.ErrUserSuspended), errors.Is(err, domains.ErrUserNotFound):
+			return nil, &domains.OAuth2Error{Code: domains.InvalidGrantError, Description: "the user can no longer sign in"}
+		}
+		return nil, err
+	}
+
+	response := &domains.TokenResponse{
+		AccessToken:  pair.AccessToken,
+		TokenType:    pair.TokenType,
+		ExpiresIn:    pair.ExpiresIn,
+		RefreshToken: pair.RefreshToken,
+		Scope:        strings.Join(pair.Scopes, scopeSeparator),
+	}
+
+	// OpenID Connect clients receive an ID token when redeeming the code
+	if code != nil && containsString(code.Scopes, domains.OpenIDScope) {
+		response.IDToken, err = s.idToken(client, code)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+func (s *oauth2Service) Introspect(ctx context.Context, client *domains.OAuth2Client, token string, hint string) (*domains.IntrospectionResponse, error) {
+	claims, saved, err := s.findToken(ctx, token, hint)
+	if err != nil {
+		return nil, err
+	}
+
+	inactive := &domains.IntrospectionResponse{Active: false}
+	switch {
+	case claims != nil:
+		if s.revocations.IsRevoked(claims) || !canIntrospect(client, claims) {
+			return inactive, nil
+		}
+
+		response := &domains.IntrospectionResponse{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			TokenType: "Bearer",
+			Subject:   claims.Subject,
+			Audience:  claims.Audience,
+			Issuer:    claims.Issuer,
+			ID:        claims.ID,
+		}
+		if claims.ExpiresAt != nil {
+			response.ExpiresAt = claims.ExpiresAt.Unix()
+		}
+		if claims.IssuedAt != nil {
+			response.IssuedAt = claims.IssuedAt.Unix()
+		}
+		return response, nil
+
+	case saved != nil:
+		// A rotated token can no longer be redeemed, replaying it revokes the family.
+		// Refresh tokens are only presented by the client holding them.
+		if saved.RevokedAt != nil || saved.RotatedAt != nil || time.Now().After(saved.ExpiresAt) ||
+			saved.ClientID != client.ID {
+			return inactive, nil
+		}
+
+		return &domains.IntrospectionResponse{
+			Active:    true,
+			Scope:     strings.Join(saved.Scopes, scopeSeparator),
+			ClientID:  saved.ClientID,
+			Subject:   saved.UserID,
+			ExpiresAt: saved.ExpiresAt.Unix(),
+			IssuedAt:  saved.CreatedAt.Unix(),
+		}, nil
+	}
+
+	return inactive, nil
+}
+
+func (s *oauth2Service) Revoke(ctx context.Context, client *domains.OAuth2Client, token string, hint string) error {
+	claims, saved, err := s.findToken(ctx, token, hint)
+	if err != nil {
+		return err
+	}
+
+	foreign := &domains.OAuth2Error{Code: domains.UnauthorizedClientError, Description: "the token was not issued to the client"}
+	switch {
+	case claims != nil:
+		if claims.ClientID != client.ID {
+			return foreign
+		}
+		return s.revocations.RevokeToken(ctx, claims)
+
+	case saved != nil:
+		if saved.ClientID != client.ID {
+			return foreign
+		}
+		if saved.RevokedAt != nil {
+			return nil
+		}
+		s.logger.Info("Client ", client.ID, " revoked refresh token family ", saved.FamilyID)
+		return s.refreshTokens.RevokeFamily(ctx, saved.FamilyID, time.Now())
+	}
+
+	return nil
+}
+
+// findToken looks token up as access token and as refresh token, the hinted
+// type first. Neither is returned for an unknown token.
+func (s *oauth2Service) findToken(ctx context.Context, token string, hint string) (*domains.AccessClaims, *domains.RefreshToken, error) {
+	lookups := []string{domains.AccessTokenHint, domains.RefreshTokenHint}
+	if hint == domains.RefreshTokenHint {
+		lookups = []string{domains.RefreshTokenHint, domains.AccessTokenHint}
+	}
+
+	for _, tokenType := range lookups {
+		if tokenType == domains.AccessTokenHint {
+			if claims, err := s.authService.Authorize(token); err == nil {
+				return claims, nil, nil
+			}
+			continue
+		}
+
+		saved, err := s.refreshTokens.GetByHash(ctx, hashToken(token))
+		if err == nil {
+			return nil, saved, nil
+		}
+		if !errors.Is(err, domains.ErrInvalidRefreshToken) {
+			return nil, nil, err
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// canIntrospect reports whether client may learn about an access token: one
+// issued to it, one it is an audience of, or any when it is a resource server
+func canIntrospect(client *domains.OAuth2Client, claims *domains.AccessClaims) bool {
+	if client.ResourceServer && !client.Public {
+		return true
+	}
+	return claims.ClientID == client.ID || containsString(claims.Audience, client.ID)
+}
+
+// clientCredentials issues a machine client a token acting for itself. Its
+// scopes become the token's permissions, limited to those the client is allowed,
+// and the token is only accepted by the requested audiences.
+func (s *oauth2Service) clientCredentials(client *domains.OAuth2Client, request *domains.TokenRequest) (*domains.TokenResponse, error) {
+	// Only confidential clients are registered for this grant, checked again in
+	// case the registry was edited by hand
+	if client.Public {
+		return nil, &domains.OAuth2Error{Code: domains.UnauthorizedClientError, Description: "public clients cannot use this grant type"}
+	}
+
+	scopes := parseScope(request.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !containsString(client.Scopes, scope) {
+			return nil, &domains.OAuth2Error{Code: domains.InvalidScopeError, Description: fmt.Sprintf("scope %q is not allowed for the client", scope)}
+		}
+	}
+
+	audiences := parseScope(request.Audience)
+	if len(audiences) == 0 {
+		audiences = client.Audiences
+	}
+	if len(audiences) == 0 {
+		return nil, &domains.OAuth2Error{Code: domains.InvalidTargetError, Description: "the client has no audience"}
+	}
+	for _, audience := range audiences {
+		if !containsString(client.Audiences, audience) {
+			return nil, &domains.OAuth2Error{Code: domains.InvalidTargetError, Description: fmt.Sprintf("audience %q is not allowed for the client", audience)}
+		}
+	}
+
+	scope := strings.Join(scopes, scopeSeparator)
+	claims := &domains.AccessClaims{
+		Permissions: scopes,
+		ClientID:    client.ID,
+		Scope:       scope,
+	}
+	claims.Subject = domains.ClientSubject(client.ID)
+	claims.Audience = jwt.ClaimStrings(audiences)
+
+	accessToken, err := s.authService.CreateToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domains.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.expiration / time.Second),
+		Scope:       scope,
+	}, nil
+}
+
+// idToken issues the ID token of an authorization code to client, telling it
+// who signed in, when and how
+func (s *oauth2Service) idToken(client *domains.OAuth2Client, code *domains.AuthorizationCode) (string, error) {
+	claims := &domains.IDClaims{
+		AuthorizedParty: client.ID,
+		Nonce:           code.Nonce,
+		AMR:             code.Authentication.Methods,
+		ACR:             code.Authentication.ACR(),
+	}
+	claims.Subject = code.UserID
+	claims.Audience = jwt.ClaimStrings{client.ID}
+	if !code.Authentication.Time.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(code.Authentication.Time)
+	}
+
+	return s.authService.CreateIDToken(claims)
+}
+
+// exchangeCode redeems an authorization code, checking it was issued to the
+// client for the same redirect uri and that the client holds the PKCE verifier.
+// The redeemed code is returned along with the tokens.
+func (s *oauth2Service) exchangeCode(ctx context.Context, client *domains.OAuth2Client, request *domains.TokenRequest) (*domains.TokenPair, *domains.AuthorizationCode, error) {
+	invalidGrant := &domains.OAuth2Error{Code: domains.InvalidGrantError, Description: "authorization code is invalid or expired"}
+
+	if request.Code == "" {
+		return nil, nil, &domains.OAuth2Error{Code: domains.InvalidRequestError, Description: "code is required"}
+	}
+
+	code, err := s.codes.Consume(ctx, hashToken(request.Code))
+	if err != nil {
+		if errors.Is(err, domains.ErrInvalidAuthorizationCode) {
+			return nil, nil, invalidGrant
+		}
+		return nil, nil, err
+	}
+
+	if code.ClientID != client.ID {
+		return nil, nil, invalidGrant
+	}
+	// redirect_uri has to repeat the one of the authorization request, if that had one
+	redirectURIRequired := !code.RedirectURIDefaulted || request.RedirectURI != ""
+	if redirectURIRequired && code.RedirectURI != request.RedirectURI {
+		return nil, nil, invalidGrant
+	}
+	if !verifyPKCE(code.CodeChallenge, request.CodeVerifier) {
+		return nil, nil, &domains.OAuth2Error{Code: domains.InvalidGrantError, Description: "code_verifier does not match the code_challenge"}
+	}
+
+	pair, err := s.sessions.IssueClientTokens(ctx, domains.ClientGrant{
+		UserID:         code.UserID,
+		ClientID:       client.ID,
+		Scopes:         code.Scopes,
+		Authentication: code.Authentication,
+		Refreshable:    client.AllowsGrant(domains.RefreshTokenGrant),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pair, code, nil
+}
+
+// consentRequired reports whether the user still has to approve the scopes for
+// the client, our own apps never ask
+func (s *oauth2Service) consentRequired(ctx context.Context, client *domains.OAuth2Client, userID string, scopes []string) (bool, error) {
+	if client.FirstParty {
+		return false, nil
+	}
+
+	consent, err := s.consents.Get(ctx, userID, client.ID)
+	if err != nil {
+		if errors.Is(err, domains.ErrConsentNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	for _, scope := range scopes {
+		if !containsString(consent.Scopes, scope) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// grantConsent adds scopes to those the user granted the client before
+func (s *oauth2Service) grantConsent(ctx context.Context, userID string, clientID string, scopes []string, now time.Time) error {
+	consent, err := s.consents.Get(ctx, userID, clientID)
+	if err != nil && !errors.Is(err, domains.ErrConsentNotFound) {
+		return err
+	}
+
+	granted := scopes
+	if consent != nil {
+		granted = append(append([]string{}, consent.Scopes...), scopes...)
+	}
+
+	return s.consents.Save(ctx, &domains.OAuth2Consent{
+		ID:        userID + consentIDSeparator + clientID,
+		UserID:    userID,
+		ClientID:  clientID,
+		Scopes:    uniqueSorted(granted),
+		GrantedAt: now,
+	})
+}
+
+// authorizationRedirect appends the response parameters and state to the
+// registered redirect uri
+func authorizationRedirect(redirectURI string, params url.Values, state string) string {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	target.RawQuery = query.Encode()
+
+	return target.String()
+}
+
+// parseScope splits a space separated scope parameter
+func parseScope(scope string) []string {
+	return uniqueSorted(strings.Fields(scope))
+}
+
+// validPKCEValue checks the length and alphabet of a code verifier or S256 challenge
+func validPKCEValue(value string) bool {
+	if len(value) < pkceMinLength || len(value) > pkceMaxLength {
+		return false
+	}
+	for _, r := range value {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r == '-', r == '.', r == '_', r == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// verifyPKCE checks the S256 transformation of verifier against challenge
+func verifyPKCE(challenge string, verifier string) bool {
+	if !validPKCEValue(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
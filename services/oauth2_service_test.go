@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestIntrospectionIsLimitedToRelatedClients(t *testing.T) {
+	ot := newOIDCTest(t, lib.Env{JWTSecret: "test secret"})
+	ctx := context.Background()
+
+	issued := ot.signIn(t, domains.ProfileScope, domains.PasswordMethod)
+
+	session := &domains.AccessClaims{}
+	session.Subject = ot.user.ID
+	firstParty, err := ot.authService.CreateToken(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &domains.AccessClaims{ClientID: "machine"}
+	service.Subject = domains.ClientSubject("machine")
+	service.Audience = jwt.ClaimStrings{"billing"}
+	serviceToken, err := ot.authService.CreateToken(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foreign := &domains.OAuth2Client{ID: "other-partner"}
+	billing := &domains.OAuth2Client{ID: "billing"}
+	gateway := &domains.OAuth2Client{ID: "gateway", ResourceServer: true}
+
+	tests := []struct {
+		name   string
+		client *domains.OAuth2Client
+		token  string
+		active bool
+	}{
+		{name: "own access token", client: ot.client, token: issued.AccessToken, active: true},
+		{name: "own refresh token", client: ot.client, token: issued.RefreshToken, active: true},
+		{name: "foreign client, partner access token", client: foreign, token: issued.AccessToken},
+		{name: "foreign client, partner refresh token", client: foreign, token: issued.RefreshToken},
+		{name: "foreign client, first-party session", client: foreign, token: firstParty},
+		{name: "foreign client, service token", client: foreign, token: serviceToken},
+		{name: "audience of the service token", client: billing, token: serviceToken, active: true},
+		{name: "resource server, partner access token", client: gateway, token: issued.AccessToken, active: true},
+		{name: "resource server, first-party session", client: gateway, token: firstParty, active: true},
+		{name: "resource server, partner refresh token", client: gateway, token: issued.RefreshToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := ot.oauth2.Introspect(ctx, tt.client, tt.token, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Active != tt.active {
+				t.Fatalf("active = %v, want %v", response.Active, tt.active)
+			}
+			if !tt.active && (response.Subject != "" || response.Scope != "" || response.ExpiresAt != 0) {
+				t.Fatalf("inactive response leaks token details: %+v", response)
+			}
+		})
+	}
+}
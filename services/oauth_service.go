@@ -2,27 +2,37 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/facebook"
-	"golang.org/x/oauth2/google"
 
+	"diandi-backend/config"
 	"diandi-backend/domains"
+	"diandi-backend/lib"
+	"diandi-backend/providers"
 )
 
+const defaultOAuthStateTTL = 10 * time.Minute
+
 // OAuthService defines the interface for OAuth operations
 type OAuthService interface {
 	// Configuration
-	GetAuthURL(provider domains.OAuthProvider, state string) (string, error)
+	GetAuthURL(state *domains.OAuthState) (string, error)
+
+	// Authorization State
+	CreateState(ctx context.Context, provider domains.OAuthProvider, intent domains.OAuthIntent, userID string, returnTo string) (*domains.OAuthState, error)
+	ConsumeState(ctx context.Context, provider domains.OAuthProvider, state string) (*domains.OAuthState, error)
 
 	// OAuth Flow
-	ExchangeCode(ctx context.Context, provider domains.OAuthProvider, code string) (*domains.OAuthToken, error)
+	ExchangeCode(ctx context.Context, state *domains.OAuthState, code string) (*domains.OAuthToken, error)
 	GetUserProfile(ctx context.Context, provider domains.OAuthProvider, token *domains.OAuthToken) (*domains.OAuthProfile, error)
+	CompleteProfile(provider domains.OAuthProvider, profile *domains.OAuthProfile, params url.Values) error
 
 	// Token Management
 	RefreshToken(ctx context.Context, token *domains.OAuthToken) (*domains.OAuthToken, error)
@@ -35,8 +45,13 @@ type OAuthService interface {
 
 // oauthService implements OAuthService
 type oauthService struct {
-	configs map[domains.OAuthProvider]*oauth2.Config
-	repo    OAuthRepository
+	providers      *providers.Registry
+	settings       map[domains.OAuthProvider]*domains.OAuthConfig
+	configs        map[domains.OAuthProvider]*oauth2.Config
+	repo           OAuthRepository
+	states         OAuthStateStore
+	stateTTL       time.Duration
+	returnToOrigin map[string]bool
 }
 
 // OAuthRepository defines the interface for OAuth data persistence
@@ -46,52 +61,212 @@ type OAuthRepository interface {
 	DeleteToken(ctx context.Context, userID string, provider domains.OAuthProvider) error
 	SaveProfile(ctx context.Context, profile *domains.OAuthProfile) error
 	GetProfile(ctx context.Context, providerID string, provider domains.OAuthProvider) (*domains.OAuthProfile, error)
+	// ListProfiles returns the profiles linked to a user, most recently updated first
+	ListProfiles(ctx context.Context, userID string) ([]*domains.OAuthProfile, error)
+}
+
+// OAuthStateStore defines the interface for pending authorization requests
+type OAuthStateStore interface {
+	Save(ctx context.Context, state *domains.OAuthState) error
+	// Consume returns and removes the state, so each state is redeemed at most once
+	Consume(ctx context.Context, state string) (*domains.OAuthState, error)
 }
 
-// NewOAuthService creates a new OAuth service
-func NewOAuthService(repo OAuthRepository, configs map[domains.OAuthProvider]*domains.OAuthConfig) OAuthService {
+// NewOAuthService creates a new OAuth service dispatching to the registered providers
+func NewOAuthService(
+	env lib.Env,
+	repo OAuthRepository,
+	states OAuthStateStore,
+	registry *providers.Registry,
+	configs *config.OAuthConfigs,
+) OAuthService {
+	settings := make(map[domains.OAuthProvider]*domains.OAuthConfig)
 	oauthConfigs := make(map[domains.OAuthProvider]*oauth2.Config)
 
-	for provider, config := range configs {
-		var endpoint oauth2.Endpoint
-		switch provider {
-		case domains.GoogleOAuthProvider:
-			endpoint = google.Endpoint
-		case domains.FacebookOAuthProvider:
-			endpoint = facebook.Endpoint
+	for name, cfg := range configs.GetAllConfigs() {
+		provider, ok := registry.Get(name)
+		if !ok {
+			continue
 		}
 
-		oauthConfigs[provider] = &oauth2.Config{
-			ClientID:     config.ClientID,
-			ClientSecret: config.ClientSecret,
-			RedirectURL:  config.RedirectURL,
-			Scopes:       config.Scopes,
-			Endpoint:     endpoint,
+		scopes := cfg.Scopes
+		if len(scopes) == 0 {
+			scopes = provider.DefaultScopes()
+		}
+
+		settings[name] = cfg
+		oauthConfigs[name] = &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+			Endpoint:     provider.Endpoint(),
+		}
+	}
+
+	stateTTL := env.OAuthStateTTL
+	if stateTTL <= 0 {
+		stateTTL = defaultOAuthStateTTL
+	}
+
+	returnToOrigin := make(map[string]bool)
+	for _, allowed := range strings.Split(env.OAuthReturnToAllowlist, ",") {
+		if origin, ok := urlOrigin(strings.TrimSpace(allowed)); ok {
+			returnToOrigin[origin] = true
 		}
 	}
 
 	return &oauthService{
-		configs: oauthConfigs,
-		repo:    repo,
+		providers:      registry,
+		settings:       settings,
+		configs:        oauthConfigs,
+		repo:           repo,
+		states:         states,
+		stateTTL:       stateTTL,
+		returnToOrigin: returnToOrigin,
 	}
 }
 
-func (s *oauthService) GetAuthURL(provider domains.OAuthProvider, state string) (string, error) {
-	config, ok := s.configs[provider]
+// provider returns the registered provider for a configured provider name
+func (s *oauthService) provider(name domains.OAuthProvider) (providers.Provider, error) {
+	if _, ok := s.configs[name]; !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", name)
+	}
+
+	provider, ok := s.providers.Get(name)
 	if !ok {
-		return "", fmt.Errorf("unsupported provider: %s", provider)
+		return nil, fmt.Errorf("unsupported provider: %s", name)
 	}
 
-	return config.AuthCodeURL(state), nil
+	return provider, nil
 }
 
-func (s *oauthService) ExchangeCode(ctx context.Context, provider domains.OAuthProvider, code string) (*domains.OAuthToken, error) {
-	config, ok := s.configs[provider]
+// oauthConfig returns the client configuration of a provider, with a freshly
+// generated client secret for providers that derive it from a signing key
+func (s *oauthService) oauthConfig(name domains.OAuthProvider) (*oauth2.Config, error) {
+	config, ok := s.configs[name]
 	if !ok {
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
+		return nil, fmt.Errorf("unsupported provider: %s", name)
+	}
+
+	provider, ok := s.providers.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", name)
+	}
+
+	generator, ok := provider.(providers.ClientSecretGenerator)
+	if !ok {
+		return config, nil
+	}
+
+	secret, err := generator.ClientSecret(s.settings[name])
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate client secret: %w", err)
+	}
+
+	withSecret := *config
+	withSecret.ClientSecret = secret
+	return &withSecret, nil
+}
+
+// CreateState generates and stores the state, PKCE verifier and nonce of a new
+// authorization request
+func (s *oauthService) CreateState(ctx context.Context, provider domains.OAuthProvider, intent domains.OAuthIntent, userID string, returnTo string) (*domains.OAuthState, error) {
+	if _, err := s.provider(provider); err != nil {
+		return nil, err
 	}
 
-	token, err := config.Exchange(ctx, code)
+	if intent == domains.LinkOAuthIntent && userID == "" {
+		return nil, errors.New("linking an account requires a signed in user")
+	}
+
+	if returnTo != "" {
+		origin, ok := urlOrigin(returnTo)
+		if !ok || !s.returnToOrigin[origin] {
+			return nil, domains.ErrReturnToNotAllowed
+		}
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate state: %w", err)
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	now := time.Now()
+	authState := &domains.OAuthState{
+		State:        state,
+		Provider:     provider,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Nonce:        nonce,
+		Intent:       intent,
+		UserID:       userID,
+		ReturnTo:     returnTo,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(s.stateTTL),
+	}
+
+	if err := s.states.Save(ctx, authState); err != nil {
+		return nil, err
+	}
+
+	return authState, nil
+}
+
+// ConsumeState redeems the state of a callback, it cannot be used again afterwards
+func (s *oauthService) ConsumeState(ctx context.Context, provider domains.OAuthProvider, state string) (*domains.OAuthState, error) {
+	if state == "" {
+		return nil, domains.ErrInvalidOAuthState
+	}
+
+	saved, err := s.states.Consume(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if saved.Provider != provider {
+		return nil, domains.ErrInvalidOAuthState
+	}
+
+	return saved, nil
+}
+
+// GetAuthURL builds the authorization URL bound to the state, its PKCE code challenge
+// and, for providers issuing ID tokens, its nonce
+func (s *oauthService) GetAuthURL(state *domains.OAuthState) (string, error) {
+	config, ok := s.configs[state.Provider]
+	if !ok {
+		return "", fmt.Errorf("unsupported provider: %s", state.Provider)
+	}
+
+	opts := []oauth2.AuthCodeOption{
+		oauth2.S256ChallengeOption(state.CodeVerifier),
+	}
+	if p, ok := s.providers.Get(state.Provider); ok {
+		if _, ok := p.(providers.IDTokenVerifier); ok {
+			opts = append(opts, oauth2.SetAuthURLParam("nonce", state.Nonce))
+		}
+		if optioner, ok := p.(providers.AuthURLOptioner); ok {
+			opts = append(opts, optioner.AuthURLOptions()...)
+		}
+	}
+
+	return config.AuthCodeURL(state.State, opts...), nil
+}
+
+// ExchangeCode redeems the code with the state's PKCE verifier and checks the
+// returned ID token against the state's nonce
+func (s *oauthService) ExchangeCode(ctx context.Context, state *domains.OAuthState, code string) (*domains.OAuthToken, error) {
+	provider := state.Provider
+	config, err := s.oauthConfig(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(state.CodeVerifier))
 	if err != nil {
 		return nil, fmt.Errorf("failed to exchange code: %w", err)
 	}
@@ -106,58 +281,68 @@ func (s *oauthService) ExchangeCode(ctx context.Context, provider domains.OAuthP
 		UpdatedAt:    time.Now(),
 	}
 
+	if idToken, ok := token.Extra("id_token").(string); ok {
+		oauthToken.IDToken = idToken
+	}
+
+	p, err := s.provider(provider)
+	if err != nil {
+		return nil, err
+	}
+	if verifier, ok := p.(providers.IDTokenVerifier); ok {
+		oauthToken.Nonce = state.Nonce
+		if err := verifier.VerifyIDToken(ctx, oauthToken); err != nil {
+			return nil, err
+		}
+	}
+
 	return oauthToken, nil
 }
 
 func (s *oauthService) GetUserProfile(ctx context.Context, provider domains.OAuthProvider, token *domains.OAuthToken) (*domains.OAuthProfile, error) {
-	var profileURL string
-	switch provider {
-	case domains.GoogleOAuthProvider:
-		profileURL = "https://www.googleapis.com/oauth2/v2/userinfo"
-	case domains.FacebookOAuthProvider:
-		profileURL = "https://graph.facebook.com/v12.0/me?fields=id,email,first_name,last_name,picture,locale"
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
+	p, err := s.provider(provider)
+	if err != nil {
+		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", profileURL, nil)
+	profile, err := p.FetchProfile(ctx, token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("%s %s", token.TokenType, token.AccessToken))
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user profile: %w", err)
+	profile.Provider = provider
+	if err := profile.Validate(); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	profile.CreatedAt = time.Now()
+	profile.UpdatedAt = time.Now()
+
+	return profile, nil
+}
+
+func (s *oauthService) CompleteProfile(provider domains.OAuthProvider, profile *domains.OAuthProfile, params url.Values) error {
+	p, err := s.provider(provider)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get user profile: %s", string(body))
+	profiler, ok := p.(providers.CallbackProfiler)
+	if !ok {
+		return nil
 	}
 
-	var profile domains.OAuthProfile
-	if err := json.Unmarshal(body, &profile); err != nil {
-		return nil, fmt.Errorf("failed to parse profile data: %w", err)
+	if err := profiler.ProfileFromCallback(profile, params); err != nil {
+		return err
 	}
 
-	profile.Provider = provider
-	profile.CreatedAt = time.Now()
-	profile.UpdatedAt = time.Now()
-
-	return &profile, nil
+	return profile.Validate()
 }
 
 func (s *oauthService) RefreshToken(ctx context.Context, token *domains.OAuthToken) (*domains.OAuthToken, error) {
-	config, ok := s.configs[token.Provider]
-	if !ok {
-		return nil, fmt.Errorf("unsupported provider: %s", token.Provider)
+	config, err := s.oauthConfig(token.Provider)
+	if err != nil {
+		return nil, err
 	}
 
 	t := &oauth2.Token{
@@ -182,36 +367,28 @@ func (s *oauthService) RefreshToken(ctx context.Context, token *domains.OAuthTok
 }
 
 func (s *oauthService) RevokeToken(ctx context.Context, token *domains.OAuthToken) error {
-	var revokeURL string
-	switch token.Provider {
-	case domains.GoogleOAuthProvider:
-		revokeURL = fmt.Sprintf("https://accounts.google.com/o/oauth2/revoke?token=%s", token.AccessToken)
-	case domains.FacebookOAuthProvider:
-		revokeURL = fmt.Sprintf("https://graph.facebook.com/v12.0/me/permissions?access_token=%s", token.AccessToken)
-	default:
-		return fmt.Errorf("unsupported provider: %s", token.Provider)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", revokeURL, nil)
+	p, err := s.provider(token.Provider)
 	if err != nil {
-		return fmt.Errorf("failed to create revoke request: %w", err)
+		return err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to revoke token: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to revoke token: %s", string(body))
+	if err := p.RevokeToken(ctx, s.settings[token.Provider], token); err != nil {
+		return err
 	}
 
 	return s.repo.DeleteToken(ctx, token.UserID, token.Provider)
 }
 
 func (s *oauthService) LinkAccount(ctx context.Context, userID string, profile *domains.OAuthProfile, token *domains.OAuthToken) error {
+	existing, err := s.repo.GetProfile(ctx, profile.ProviderID, profile.Provider)
+	if err != nil && !errors.Is(err, domains.ErrProfileNotFound) {
+		return fmt.Errorf("failed to get profile: %w", err)
+	}
+	if existing != nil && existing.UserID != "" && existing.UserID != userID {
+		return domains.ErrAccountLinked
+	}
+
+	profile.UserID = userID
 	token.UserID = userID
 
 	if err := s.repo.SaveProfile(ctx, profile); err != nil {
@@ -237,3 +414,21 @@ func (s *oauthService) UnlinkAccount(ctx context.Context, userID string, provide
 
 	return nil
 }
+
+// urlOrigin returns the scheme and host of an absolute http(s) URL
+func urlOrigin(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// randomToken returns 32 random bytes encoded for use in URLs
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
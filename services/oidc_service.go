@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+)
+
+// OIDCService is the OpenID Connect layer on top of the authorization server
+type OIDCService interface {
+	// Discovery returns the provider metadata clients configure themselves from,
+	// or ErrOpenIDUnavailable while ID tokens cannot be issued
+	Discovery() (*domains.OpenIDConfiguration, error)
+	// UserInfo returns the claims about the user of an access token issued to a
+	// client, limited to what the granted scopes cover
+	UserInfo(ctx context.Context, claims *domains.AccessClaims) (*domains.UserInfo, error)
+}
+
+// oidcService implements OIDCService
+type oidcService struct {
+	authService domains.AuthService
+	users       UserRepository
+	profiles    OAuthRepository
+	publicURL   string
+}
+
+// NewOIDCService creates a new OpenID Connect service
+func NewOIDCService(
+	env lib.Env,
+	authService domains.AuthService,
+	users UserRepository,
+	profiles OAuthRepository,
+) OIDCService {
+	return &oidcService{
+		authService: authService,
+		users:       users,
+		profiles:    profiles,
+		publicURL:   strings.TrimRight(env.PublicURL, "/"),
+	}
+}
+
+func (s *oidcService) Discovery() (*domains.OpenIDConfiguration, error) {
+	if !s.authService.SupportsIDTokens() {
+		return nil, domains.ErrOpenIDUnavailable
+	}
+
+	algorithms := []string{}
+	for _, key := range s.authService.JWKS().Keys {
+		if !containsString(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+
+	configuration := &domains.OpenIDConfiguration{
+		Issuer:                            s.authService.Issuer(),
+		AuthorizationEndpoint:             s.publicURL + "/oauth2/authorize",
+		TokenEndpoint:                     s.publicURL + "/oauth2/token",
+		UserInfoEndpoint:                  s.publicURL + "/oauth2/userinfo",
+		IntrospectionEndpoint:             s.publicURL + "/oauth2/introspect",
+		RevocationEndpoint:                s.publicURL + "/oauth2/revoke",
+		JWKSURI:                           s.publicURL + "/.well-known/jwks.json",
+		ScopesSupported:                   []string{domains.OpenIDScope, domains.ProfileScope, domains.EmailScope},
+		ResponseTypesSupported:            []string{codeResponseType},
+		ResponseModesSupported:            []string{"query"},
+		GrantTypesSupported:               []string{domains.AuthorizationCodeGrant, domains.RefreshTokenGrant, domains.ClientCredentialsGrant},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algorithms,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{pkceMethodS256},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr", "acr", "azp",
+			"name", "given_name", "family_name", "picture", "locale", "updated_at",
+			"email", "email_verified",
+		},
+		ACRValuesSupported: []string{domains.SingleFactorACR, domains.MultiFactorACR},
+	}
+
+	return configuration, nil
+}
+
+func (s *oidcService) UserInfo(ctx context.Context, claims *domains.AccessClaims) (*domains.UserInfo, error) {
+	scopes := strings.Fields(claims.Scope)
+	if claims.FirstParty() || claims.Principal().Type != domains.UserPrincipal ||
+		!containsString(scopes, domains.OpenIDScope) {
+		return nil, domains.ErrInsufficientScope
+	}
+
+	user, err := s.users.GetByID(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if user.SuspendedAt != nil {
+		return nil, domains.ErrUserSuspended
+	}
+
+	info := &domains.UserInfo{Subject: user.ID}
+	wantsProfile := containsString(scopes, domains.ProfileScope)
+	wantsEmail := containsString(scopes, domains.EmailScope)
+	if !wantsProfile && !wantsEmail {
+		return info, nil
+	}
+
+	// Linked provider profiles fill in what the account itself lacks
+	profiles, err := s.profiles.ListProfiles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if wantsProfile {
+		info.Name = user.Name
+		info.GivenName = user.FirstName
+		info.FamilyName = user.LastName
+		info.Picture = user.Picture
+		info.Locale = user.Locale
+		for _, profile := range profiles {
+			info.Name = firstNonEmpty(info.Name, profile.Name)
+			info.GivenName = firstNonEmpty(info.GivenName, profile.FirstName)
+			info.FamilyName = firstNonEmpty(info.FamilyName, profile.LastName)
+			info.Picture = firstNonEmpty(info.Picture, profile.Picture)
+			info.Locale = firstNonEmpty(info.Locale, profile.Locale)
+		}
+		info.UpdatedAt = user.UpdatedAt.Unix()
+	}
+
+	if wantsEmail {
+		email, verified := user.Email, user.EmailVerified
+		if email == "" {
+			for _, profile := range profiles {
+				if profile.Email != "" {
+					email, verified = profile.Email, profile.EmailVerified
+					break
+				}
+			}
+		}
+		if email != "" {
+			info.Email = email
+			info.EmailVerified = &verified
+		}
+	}
+
+	return info, nil
+}
+
+// firstNonEmpty returns value unless it is empty, then fallback
+func firstNonEmpty(value string, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "partner-app"
+	testRedirectURI = "https://partner.example.com/callback"
+	testNonce       = "n-0S6_WzA2Mj"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// oidcTest wires the authorization server and OpenID Connect layer to
+// in-memory repositories, serving discovery and JWKS the way the routes do
+type oidcTest struct {
+	oauth2      OAuth2Service
+	oidc        OIDCService
+	authService domains.AuthService
+	client      *domains.OAuth2Client
+	user        *domains.User
+	profiles    *fakeOAuthRepository
+	server      *httptest.Server
+}
+
+func newOIDCTest(t *testing.T, env lib.Env) *oidcTest {
+	ot := &oidcTest{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		configuration, err := ot.oidc.Discovery()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(configuration)
+	})
+	mux.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ot.authService.JWKS())
+	})
+	ot.server = httptest.NewServer(mux)
+	t.Cleanup(ot.server.Close)
+
+	env.PublicURL = ot.server.URL
+	env.JWTIssuer = ot.server.URL
+
+	logger := testLogger()
+	authService, err := NewAuthService(env, logger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ot.user = &domains.User{
+		ID:            "user-1",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		Name:          "Jane Doe",
+		FirstName:     "Jane",
+		LastName:      "Doe",
+		UpdatedAt:     time.Now(),
+	}
+	users := newFakeUserRepository(ot.user)
+	ot.profiles = &fakeOAuthRepository{}
+
+	ot.client = &domains.OAuth2Client{
+		ID:           testClientID,
+		Name:         "Partner",
+		Public:       true,
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{domains.EmailScope, domains.OpenIDScope, domains.ProfileScope},
+		GrantTypes:   []string{domains.AuthorizationCodeGrant, domains.RefreshTokenGrant},
+	}
+	clientRepository := &fakeOAuth2ClientRepository{}
+	if err := clientRepository.Create(context.Background(), ot.client); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshTokens := &fakeRefreshTokenRepository{}
+	revocations := NewTokenRevocationService(env, logger, &fakeRevokedTokenRepository{})
+	roles := NewRoleService(logger, &fakeRoleRepository{}, users, revocations)
+	sessions := NewSessionService(env, logger, authService, revocations, users, roles, nil, refreshTokens)
+	clients := NewOAuth2ClientService(logger, clientRepository, refreshTokens)
+
+	ot.authService = authService
+	ot.oauth2 = NewOAuth2Service(
+		env,
+		logger,
+		authService,
+		revocations,
+		clients,
+		sessions,
+		refreshTokens,
+		&fakeAuthorizationRequestRepository{},
+		&fakeAuthorizationCodeRepository{},
+		&fakeOAuth2ConsentRepository{},
+	)
+	ot.oidc = NewOIDCService(env, authService, users, ot.profiles)
+
+	return ot
+}
+
+// rs256Env returns the settings of a server signing with a fresh RSA key
+func rs256Env(t *testing.T) lib.Env {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return lib.Env{JWTAlgorithm: jwt.SigningMethodRS256.Alg(), JWTPrivateKeyPath: path}
+}
+
+// signIn runs the authorization code flow with PKCE for scope, approved by the
+// test user who signed in with methods
+func (ot *oidcTest) signIn(t *testing.T, scope string, methods ...string) *domains.TokenResponse {
+	ctx := context.Background()
+
+	challenge := sha256.Sum256([]byte(testVerifier))
+	request, err := ot.oauth2.Authorize(ctx, &domains.AuthorizationParams{
+		ResponseType:        codeResponseType,
+		ClientID:            ot.client.ID,
+		RedirectURI:         testRedirectURI,
+		Scope:               scope,
+		State:               "af0ifjsldkj",
+		Nonce:               testNonce,
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(challenge[:]),
+		CodeChallengeMethod: pkceMethodS256,
+	})
+	if err != nil {
+		t.Fatalf("authorize failed: %v", err)
+	}
+
+	session := &domains.AccessClaims{AMR: methods}
+	session.Subject = ot.user.ID
+	session.AuthTime = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	redirect, err := ot.oauth2.Decide(ctx, request.ID, session, true)
+	if err != nil {
+		t.Fatalf("decide failed: %v", err)
+	}
+
+	location, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Query().Get("state") != "af0ifjsldkj" {
+		t.Fatalf("redirect %q does not return the state", redirect)
+	}
+
+	response, err := ot.oauth2.Token(ctx, ot.client, &domains.TokenRequest{
+		GrantType:    domains.AuthorizationCodeGrant,
+		Code:         location.Query().Get("code"),
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testVerifier,
+	})
+	if err != nil {
+		t.Fatalf("token exchange failed: %v", err)
+	}
+	return response
+}
+
+// userInfo serves /userinfo for an access token issued by the flow
+func (ot *oidcTest) userInfo(t *testing.T, accessToken string) (*domains.UserInfo, error) {
+	claims, err := ot.authService.Authorize(accessToken)
+	if err != nil {
+		t.Fatalf("access token rejected: %v", err)
+	}
+	return ot.oidc.UserInfo(context.Background(), claims)
+}
+
+func TestOpenIDConnectIDTokenVerifies(t *testing.T) {
+	ot := newOIDCTest(t, rs256Env(t))
+	ctx := context.Background()
+
+	response := ot.signIn(t, "openid profile email", domains.PasswordMethod, domains.MultiFactorMethod)
+	if response.IDToken == "" {
+		t.Fatal("no id_token issued for the openid scope")
+	}
+
+	provider, err := oidc.NewProvider(ctx, ot.server.URL)
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: ot.client.ID}).Verify(ctx, response.IDToken)
+	if err != nil {
+		t.Fatalf("id_token does not verify: %v", err)
+	}
+
+	if idToken.Subject != ot.user.ID || idToken.Nonce != testNonce {
+		t.Fatalf("sub = %q, nonce = %q", idToken.Subject, idToken.Nonce)
+	}
+
+	var claims struct {
+		AuthorizedParty string   `json:"azp"`
+		AuthTime        int64    `json:"auth_time"`
+		AMR             []string `json:"amr"`
+		ACR             string   `json:"acr"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.AuthorizedParty != ot.client.ID {
+		t.Fatalf("azp = %q, want %q", claims.AuthorizedParty, ot.client.ID)
+	}
+	if claims.AuthTime == 0 || claims.AuthTime > time.Now().Unix() {
+		t.Fatalf("auth_time = %d", claims.AuthTime)
+	}
+	if strings.Join(claims.AMR, " ") != "pwd mfa" || claims.ACR != domains.MultiFactorACR {
+		t.Fatalf("amr = %v, acr = %q", claims.AMR, claims.ACR)
+	}
+
+	// Neither token passes for the other
+	if _, err := ot.authService.Authorize(response.IDToken); err == nil {
+		t.Fatal("id_token was accepted as access token")
+	}
+	if _, err := provider.Verifier(&oidc.Config{ClientID: ot.client.ID}).Verify(ctx, response.AccessToken); err == nil {
+		t.Fatal("access token was accepted as id_token")
+	}
+}
+
+func TestOpenIDConnectACRReflectsSingleFactor(t *testing.T) {
+	ot := newOIDCTest(t, rs256Env(t))
+
+	response := ot.signIn(t, "openid", domains.PasswordMethod)
+
+	claims := &domains.IDClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(response.IDToken, claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.ACR != domains.SingleFactorACR {
+		t.Fatalf("acr = %q, want %q", claims.ACR, domains.SingleFactorACR)
+	}
+}
+
+func TestOpenIDConnectNoIDTokenWithoutOpenIDScope(t *testing.T) {
+	ot := newOIDCTest(t, rs256Env(t))
+
+	response := ot.signIn(t, "profile", domains.PasswordMethod)
+	if response.IDToken != "" {
+		t.Fatal("id_token issued without the openid scope")
+	}
+
+	if _, err := ot.userInfo(t, response.AccessToken); !errors.Is(err, domains.ErrInsufficientScope) {
+		t.Fatalf("userinfo without openid: err = %v, want ErrInsufficientScope", err)
+	}
+}
+
+func TestOpenIDConnectUserInfoScopes(t *testing.T) {
+	ot := newOIDCTest(t, rs256Env(t))
+
+	tests := []struct {
+		scope       string
+		wantProfile bool
+		wantEmail   bool
+	}{
+		{scope: "openid"},
+		{scope: "openid profile", wantProfile: true},
+		{scope: "openid email", wantEmail: true},
+		{scope: "openid profile email", wantProfile: true, wantEmail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			response := ot.signIn(t, tt.scope, domains.PasswordMethod)
+
+			info, err := ot.userInfo(t, response.AccessToken)
+			if err != nil {
+				t.Fatalf("userinfo failed: %v", err)
+			}
+			if info.Subject != ot.user.ID {
+				t.Fatalf("sub = %q, want %q", info.Subject, ot.user.ID)
+			}
+
+			hasProfile := info.Name != "" || info.GivenName != "" || info.FamilyName != "" || info.UpdatedAt != 0
+			if hasProfile != tt.wantProfile {
+				t.Fatalf("profile claims present = %v, want %v: %+v", hasProfile, tt.wantProfile, info)
+			}
+			if tt.wantProfile && (info.Name != "Jane Doe" || info.GivenName != "Jane" || info.FamilyName != "Doe") {
+				t.Fatalf("unexpected profile claims %+v", info)
+			}
+
+			hasEmail := info.Email != "" || info.EmailVerified != nil
+			if hasEmail != tt.wantEmail {
+				t.Fatalf("email claims present = %v, want %v: %+v", hasEmail, tt.wantEmail, info)
+			}
+			if tt.wantEmail && (info.Email != ot.user.Email || !*info.EmailVerified) {
+				t.Fatalf("unexpected email claims %+v", info)
+			}
+		})
+	}
+}
+
+func TestOpenIDConnectUserInfoFallsBackToLinkedProfiles(t *testing.T) {
+	ot := newOIDCTest(t, rs256Env(t))
+	ot.profiles.profiles = append(ot.profiles.profiles, &domains.OAuthProfile{
+		UserID:  ot.user.ID,
+		Picture: "https://cdn.example.com/jane.png",
+		Locale:  "de",
+		Name:    "Someone Else",
+	})
+
+	response := ot.signIn(t, "openid profile", domains.FederatedMethod)
+
+	info, err := ot.userInfo(t, response.AccessToken)
+	if err != nil {
+		t.Fatalf("userinfo failed: %v", err)
+	}
+	if info.Picture != "https://cdn.example.com/jane.png" || info.Locale != "de" {
+		t.Fatalf("missing claims were not filled from the linked profile: %+v", info)
+	}
+	if info.Name != "Jane Doe" {
+		t.Fatalf("name = %q, the account's own claims take precedence", info.Name)
+	}
+}
+
+func TestOpenIDConnectUnavailableWithSharedSecret(t *testing.T) {
+	ot := newOIDCTest(t, lib.Env{JWTSecret: "test secret"})
+
+	if _, err := ot.oidc.Discovery(); !errors.Is(err, domains.ErrOpenIDUnavailable) {
+		t.Fatalf("discovery: err = %v, want ErrOpenIDUnavailable", err)
+	}
+
+	challenge := sha256.Sum256([]byte(testVerifier))
+	_, err := ot.oauth2.Authorize(context.Background(), &domains.AuthorizationParams{
+		ResponseType:        codeResponseType,
+		ClientID:            ot.client.ID,
+		RedirectURI:         testRedirectURI,
+		Scope:               "openid",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(challenge[:]),
+		CodeChallengeMethod: pkceMethodS256,
+	})
+	var oauth2Err *domains.OAuth2Error
+	if !errors.As(err, &oauth2Err) || oauth2Err.Code != domains.InvalidScopeError {
+		t.Fatalf("authorize with openid: err = %v, want invalid_scope", err)
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"diandi-backend/lib"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	defaultPasswordHashMemory      = 64 * 1024
+	defaultPasswordHashIterations  = 3
+	defaultPasswordHashParallelism = 2
+
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+)
+
+var errInvalidPasswordHash = errors.New("invalid password hash")
+
+// PasswordHasher hashes passwords with argon2id. Hashes are stored in the PHC
+// string format so they carry their own parameters and stay verifiable after
+// the PASSWORD_HASH_* settings change.
+type PasswordHasher struct {
+	params argon2Params
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func NewPasswordHasher(env lib.Env) PasswordHasher {
+	params := argon2Params{
+		memory:      env.PasswordHashMemory,
+		iterations:  env.PasswordHashIterations,
+		parallelism: env.PasswordHashParallelism,
+	}
+	if params.memory == 0 {
+		params.memory = defaultPasswordHashMemory
+	}
+	if params.iterations == 0 {
+		params.iterations = defaultPasswordHashIterations
+	}
+	if params.parallelism == 0 {
+		params.parallelism = defaultPasswordHashParallelism
+	}
+
+	return PasswordHasher{params: params}
+}
+
+// Hash derives an encoded argon2id hash of password with a random salt
+func (h PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.iterations, h.params.memory, h.params.parallelism, passwordKeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.params.memory,
+		h.params.iterations,
+		h.params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify reports whether password matches the encoded hash, comparing in constant time
+func (h PasswordHasher) Verify(password string, encoded string) (bool, error) {
+	params, salt, key, err := decodePasswordHash(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+// NeedsRehash reports whether the hash was created with other parameters than
+// the configured ones
+func (h PasswordHasher) NeedsRehash(encoded string) bool {
+	params, _, _, err := decodePasswordHash(encoded)
+	return err != nil || params != h.params
+}
+
+func decodePasswordHash(encoded string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	return params, salt, key, nil
+}
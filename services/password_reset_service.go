@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+)
+
+const (
+	defaultPasswordResetTTL = 30 * time.Minute
+	passwordResetTimeout    = 30 * time.Second
+)
+
+// PasswordResetService lets users who forgot their password set a new one
+type PasswordResetService interface {
+	// RequestReset mails a reset link when email belongs to an active account. It
+	// returns before any lookup happens, so callers learn nothing about the email.
+	RequestReset(email string)
+
+	// ResetPassword redeems a reset token, sets the new password and ends every
+	// session of the user
+	ResetPassword(ctx context.Context, token string, password string) error
+}
+
+// PasswordResetRepository defines the interface for password reset token persistence
+type PasswordResetRepository interface {
+	Save(ctx context.Context, token *domains.PasswordResetToken) error
+	// Consume returns and removes the token, so each token is redeemed at most once
+	Consume(ctx context.Context, tokenHash string) (*domains.PasswordResetToken, error)
+	DeleteUser(ctx context.Context, userID string) error
+}
+
+// passwordResetService implements PasswordResetService
+type passwordResetService struct {
+	logger        lib.Logger
+	users         UserRepository
+	resetTokens   PasswordResetRepository
+	refreshTokens RefreshTokenRepository
+	revocations   TokenRevocationService
+	hasher        PasswordHasher
+	mailer        lib.Mailer
+	resetURL      string
+	ttl           time.Duration
+}
+
+// NewPasswordResetService creates a new password reset service
+func NewPasswordResetService(
+	env lib.Env,
+	logger lib.Logger,
+	users UserRepository,
+	resetTokens PasswordResetRepository,
+	refreshTokens RefreshTokenRepository,
+	revocations TokenRevocationService,
+	hasher PasswordHasher,
+	mailer lib.Mailer,
+) PasswordResetService {
+	ttl := env.PasswordResetTTL
+	if ttl <= 0 {
+		ttl = defaultPasswordResetTTL
+	}
+
+	return &passwordResetService{
+		logger:        logger,
+		users:         users,
+		resetTokens:   resetTokens,
+		refreshTokens: refreshTokens,
+		revocations:   revocations,
+		hasher:        hasher,
+		mailer:        mailer,
+		resetURL:      env.PasswordResetURL,
+		ttl:           ttl,
+	}
+}
+
+func (s *passwordResetService) RequestReset(email string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), passwordResetTimeout)
+		defer cancel()
+
+		if err := s.requestReset(ctx, normalizeEmail(email)); err != nil {
+			s.logger.Error("Failed to request password reset: ", err)
+		}
+	}()
+}
+
+func (s *passwordResetService) requestReset(ctx context.Context, email string) error {
+	user, err := s.users.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, domains.ErrUserNotFound) {
+			s.logger.Info("Password reset requested for an unknown email")
+			return nil
+		}
+		return err
+	}
+
+	if user.SuspendedAt != nil {
+		s.logger.Info("Password reset requested for suspended user ", user.ID)
+		return nil
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate password reset token: %w", err)
+	}
+
+	now := time.Now()
+	err = s.resetTokens.Save(ctx, &domains.PasswordResetToken{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, lib.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password of your account. To choose a new password, %s\n\nThis expires in %s. If you did not ask for a reset, ignore this email, your password stays unchanged.\n",
+			s.resetInstructions(token), s.ttl,
+		),
+	})
+}
+
+// resetInstructions points to the frontend reset page, or hands out the bare
+// token when PASSWORD_RESET_URL is not configured
+func (s *passwordResetService) resetInstructions(token string) string {
+	target, err := url.Parse(s.resetURL)
+	if s.resetURL == "" || err != nil {
+		return "use this reset token:\n\n" + token
+	}
+
+	query := target.Query()
+	query.Set("token", token)
+	target.RawQuery = query.Encode()
+
+	return "open the link below:\n\n" + target.String()
+}
+
+func (s *passwordResetService) ResetPassword(ctx context.Context, token string, password string) error {
+	if token == "" {
+		return domains.ErrInvalidResetToken
+	}
+
+	resetToken, err := s.resetTokens.Consume(ctx, hashToken(token))
+	if err != nil {
+		return err
+	}
+
+	user, err := s.users.GetByID(ctx, resetToken.UserID)
+	if err != nil {
+		if errors.Is(err, domains.ErrUserNotFound) {
+			return domains.ErrInvalidResetToken
+		}
+		return err
+	}
+	if user.SuspendedAt != nil {
+		return domains.ErrUserSuspended
+	}
+
+	passwordHash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	// Redeeming the mailed token proves ownership of the address
+	now := time.Now()
+	if err := s.users.SetPasswordHash(ctx, user.ID, passwordHash); err != nil {
+		return err
+	}
+	if !user.EmailVerified {
+		if err := s.users.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+			return err
+		}
+	}
+
+	// Whoever knew the old password must not keep a session
+	if err := s.resetTokens.DeleteUser(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.refreshTokens.RevokeUser(ctx, user.ID, now); err != nil {
+		return err
+	}
+	if err := s.revocations.RevokeUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	err = s.mailer.Send(ctx, lib.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body:    "The password of your account was just reset and all sessions were signed out. If this was not you, reset your password again right away and contact support.\n",
+	})
+	if err != nil {
+		// The reset itself succeeded, a missing notification must not undo it
+		s.logger.Error("Failed to send password change notification: ", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+)
+
+// RoleService manages roles and resolves the permissions they grant
+type RoleService interface {
+	ListRoles(ctx context.Context) ([]*domains.Role, error)
+
+	// AssignRoles replaces the roles of a user. Outstanding access tokens carry
+	// the previous permissions and are revoked, clients refresh to pick up the
+	// new ones.
+	AssignRoles(ctx context.Context, userID string, roles []string) (*domains.User, error)
+
+	// Permissions returns the union of the permissions granted by roles, roles
+	// that no longer exist grant nothing
+	Permissions(ctx context.Context, roles []string) ([]string, error)
+
+	// SeedDefaultRoles creates the default roles that do not exist yet, leaving
+	// roles changed by administrators untouched
+	SeedDefaultRoles(ctx context.Context) error
+}
+
+// RoleRepository defines the interface for role persistence
+type RoleRepository interface {
+	List(ctx context.Context) ([]*domains.Role, error)
+	GetMany(ctx context.Context, names []string) ([]*domains.Role, error)
+	// CreateIfMissing inserts the role unless one with its name exists and
+	// reports whether it was inserted
+	CreateIfMissing(ctx context.Context, role *domains.Role) (bool, error)
+}
+
+// roleService implements RoleService
+type roleService struct {
+	logger      lib.Logger
+	roles       RoleRepository
+	users       UserRepository
+	revocations TokenRevocationService
+}
+
+// NewRoleService creates a new role service
+func NewRoleService(
+	logger lib.Logger,
+	roles RoleRepository,
+	users UserRepository,
+	revocations TokenRevocationService,
+) RoleService {
+	return &roleService{
+		logger:      logger,
+		roles:       roles,
+		users:       users,
+		revocations: revocations,
+	}
+}
+
+func (s *roleService) ListRoles(ctx context.Context) ([]*domains.Role, error) {
+	return s.roles.List(ctx)
+}
+
+func (s *roleService) AssignRoles(ctx context.Context, userID string, roles []string) (*domains.User, error) {
+	roles = uniqueSorted(roles)
+
+	existing, err := s.roles.GetMany(ctx, roles)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) != len(roles) {
+		return nil, domains.ErrRoleNotFound
+	}
+
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.SetRoles(ctx, userID, roles); err != nil {
+		return nil, err
+	}
+	user.Roles = roles
+
+	s.logger.Info("Assigned roles ", roles, " to user ", userID)
+
+	if err := s.revocations.RevokeUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *roleService) Permissions(ctx context.Context, roles []string) ([]string, error) {
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	granted, err := s.roles.GetMany(ctx, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []string
+	for _, role := range granted {
+		permissions = append(permissions, role.Permissions...)
+	}
+
+	return uniqueSorted(permissions), nil
+}
+
+func (s *roleService) SeedDefaultRoles(ctx context.Context) error {
+	now := time.Now()
+	for _, role := range domains.DefaultRoles() {
+		role.CreatedAt = now
+		role.UpdatedAt = now
+
+		created, err := s.roles.CreateIfMissing(ctx, role)
+		if err != nil {
+			return err
+		}
+		if created {
+			s.logger.Info("Created role ", role.Name)
+		}
+	}
+
+	return nil
+}
+
+// uniqueSorted returns the distinct non empty values in ascending order
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" && !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
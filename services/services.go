@@ -4,4 +4,20 @@ import "go.uber.org/fx"
 
 var Module = fx.Options(
 	fx.Provide(NewAuthService),
+	fx.Provide(NewOAuthService),
+	fx.Provide(NewUserService),
+	fx.Provide(NewSessionService),
+	fx.Provide(NewSigningKeyService),
+	fx.Provide(NewTokenRevocationService),
+	fx.Provide(NewPasswordHasher),
+	fx.Provide(NewEmailVerificationService),
+	fx.Provide(NewPasswordResetService),
+	fx.Provide(NewMFAService),
+	fx.Provide(NewWebAuthnService),
+	fx.Provide(NewEmailLoginService),
+	fx.Provide(NewRoleService),
+	fx.Provide(NewAPIKeyService),
+	fx.Provide(NewOAuth2ClientService),
+	fx.Provide(NewOAuth2Service),
+	fx.Provide(NewOIDCService),
 )
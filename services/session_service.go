@@ -0,0 +1,382 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	loginCodeTTL                  = time.Minute
+	defaultRefreshTokenExpiration = 30 * 24 * time.Hour
+)
+
+// SessionService defines the interface for issuing our own tokens to clients
+type SessionService interface {
+	// IssueTokens creates the tokens of a new session for the user, who just
+	// signed in as described by auth
+	IssueTokens(ctx context.Context, userID string, auth domains.Authentication) (*domains.TokenPair, error)
+
+	// Refresh rotates a refresh token into a new token pair of the same family
+	Refresh(ctx context.Context, refreshToken string) (*domains.TokenPair, error)
+
+	// IssueClientTokens creates the tokens of a grant to an OAuth2 client
+	IssueClientTokens(ctx context.Context, grant domains.ClientGrant) (*domains.TokenPair, error)
+	// RefreshClient rotates a refresh token bound to clientID. A non empty scopes
+	// narrows the new access token, the refresh token keeps the granted scopes.
+	RefreshClient(ctx context.Context, clientID string, refreshToken string, scopes []string) (*domains.TokenPair, error)
+
+	// Logout revokes the access token and, when given, the refresh token family of
+	// the session
+	Logout(ctx context.Context, claims *domains.AccessClaims, refreshToken string) error
+
+	// Login codes
+	CreateLoginCode(ctx context.Context, userID string) (string, error)
+	// RedeemLoginCode consumes a login code and returns the user it was issued for
+	RedeemLoginCode(ctx context.Context, code string) (string, error)
+}
+
+// RefreshTokenRepository defines the interface for refresh token persistence
+type RefreshTokenRepository interface {
+	Save(ctx context.Context, token *domains.RefreshToken) error
+	GetByHash(ctx context.Context, tokenHash string) (*domains.RefreshToken, error)
+	// MarkRotated flags an active token as used and reports whether this call did so
+	MarkRotated(ctx context.Context, tokenHash string, rotatedAt time.Time) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error
+	RevokeClient(ctx context.Context, clientID string, revokedAt time.Time) error
+}
+
+// LoginCodeRepository defines the interface for login code persistence
+type LoginCodeRepository interface {
+	Save(ctx context.Context, code *domains.LoginCode) error
+	// Consume returns and removes the code, so each code is redeemed at most once
+	Consume(ctx context.Context, codeHash string) (*domains.LoginCode, error)
+}
+
+// sessionService implements SessionService
+type sessionService struct {
+	logger            lib.Logger
+	authService       domains.AuthService
+	revocations       TokenRevocationService
+	users             UserRepository
+	roles             RoleService
+	loginCodes        LoginCodeRepository
+	refreshTokens     RefreshTokenRepository
+	expiration        time.Duration
+	refreshExpiration time.Duration
+}
+
+// tokenGrant describes the tokens to issue, for our own frontend or for a client
+type tokenGrant struct {
+	userID   string
+	familyID string
+	clientID string
+	// scopes are kept on the refresh token, accessScopes go into the access token
+	scopes       []string
+	accessScopes []string
+	refreshable  bool
+	auth         domains.Authentication
+}
+
+// NewSessionService creates a new session service
+func NewSessionService(
+	env lib.Env,
+	logger lib.Logger,
+	authService domains.AuthService,
+	revocations TokenRevocationService,
+	users UserRepository,
+	roles RoleService,
+	loginCodes LoginCodeRepository,
+	refreshTokens RefreshTokenRepository,
+) SessionService {
+	refreshExpiration := env.RefreshTokenExpiration
+	if refreshExpiration <= 0 {
+		refreshExpiration = defaultRefreshTokenExpiration
+	}
+
+	return &sessionService{
+		logger:            logger,
+		authService:       authService,
+		revocations:       revocations,
+		users:             users,
+		roles:             roles,
+		loginCodes:        loginCodes,
+		refreshTokens:     refreshTokens,
+		expiration:        tokenExpiration(env),
+		refreshExpiration: refreshExpiration,
+	}
+}
+
+func (s *sessionService) IssueTokens(ctx context.Context, userID string, auth domains.Authentication) (*domains.TokenPair, error) {
+	familyID, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token family: %w", err)
+	}
+
+	return s.issueTokens(ctx, tokenGrant{userID: userID, familyID: familyID, refreshable: true, auth: auth})
+}
+
+func (s *sessionService) Refresh(ctx context.Context, refreshToken string) (*domains.TokenPair, error) {
+	saved, err := s.rotate(ctx, refreshToken, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, tokenGrant{
+		userID:      saved.UserID,
+		familyID:    saved.FamilyID,
+		refreshable: true,
+		auth:        saved.Authentication,
+	})
+}
+
+func (s *sessionService) IssueClientTokens(ctx context.Context, grant domains.ClientGrant) (*domains.TokenPair, error) {
+	familyID, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token family: %w", err)
+	}
+
+	return s.issueTokens(ctx, tokenGrant{
+		userID:       grant.UserID,
+		familyID:     familyID,
+		clientID:     grant.ClientID,
+		scopes:       grant.Scopes,
+		accessScopes: grant.Scopes,
+		refreshable:  grant.Refreshable,
+		auth:         grant.Authentication,
+	})
+}
+
+func (s *sessionService) RefreshClient(ctx context.Context, clientID string, refreshToken string, scopes []string) (*domains.TokenPair, error) {
+	if clientID == "" {
+		return nil, domains.ErrInvalidRefreshToken
+	}
+
+	saved, err := s.rotate(ctx, refreshToken, clientID, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	accessScopes := saved.Scopes
+	if len(scopes) > 0 {
+		accessScopes = scopes
+	}
+
+	return s.issueTokens(ctx, tokenGrant{
+		userID:       saved.UserID,
+		familyID:     saved.FamilyID,
+		clientID:     clientID,
+		scopes:       saved.Scopes,
+		accessScopes: accessScopes,
+		refreshable:  true,
+		auth:         saved.Authentication,
+	})
+}
+
+// rotate redeems a refresh token issued to clientID, empty for our own frontend,
+// and marks it used. Requested scopes must be among those granted.
+func (s *sessionService) rotate(ctx context.Context, refreshToken string, clientID string, scopes []string) (*domains.RefreshToken, error) {
+	if refreshToken == "" {
+		return nil, domains.ErrInvalidRefreshToken
+	}
+
+	tokenHash := hashToken(refreshToken)
+	saved, err := s.refreshTokens.GetByHash(ctx, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if saved.RevokedAt != nil || now.After(saved.ExpiresAt) || saved.ClientID != clientID {
+		return nil, domains.ErrInvalidRefreshToken
+	}
+
+	// A rotated token coming back means it leaked, kill every token derived from it
+	if saved.RotatedAt != nil {
+		return nil, s.revokeReusedFamily(ctx, saved, now)
+	}
+
+	// Checked before rotating so an invalid request does not spend the token
+	for _, scope := range scopes {
+		if !containsString(saved.Scopes, scope) {
+			return nil, domains.ErrInvalidScope
+		}
+	}
+
+	rotated, err := s.refreshTokens.MarkRotated(ctx, tokenHash, now)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// A concurrent request redeemed the same token first
+		return nil, s.revokeReusedFamily(ctx, saved, now)
+	}
+
+	return saved, nil
+}
+
+func (s *sessionService) Logout(ctx context.Context, claims *domains.AccessClaims, refreshToken string) error {
+	if err := s.revocations.RevokeToken(ctx, claims); err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	saved, err := s.refreshTokens.GetByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, domains.ErrInvalidRefreshToken) {
+			return nil
+		}
+		return err
+	}
+
+	// Only the owner of the session may end it
+	if saved.UserID != claims.Subject {
+		return nil
+	}
+
+	return s.refreshTokens.RevokeFamily(ctx, saved.FamilyID, time.Now())
+}
+
+// revokeReusedFamily revokes the family of a replayed refresh token
+func (s *sessionService) revokeReusedFamily(ctx context.Context, token *domains.RefreshToken, now time.Time) error {
+	s.logger.Warn("Refresh token reuse detected, revoking family ", token.FamilyID, " of user ", token.UserID)
+	if err := s.refreshTokens.RevokeFamily(ctx, token.FamilyID, now); err != nil {
+		return err
+	}
+	return domains.ErrRefreshTokenReused
+}
+
+// issueTokens creates an access token and, for refreshable grants, a refresh
+// token belonging to the grant's family. Tokens issued to a client only carry
+// the permissions among its scopes that the user holds, and no roles.
+func (s *sessionService) issueTokens(ctx context.Context, grant tokenGrant) (*domains.TokenPair, error) {
+	user, err := s.users.GetByID(ctx, grant.userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.SuspendedAt != nil {
+		return nil, domains.ErrUserSuspended
+	}
+
+	permissions, err := s.roles.Permissions(ctx, user.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &domains.AccessClaims{
+		Roles:       user.Roles,
+		Permissions: permissions,
+		AMR:         grant.auth.Methods,
+	}
+	if !grant.auth.Time.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(grant.auth.Time)
+	}
+	if grant.clientID != "" {
+		claims = &domains.AccessClaims{
+			Permissions: grantedScopes(grant.accessScopes, permissions),
+			ClientID:    grant.clientID,
+			Scope:       strings.Join(grant.accessScopes, " "),
+		}
+	}
+	claims.Subject = grant.userID
+	accessToken, err := s.authService.CreateToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	pair := &domains.TokenPair{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.expiration / time.Second),
+		Scopes:      grant.accessScopes,
+	}
+	if !grant.refreshable {
+		return pair, nil
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	now := time.Now()
+	err = s.refreshTokens.Save(ctx, &domains.RefreshToken{
+		TokenHash:      hashToken(refreshToken),
+		FamilyID:       grant.familyID,
+		UserID:         grant.userID,
+		ClientID:       grant.clientID,
+		Scopes:         grant.scopes,
+		Authentication: grant.auth,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.refreshExpiration),
+	})
+	if err != nil {
+		return nil, err
+	}
+	pair.RefreshToken = refreshToken
+
+	return pair, nil
+}
+
+func (s *sessionService) CreateLoginCode(ctx context.Context, userID string) (string, error) {
+	code, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate login code: %w", err)
+	}
+
+	now := time.Now()
+	loginCode := &domains.LoginCode{
+		CodeHash:  hashToken(code),
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(loginCodeTTL),
+	}
+
+	if err := s.loginCodes.Save(ctx, loginCode); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+func (s *sessionService) RedeemLoginCode(ctx context.Context, code string) (string, error) {
+	if code == "" {
+		return "", domains.ErrInvalidLoginCode
+	}
+
+	loginCode, err := s.loginCodes.Consume(ctx, hashToken(code))
+	if err != nil {
+		return "", err
+	}
+
+	return loginCode.UserID, nil
+}
+
+// hashToken returns the SHA-256 digest of an opaque token, tokens are high entropy
+// random values so no salt or slow hash is needed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyPublishLeeway keeps retired keys around a little longer than the tokens they signed
+const keyPublishLeeway = time.Minute
+
+// SigningKeyService manages the access token signing keys stored in the database
+type SigningKeyService interface {
+	// Rotate creates a new signing key and retires the previous ones, which stay
+	// published until the longest lived tokens they signed have expired
+	Rotate(ctx context.Context) (*domains.SigningKey, error)
+
+	// PublishedKeys returns the decrypted keys still valid for verification, newest
+	// first, creating the initial key when none exist yet
+	PublishedKeys(ctx context.Context) ([]*domains.SigningKey, error)
+}
+
+// SigningKeyRepository defines the interface for signing key persistence
+type SigningKeyRepository interface {
+	Save(ctx context.Context, key *domains.SigningKey) error
+	// SaveInitial stores the initial key, reporting false when one already exists
+	SaveInitial(ctx context.Context, key *domains.SigningKey) (bool, error)
+	// ListPublished returns the keys published at now, newest first
+	ListPublished(ctx context.Context, now time.Time) ([]*domains.SigningKey, error)
+	// Retire retires every active key but exceptID
+	Retire(ctx context.Context, exceptID string, retiredAt time.Time, publishUntil time.Time) error
+}
+
+// signingKeyService implements SigningKeyService
+type signingKeyService struct {
+	logger     lib.Logger
+	repo       SigningKeyRepository
+	cipher     lib.Cipher
+	algorithm  string
+	publishFor time.Duration
+}
+
+// NewSigningKeyService creates a new signing key service
+func NewSigningKeyService(env lib.Env, logger lib.Logger, repo SigningKeyRepository, cipher lib.Cipher) SigningKeyService {
+	// Published keys must be asymmetric, HS256 falls back to RS256
+	algorithm := env.JWTAlgorithm
+	if algorithm != jwt.SigningMethodEdDSA.Alg() {
+		algorithm = jwt.SigningMethodRS256.Alg()
+	}
+
+	return &signingKeyService{
+		logger:     logger,
+		repo:       repo,
+		cipher:     cipher,
+		algorithm:  algorithm,
+		publishFor: signedTokenLifetime(env) + keyPublishLeeway,
+	}
+}
+
+// signedTokenLifetime returns the longest lifetime of the tokens signed with the
+// keys: access and ID tokens, and action tokens such as mailed verification links
+func signedTokenLifetime(env lib.Env) time.Duration {
+	lifetime := tokenExpiration(env)
+	for _, ttl := range []time.Duration{emailVerificationTTL(env), mfaChallengeTTL} {
+		if ttl > lifetime {
+			lifetime = ttl
+		}
+	}
+	return lifetime
+}
+
+func (s *signingKeyService) Rotate(ctx context.Context) (*domains.SigningKey, error) {
+	key, err := s.generate()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Save(ctx, key); err != nil {
+		return nil, err
+	}
+
+	// Retire only after the new key is stored so there always is an active key
+	now := time.Now()
+	if err := s.repo.Retire(ctx, key.ID, now, now.Add(s.publishFor)); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Rotated signing key, new kid ", key.ID)
+
+	return key, nil
+}
+
+func (s *signingKeyService) PublishedKeys(ctx context.Context) ([]*domains.SigningKey, error) {
+	keys, err := s.repo.ListPublished(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return s.createInitial(ctx)
+	}
+
+	return s.decrypt(keys)
+}
+
+// decrypt fills in the private keys of stored keys
+func (s *signingKeyService) decrypt(keys []*domains.SigningKey) ([]*domains.SigningKey, error) {
+	for _, key := range keys {
+		der, err := s.cipher.Decrypt(key.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt signing key %s: %w", key.ID, err)
+		}
+		key.Key, err = x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key %s: %w", key.ID, err)
+		}
+	}
+
+	return keys, nil
+}
+
+// createInitial creates the first signing key. Instances starting at the same
+// time race for a single initial key, the losers load the winner's.
+func (s *signingKeyService) createInitial(ctx context.Context) ([]*domains.SigningKey, error) {
+	key, err := s.generate()
+	if err != nil {
+		return nil, err
+	}
+	key.Initial = true
+
+	created, err := s.repo.SaveInitial(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		s.logger.Info("Created initial signing key, kid ", key.ID)
+		return []*domains.SigningKey{key}, nil
+	}
+
+	keys, err := s.repo.ListPublished(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("initial signing key exists but is not published")
+	}
+
+	return s.decrypt(keys)
+}
+
+// generate creates a new key pair with its private half encrypted for storage
+func (s *signingKeyService) generate() (*domains.SigningKey, error) {
+	var private interface{}
+	var err error
+	switch s.algorithm {
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signing key: %w", err)
+	}
+
+	encrypted, err := s.cipher.Encrypt(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt signing key: %w", err)
+	}
+
+	kid, err := newTokenID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key id: %w", err)
+	}
+
+	return &domains.SigningKey{
+		ID:         kid,
+		Algorithm:  s.algorithm,
+		PrivateKey: encrypted,
+		CreatedAt:  time.Now(),
+		Key:        private,
+	}, nil
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newSigningKeyTest(t *testing.T, env lib.Env) (SigningKeyService, *fakeSigningKeyRepository) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	cipher, err := lib.NewCipher(lib.Env{EncryptionKey: base64.StdEncoding.EncodeToString(secret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &fakeSigningKeyRepository{}
+	return NewSigningKeyService(env, testLogger(), repo, cipher), repo
+}
+
+func TestRotatedKeyVerifiesOutstandingActionTokens(t *testing.T) {
+	env := lib.Env{JWTKeySource: DatabaseKeySource, JWTExpiration: 15 * time.Minute}
+	keys, repo := newSigningKeyTest(t, env)
+	ctx := context.Background()
+
+	authService, err := NewAuthService(env, testLogger(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := &domains.ActionClaims{Email: "jane@example.com"}
+	claims.Subject = "user-1"
+	link, err := authService.CreateActionToken(domains.EmailVerificationPurpose, claims, emailVerificationTTL(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(link, &domains.ActionClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retiredKID := token.Header["kid"]
+
+	rotated, err := keys.Rotate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An instance starting after the rotation signs with the new key and still
+	// accepts the link
+	restarted, err := NewAuthService(env, testLogger(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.VerifyActionToken(domains.EmailVerificationPurpose, link); err != nil {
+		t.Fatalf("verification link rejected after rotation: %v", err)
+	}
+	fresh, err := restarted.CreateActionToken(domains.EmailVerificationPurpose, claims, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, _, _ := jwt.NewParser().ParseUnverified(fresh, &domains.ActionClaims{}); token.Header["kid"] != rotated.ID {
+		t.Fatalf("signed with kid %v, want the rotated key %s", token.Header["kid"], rotated.ID)
+	}
+
+	// The retired key outlives the link, not just the much shorter access tokens
+	expiry := time.Now().Add(emailVerificationTTL(env))
+	published, err := repo.ListPublished(ctx, expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, key := range published {
+		found = found || key.ID == retiredKID
+	}
+	if !found {
+		t.Fatalf("retired key %v is no longer published when the link expires", retiredKID)
+	}
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+)
+
+const (
+	// revocationSyncInterval bounds how long a revocation made on another instance
+	// takes to be enforced here
+	revocationSyncInterval = 5 * time.Second
+	// revocationSyncOverlap re-reads recent entries to tolerate clock skew between instances
+	revocationSyncOverlap = time.Minute
+	revocationSyncTimeout = 5 * time.Second
+
+	userRevocationPrefix = "user:"
+)
+
+// TokenRevocationService maintains the access token denylist
+type TokenRevocationService interface {
+	// RevokeToken denylists a single access token until it expires
+	RevokeToken(ctx context.Context, claims *domains.AccessClaims) error
+
+	// RevokeUser denylists every access token issued to the user so far
+	RevokeUser(ctx context.Context, userID string) error
+
+	// IsRevoked reports whether the token was revoked
+	IsRevoked(claims *domains.AccessClaims) bool
+}
+
+// RevokedTokenRepository defines the interface for denylist persistence
+type RevokedTokenRepository interface {
+	// Save inserts or replaces the entry with the same ID
+	Save(ctx context.Context, token *domains.RevokedToken) error
+	// ListSince returns the unexpired entries revoked at or after since
+	ListSince(ctx context.Context, since time.Time) ([]*domains.RevokedToken, error)
+}
+
+// tokenRevocationService implements TokenRevocationService. Lookups are served
+// from memory, the cache is synced from the repository every few seconds so
+// revocations propagate across instances.
+type tokenRevocationService struct {
+	logger          lib.Logger
+	repo            RevokedTokenRepository
+	tokenExpiration time.Duration
+
+	mu       sync.RWMutex
+	entries  map[string]*domains.RevokedToken
+	syncedAt time.Time
+	since    time.Time
+}
+
+// NewTokenRevocationService creates a new token revocation service
+func NewTokenRevocationService(env lib.Env, logger lib.Logger, repo RevokedTokenRepository) TokenRevocationService {
+	return &tokenRevocationService{
+		logger:          logger,
+		repo:            repo,
+		tokenExpiration: tokenExpiration(env),
+		entries:         make(map[string]*domains.RevokedToken),
+	}
+}
+
+func (s *tokenRevocationService) RevokeToken(ctx context.Context, claims *domains.AccessClaims) error {
+	// Tokens without an expiry are rejected by Authorize, nothing to denylist
+	if claims.ID == "" || claims.ExpiresAt == nil || time.Now().After(claims.ExpiresAt.Time) {
+		return nil
+	}
+
+	return s.save(ctx, &domains.RevokedToken{
+		ID:        claims.ID,
+		TokenID:   claims.ID,
+		UserID:    claims.Subject,
+		RevokedAt: time.Now(),
+		ExpiresAt: claims.ExpiresAt.Time,
+	})
+}
+
+func (s *tokenRevocationService) RevokeUser(ctx context.Context, userID string) error {
+	// Every token issued so far expires within one token lifetime
+	now := time.Now()
+	return s.save(ctx, &domains.RevokedToken{
+		ID:        userRevocationPrefix + userID,
+		UserID:    userID,
+		RevokedAt: now,
+		ExpiresAt: now.Add(s.tokenExpiration),
+	})
+}
+
+func (s *tokenRevocationService) IsRevoked(claims *domains.AccessClaims) bool {
+	s.sync()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.entries[claims.ID]; ok && claims.ID != "" {
+		return true
+	}
+
+	// iat only has second precision, tokens issued within the second of the
+	// revocation stay valid so a sign in right after it is not rejected
+	if entry, ok := s.entries[userRevocationPrefix+claims.Subject]; ok {
+		return claims.IssuedAt == nil || claims.IssuedAt.Before(entry.RevokedAt.Truncate(time.Second))
+	}
+
+	return false
+}
+
+// save persists the entry and applies it locally right away
+func (s *tokenRevocationService) save(ctx context.Context, entry *domains.RevokedToken) error {
+	if err := s.repo.Save(ctx, entry); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.entries[entry.ID] = entry
+	s.mu.Unlock()
+
+	return nil
+}
+
+// sync pulls entries revoked elsewhere since the last sync and drops expired ones,
+// keeping the cached entries if the repository is unavailable
+func (s *tokenRevocationService) sync() {
+	s.mu.RLock()
+	fresh := time.Since(s.syncedAt) < revocationSyncInterval
+	since := s.since
+	s.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), revocationSyncTimeout)
+	defer cancel()
+
+	now := time.Now()
+	entries, err := s.repo.ListSince(ctx, since)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncedAt = now
+	if err != nil {
+		s.logger.Error("Failed to sync revoked tokens: ", err)
+		return
+	}
+	s.since = now.Add(-revocationSyncOverlap)
+
+	for id, entry := range s.entries {
+		if now.After(entry.ExpiresAt) {
+			delete(s.entries, id)
+		}
+	}
+	for _, entry := range entries {
+		s.entries[entry.ID] = entry
+	}
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+)
+
+const verificationSendTimeout = 30 * time.Second
+
+// UserService defines the interface for user account operations
+type UserService interface {
+	GetUser(ctx context.Context, userID string) (*domains.User, error)
+
+	// ResolveOAuthUser returns the owner of the given provider profile, creating
+	// a new user on first sign in. The boolean reports whether a user was created.
+	// A new profile sharing its email with a user is linked to that user when both
+	// sides verified the address, otherwise ErrEmailTaken is returned.
+	ResolveOAuthUser(ctx context.Context, profile *domains.OAuthProfile) (*domains.User, bool, error)
+
+	// Register creates a password account. Registering a taken email succeeds
+	// silently so the response does not reveal which emails have accounts.
+	Register(ctx context.Context, email string, password string, name string) error
+	// Authenticate returns the user owning the credentials, or ErrInvalidCredentials
+	// for an unknown email and a wrong password alike
+	Authenticate(ctx context.Context, email string, password string) (*domains.User, error)
+
+	// SuspendUser blocks the user from signing in and revokes all of their tokens
+	SuspendUser(ctx context.Context, userID string) error
+	// ReinstateUser lifts a suspension
+	ReinstateUser(ctx context.Context, userID string) error
+}
+
+// UserRepository defines the interface for user persistence
+type UserRepository interface {
+	Create(ctx context.Context, user *domains.User) error
+	GetByID(ctx context.Context, id string) (*domains.User, error)
+	GetByEmail(ctx context.Context, email string) (*domains.User, error)
+	// The setters below write only the named fields, so concurrent changes to
+	// other parts of the user are never lost
+
+	SetPasswordHash(ctx context.Context, userID string, passwordHash string) error
+	// MarkEmailVerified verifies the email of the user if it is still email
+	MarkEmailVerified(ctx context.Context, userID string, email string) error
+	// ClaimEmail verifies the still unverified email of the user for its proven
+	// owner, dropping the password and second factors whoever registered it set
+	// up. It reports whether this call claimed the address.
+	ClaimEmail(ctx context.Context, userID string, email string) (bool, error)
+	SetRoles(ctx context.Context, userID string, roles []string) error
+	// SetSuspended suspends the user, or lifts the suspension when suspendedAt is nil
+	SetSuspended(ctx context.Context, userID string, suspendedAt *time.Time) error
+	// SetWebAuthnMFA records whether the user has passkeys serving as second factor
+	SetWebAuthnMFA(ctx context.Context, userID string, enabled bool) error
+	// SetPendingTOTPSecret stores a TOTP secret awaiting confirmation, reporting
+	// false when MFA is already enabled
+	SetPendingTOTPSecret(ctx context.Context, userID string, secret []byte) (bool, error)
+	// EnableTOTP turns on TOTP with the pending secret, reporting false when MFA
+	// got enabled or the pending secret replaced in the meantime
+	EnableTOTP(ctx context.Context, userID string, pendingSecret []byte, step int64, recoveryCodes []string, enabledAt time.Time) (bool, error)
+	// SetRecoveryCodes replaces the hashed recovery codes of the user
+	SetRecoveryCodes(ctx context.Context, userID string, recoveryCodes []string) error
+	// AdvanceTOTPStep records the TOTP step last used, reporting false when the
+	// step is not newer than the recorded one
+	AdvanceTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	// ConsumeRecoveryCode removes the hashed recovery code, reporting false when
+	// the user does not have it
+	ConsumeRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error)
+	// RecordMFAFailure atomically counts a failed MFA attempt and, once the count
+	// reaches maxFailures, locks the second factor until lockedUntil and starts
+	// counting anew. It reports whether this failure locked the second factor
+	RecordMFAFailure(ctx context.Context, userID string, maxFailures int, lockedUntil time.Time) (bool, error)
+	// ResetMFAAttempts clears the failed MFA attempt counter and lockout
+	ResetMFAAttempts(ctx context.Context, userID string) error
+	// ClearTOTP turns the TOTP second factor off, removing its secrets and recovery
+	// codes while leaving passkeys alone
+	ClearTOTP(ctx context.Context, userID string) error
+}
+
+// userService implements UserService
+type userService struct {
+	logger        lib.Logger
+	repo          UserRepository
+	oauthRepo     OAuthRepository
+	refreshTokens RefreshTokenRepository
+	revocations   TokenRevocationService
+	verification  EmailVerificationService
+	hasher        PasswordHasher
+
+	// dummyHash is verified for unknown emails so they take as long as wrong passwords
+	dummyHash     string
+	dummyHashOnce sync.Once
+}
+
+// NewUserService creates a new user service
+func NewUserService(
+	logger lib.Logger,
+	repo UserRepository,
+	oauthRepo OAuthRepository,
+	refreshTokens RefreshTokenRepository,
+	revocations TokenRevocationService,
+	verification EmailVerificationService,
+	hasher PasswordHasher,
+) UserService {
+	return &userService{
+		logger:        logger,
+		repo:          repo,
+		oauthRepo:     oauthRepo,
+		refreshTokens: refreshTokens,
+		revocations:   revocations,
+		verification:  verification,
+		hasher:        hasher,
+	}
+}
+
+func (s *userService) GetUser(ctx context.Context, userID string) (*domains.User, error) {
+	return s.repo.GetByID(ctx, userID)
+}
+
+func (s *userService) ResolveOAuthUser(ctx context.Context, profile *domains.OAuthProfile) (*domains.User, bool, error) {
+	existing, err := s.oauthRepo.GetProfile(ctx, profile.ProviderID, profile.Provider)
+	switch {
+	case err == nil && existing.UserID != "":
+		user, err := s.repo.GetByID(ctx, existing.UserID)
+		if err != nil {
+			return nil, false, err
+		}
+		return user, false, nil
+	case err != nil && !errors.Is(err, domains.ErrProfileNotFound):
+		return nil, false, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	email := normalizeEmail(profile.Email)
+	if email != "" {
+		owner, err := s.repo.GetByEmail(ctx, email)
+		switch {
+		case err == nil:
+			return s.linkByEmail(owner, profile)
+		case !errors.Is(err, domains.ErrUserNotFound):
+			return nil, false, err
+		}
+	}
+
+	now := time.Now()
+	user := &domains.User{
+		Email:         email,
+		EmailVerified: profile.EmailVerified,
+		Name:          profile.Name,
+		FirstName:     profile.FirstName,
+		LastName:      profile.LastName,
+		Picture:       profile.Picture,
+		Locale:        profile.Locale,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if err := s.repo.Create(ctx, user); err != nil {
+		// Another sign in took the email in the meantime
+		if errors.Is(err, domains.ErrEmailTaken) {
+			owner, err := s.repo.GetByEmail(ctx, email)
+			if err != nil {
+				return nil, false, err
+			}
+			return s.linkByEmail(owner, profile)
+		}
+		return nil, false, err
+	}
+
+	if !user.EmailVerified && user.Email != "" {
+		s.sendVerification(*user)
+	}
+
+	return user, true, nil
+}
+
+// linkByEmail resolves a new profile to the user already owning its email. Only
+// an address verified by both the provider and the user proves it is the same
+// person, otherwise whoever registered the email first could take over the other.
+func (s *userService) linkByEmail(owner *domains.User, profile *domains.OAuthProfile) (*domains.User, bool, error) {
+	if !profile.EmailVerified || !owner.EmailVerified {
+		s.logger.Info("Refusing to link a ", profile.Provider, " profile to user ", owner.ID, " by unverified email")
+		return nil, false, domains.ErrEmailTaken
+	}
+
+	s.logger.Info("Linking a ", profile.Provider, " profile to user ", owner.ID, " by email")
+	return owner, false, nil
+}
+
+func (s *userService) Register(ctx context.Context, email string, password string, name string) error {
+	email = normalizeEmail(email)
+
+	existing, err := s.repo.GetByEmail(ctx, email)
+	if err != nil && !errors.Is(err, domains.ErrUserNotFound) {
+		return err
+	}
+
+	// Hash even for taken emails so both cases take the same time
+	passwordHash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		s.logger.Info("Registration attempted for an existing email")
+		return nil
+	}
+
+	now := time.Now()
+	user := &domains.User{
+		Email:        email,
+		Name:         name,
+		PasswordHash: passwordHash,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	// A concurrent registration of the same email is answered like a taken one
+	if err := s.repo.Create(ctx, user); err != nil {
+		if errors.Is(err, domains.ErrEmailTaken) {
+			s.logger.Info("Registration attempted for an existing email")
+			return nil
+		}
+		return err
+	}
+
+	s.sendVerification(*user)
+
+	return nil
+}
+
+// sendVerification mails the verification link of a new account in the background,
+// so responses do not wait for the mail server nor reveal whether an account was
+// created. Failures only get logged as the user can request another link.
+func (s *userService) sendVerification(user domains.User) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), verificationSendTimeout)
+		defer cancel()
+
+		if err := s.verification.SendVerification(ctx, &user); err != nil {
+			s.logger.Error("Failed to send verification email: ", err)
+		}
+	}()
+}
+
+func (s *userService) Authenticate(ctx context.Context, email string, password string) (*domains.User, error) {
+	user, err := s.repo.GetByEmail(ctx, normalizeEmail(email))
+	if err != nil && !errors.Is(err, domains.ErrUserNotFound) {
+		return nil, err
+	}
+
+	// Accounts created through OAuth have no password, treat them like unknown emails
+	if user == nil || user.PasswordHash == "" {
+		_, _ = s.hasher.Verify(password, s.getDummyHash())
+		return nil, domains.ErrInvalidCredentials
+	}
+
+	ok, err := s.hasher.Verify(password, user.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, domains.ErrInvalidCredentials
+	}
+
+	// Upgrade hashes created with outdated parameters while the password is at hand
+	if s.hasher.NeedsRehash(user.PasswordHash) {
+		if passwordHash, err := s.hasher.Hash(password); err == nil {
+			user.PasswordHash = passwordHash
+			if err := s.repo.SetPasswordHash(ctx, user.ID, passwordHash); err != nil {
+				s.logger.Error("Failed to rehash password: ", err)
+			}
+		}
+	}
+
+	return user, nil
+}
+
+func (s *userService) getDummyHash() string {
+	s.dummyHashOnce.Do(func() {
+		s.dummyHash, _ = s.hasher.Hash("dummy password")
+	})
+	return s.dummyHash
+}
+
+func (s *userService) SuspendUser(ctx context.Context, userID string) error {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if user.SuspendedAt == nil {
+		if err := s.repo.SetSuspended(ctx, userID, &now); err != nil {
+			return err
+		}
+	}
+
+	// Refresh tokens cannot mint new access tokens, outstanding ones are denylisted
+	if err := s.refreshTokens.RevokeUser(ctx, userID, now); err != nil {
+		return err
+	}
+
+	return s.revocations.RevokeUser(ctx, userID)
+}
+
+func (s *userService) ReinstateUser(ctx context.Context, userID string) error {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if user.SuspendedAt == nil {
+		return nil
+	}
+
+	return s.repo.SetSuspended(ctx, userID, nil)
+}
+
+// normalizeEmail lower cases and trims an email so lookups are case insensitive
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,530 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const webAuthnSessionTTL = 5 * time.Minute
+
+// reauthenticationWindow is how long after signing in a session may add or
+// delete passkeys. Refreshed tokens keep the original sign in time.
+const reauthenticationWindow = 10 * time.Minute
+
+// WebAuthnService runs the passkey registration and authentication ceremonies
+type WebAuthnService interface {
+	// BeginRegistration starts registering a new passkey for the user, auth is
+	// how the session signed in and must be recent
+	BeginRegistration(ctx context.Context, userID string, auth domains.Authentication) (*domains.WebAuthnCeremony, error)
+	// FinishRegistration verifies the attestation and stores the passkey. With
+	// secondFactor the user's passkeys are also required after password sign in.
+	FinishRegistration(ctx context.Context, userID string, auth domains.Authentication, sessionID string, name string, secondFactor bool, response []byte) (*domains.WebAuthnCredential, error)
+
+	// BeginLogin starts a passwordless sign in with a discoverable passkey
+	BeginLogin(ctx context.Context) (*domains.WebAuthnCeremony, error)
+	// FinishLogin verifies the assertion and returns the passkey owner
+	FinishLogin(ctx context.Context, sessionID string, response []byte) (*domains.User, error)
+
+	// BeginSecondFactor starts answering an MFA challenge with a passkey
+	BeginSecondFactor(ctx context.Context, mfaToken string) (*domains.WebAuthnCeremony, error)
+	// FinishSecondFactor verifies the assertion against the challenged user
+	FinishSecondFactor(ctx context.Context, mfaToken string, sessionID string, response []byte) (*domains.User, error)
+
+	ListCredentials(ctx context.Context, userID string) ([]*domains.WebAuthnCredential, error)
+	// DeleteCredential removes a passkey, auth must be recent as removing the
+	// last one also turns off passkeys as second factor
+	DeleteCredential(ctx context.Context, userID string, auth domains.Authentication, credentialID string) error
+}
+
+// WebAuthnCredentialRepository defines the interface for passkey persistence
+type WebAuthnCredentialRepository interface {
+	Save(ctx context.Context, credential *domains.WebAuthnCredential) error
+	ListByUser(ctx context.Context, userID string) ([]*domains.WebAuthnCredential, error)
+	UpdateUsage(ctx context.Context, id string, signCount uint32, backupState bool, usedAt time.Time) error
+	Delete(ctx context.Context, userID string, id string) error
+}
+
+// WebAuthnSessionRepository defines the interface for pending ceremony persistence
+type WebAuthnSessionRepository interface {
+	Save(ctx context.Context, session *domains.WebAuthnSession) error
+	// Consume returns and removes the session, so each ceremony finishes at most once
+	Consume(ctx context.Context, id string) (*domains.WebAuthnSession, error)
+}
+
+// webAuthnService implements WebAuthnService
+type webAuthnService struct {
+	logger      lib.Logger
+	webAuthn    *webauthn.WebAuthn
+	authService domains.AuthService
+	users       UserRepository
+	credentials WebAuthnCredentialRepository
+	sessions    WebAuthnSessionRepository
+}
+
+// webAuthnUser adapts a user and their passkeys to the WebAuthn library. The
+// user id doubles as user handle, discoverable logins resolve the user from it.
+type webAuthnUser struct {
+	user        *domains.User
+	credentials []*domains.WebAuthnCredential
+}
+
+// NewWebAuthnService creates a new WebAuthn service. The relying party defaults
+// to the origin of FRONTEND_URL, without any origin passkeys are disabled.
+func NewWebAuthnService(
+	env lib.Env,
+	logger lib.Logger,
+	authService domains.AuthService,
+	users UserRepository,
+	credentials WebAuthnCredentialRepository,
+	sessions WebAuthnSessionRepository,
+) (WebAuthnService, error) {
+	service := &webAuthnService{
+		logger:      logger,
+		authService: authService,
+		users:       users,
+		credentials: credentials,
+		sessions:    sessions,
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(env.WebAuthnRPOrigins, ",") {
+		if origin, ok := urlOrigin(strings.TrimSpace(origin)); ok {
+			origins = append(origins, origin)
+		}
+	}
+	if origin, ok := urlOrigin(env.FrontendURL); ok && len(origins) == 0 {
+		origins = append(origins, origin)
+	}
+	if len(origins) == 0 {
+		return service, nil
+	}
+
+	rpID := env.WebAuthnRPID
+	if rpID == "" {
+		u, _ := url.Parse(origins[0])
+		rpID = u.Hostname()
+	}
+
+	rpName := env.WebAuthnRPName
+	if rpName == "" {
+		rpName = env.MFAIssuer
+	}
+	if rpName == "" {
+		rpName = defaultMFAIssuer
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure webauthn: %w", err)
+	}
+	service.webAuthn = webAuthn
+
+	return service, nil
+}
+
+func (s *webAuthnService) BeginRegistration(ctx context.Context, userID string, auth domains.Authentication) (*domains.WebAuthnCeremony, error) {
+	if s.webAuthn == nil {
+		return nil, domains.ErrWebAuthnDisabled
+	}
+	if !recentlyAuthenticated(auth) {
+		return nil, domains.ErrReauthenticationRequired
+	}
+
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep authenticators from registering a second passkey for the same account
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	options, session, err := s.webAuthn.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey registration: %w", err)
+	}
+
+	return s.saveSession(ctx, domains.WebAuthnRegistration, userID, session, options)
+}
+
+func (s *webAuthnService) FinishRegistration(ctx context.Context, userID string, auth domains.Authentication, sessionID string, name string, secondFactor bool, response []byte) (*domains.WebAuthnCredential, error) {
+	if s.webAuthn == nil {
+		return nil, domains.ErrWebAuthnDisabled
+	}
+	// Checked again as the ceremony may outlast the window opened at begin
+	if !recentlyAuthenticated(auth) {
+		return nil, domains.ErrReauthenticationRequired
+	}
+
+	session, err := s.consumeSession(ctx, sessionID, domains.WebAuthnRegistration, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		s.logger.Debug("Invalid passkey attestation: ", err)
+		return nil, domains.ErrWebAuthnFailed
+	}
+
+	created, err := s.webAuthn.CreateCredential(user, *session, parsed)
+	if err != nil {
+		s.logger.Debug("Passkey attestation rejected: ", err)
+		return nil, domains.ErrWebAuthnFailed
+	}
+
+	if name == "" {
+		name = "Passkey"
+	}
+
+	transports := make([]string, 0, len(created.Transport))
+	for _, transport := range created.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	credential := &domains.WebAuthnCredential{
+		ID:              base64.RawURLEncoding.EncodeToString(created.ID),
+		UserID:          userID,
+		Name:            name,
+		PublicKey:       created.PublicKey,
+		AttestationType: created.AttestationType,
+		Transports:      transports,
+		AAGUID:          created.Authenticator.AAGUID,
+		SignCount:       created.Authenticator.SignCount,
+		BackupEligible:  created.Flags.BackupEligible,
+		BackupState:     created.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	}
+	if err := s.credentials.Save(ctx, credential); err != nil {
+		return nil, err
+	}
+
+	if secondFactor && !user.user.MFA.WebAuthn {
+		if err := s.users.SetWebAuthnMFA(ctx, user.user.ID, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return credential, nil
+}
+
+func (s *webAuthnService) BeginLogin(ctx context.Context) (*domains.WebAuthnCeremony, error) {
+	if s.webAuthn == nil {
+		return nil, domains.ErrWebAuthnDisabled
+	}
+
+	// A passkey login skips the MFA challenge, so the authenticator must verify
+	// the user besides proving possession
+	options, session, err := s.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey login: %w", err)
+	}
+
+	return s.saveSession(ctx, domains.WebAuthnLogin, "", session, options)
+}
+
+func (s *webAuthnService) FinishLogin(ctx context.Context, sessionID string, response []byte) (*domains.User, error) {
+	if s.webAuthn == nil {
+		return nil, domains.ErrWebAuthnDisabled
+	}
+
+	session, err := s.consumeSession(ctx, sessionID, domains.WebAuthnLogin, "")
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		s.logger.Debug("Invalid passkey assertion: ", err)
+		return nil, domains.ErrWebAuthnFailed
+	}
+
+	// The authenticator names the account through the user handle
+	var user *webAuthnUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		user, err = s.loadUser(ctx, string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	credential, err := s.webAuthn.ValidateDiscoverableLogin(handler, *session, parsed)
+	if err != nil {
+		s.logger.Debug("Passkey assertion rejected: ", err)
+		return nil, domains.ErrWebAuthnFailed
+	}
+	if !credential.Flags.UserVerified {
+		s.logger.Debug("Passkey assertion without user verification")
+		return nil, domains.ErrWebAuthnFailed
+	}
+
+	if err := s.recordUsage(ctx, credential); err != nil {
+		return nil, err
+	}
+
+	return user.user, nil
+}
+
+func (s *webAuthnService) BeginSecondFactor(ctx context.Context, mfaToken string) (*domains.WebAuthnCeremony, error) {
+	if s.webAuthn == nil {
+		return nil, domains.ErrWebAuthnDisabled
+	}
+
+	claims, err := s.authService.VerifyActionToken(domains.MFAChallengePurpose, mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.loadUser(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.credentials) == 0 {
+		return nil, domains.ErrCredentialNotFound
+	}
+
+	options, session, err := s.webAuthn.BeginLogin(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey login: %w", err)
+	}
+
+	return s.saveSession(ctx, domains.WebAuthnSecondFactor, user.user.ID, session, options)
+}
+
+func (s *webAuthnService) FinishSecondFactor(ctx context.Context, mfaToken string, sessionID string, response []byte) (*domains.User, error) {
+	if s.webAuthn == nil {
+		return nil, domains.ErrWebAuthnDisabled
+	}
+
+	claims, err := s.authService.VerifyActionToken(domains.MFAChallengePurpose, mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.consumeSession(ctx, sessionID, domains.WebAuthnSecondFactor, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.loadUser(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		s.logger.Debug("Invalid passkey assertion: ", err)
+		return nil, domains.ErrWebAuthnFailed
+	}
+
+	credential, err := s.webAuthn.ValidateLogin(user, *session, parsed)
+	if err != nil {
+		s.logger.Debug("Passkey assertion rejected: ", err)
+		return nil, domains.ErrWebAuthnFailed
+	}
+
+	if err := s.recordUsage(ctx, credential); err != nil {
+		return nil, err
+	}
+
+	return user.user, nil
+}
+
+func (s *webAuthnService) ListCredentials(ctx context.Context, userID string) ([]*domains.WebAuthnCredential, error) {
+	return s.credentials.ListByUser(ctx, userID)
+}
+
+func (s *webAuthnService) DeleteCredential(ctx context.Context, userID string, auth domains.Authentication, credentialID string) error {
+	if !recentlyAuthenticated(auth) {
+		return domains.ErrReauthenticationRequired
+	}
+
+	if err := s.credentials.Delete(ctx, userID, credentialID); err != nil {
+		return err
+	}
+
+	remaining, err := s.credentials.ListByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		return nil
+	}
+
+	// Without passkeys left they can no longer serve as second factor
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.MFA.WebAuthn {
+		return nil
+	}
+	return s.users.SetWebAuthnMFA(ctx, userID, false)
+}
+
+// recentlyAuthenticated reports whether the session signed in within the
+// reauthentication window. Tokens without auth_time never qualify.
+func recentlyAuthenticated(auth domains.Authentication) bool {
+	return !auth.Time.IsZero() && time.Since(auth.Time) <= reauthenticationWindow
+}
+
+// recordUsage stores the new sign counter, refusing the login when the counter
+// went backwards as the authenticator may have been cloned
+func (s *webAuthnService) recordUsage(ctx context.Context, credential *webauthn.Credential) error {
+	id := base64.RawURLEncoding.EncodeToString(credential.ID)
+
+	if credential.Authenticator.CloneWarning {
+		s.logger.Warn("Passkey sign counter went backwards, possible cloned authenticator: ", id)
+		return domains.ErrWebAuthnFailed
+	}
+
+	return s.credentials.UpdateUsage(ctx, id, credential.Authenticator.SignCount, credential.Flags.BackupState, time.Now())
+}
+
+func (s *webAuthnService) loadUser(ctx context.Context, userID string) (*webAuthnUser, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := s.credentials.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+func (s *webAuthnService) saveSession(ctx context.Context, ceremony string, userID string, session *webauthn.SessionData, options interface{}) (*domains.WebAuthnCeremony, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webauthn session: %w", err)
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate webauthn session id: %w", err)
+	}
+
+	now := time.Now()
+	err = s.sessions.Save(ctx, &domains.WebAuthnSession{
+		ID:        id,
+		Ceremony:  ceremony,
+		UserID:    userID,
+		Data:      data,
+		CreatedAt: now,
+		ExpiresAt: now.Add(webAuthnSessionTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domains.WebAuthnCeremony{
+		SessionID: id,
+		Options:   options,
+	}, nil
+}
+
+// consumeSession redeems a pending ceremony, it must have been started as the
+// given ceremony by the given user
+func (s *webAuthnService) consumeSession(ctx context.Context, sessionID string, ceremony string, userID string) (*webauthn.SessionData, error) {
+	if sessionID == "" {
+		return nil, domains.ErrInvalidWebAuthnSession
+	}
+
+	saved, err := s.sessions.Consume(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if saved.Ceremony != ceremony || saved.UserID != userID {
+		return nil, domains.ErrInvalidWebAuthnSession
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(saved.Data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode webauthn session: %w", err)
+	}
+
+	return &session, nil
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(u.user.ID)
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	if u.user.Email != "" {
+		return u.user.Email
+	}
+	return u.user.ID
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.WebAuthnName()
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, credential := range u.credentials {
+		id, err := base64.RawURLEncoding.DecodeString(credential.ID)
+		if err != nil {
+			continue
+		}
+
+		transports := make([]protocol.AuthenticatorTransport, 0, len(credential.Transports))
+		for _, transport := range credential.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              id,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: credential.BackupEligible,
+				BackupState:    credential.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    credential.AAGUID,
+				SignCount: credential.SignCount,
+			},
+		})
+	}
+	return credentials
+}
@@ -0,0 +1,441 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"diandi-backend/domains"
+	"diandi-backend/lib"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	testRPOrigin = "https://app.example.com"
+	testRPID     = "app.example.com"
+
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// softAuthenticator is an ES256 authenticator holding one discoverable passkey,
+// it answers ceremonies the way a browser would forward them
+type softAuthenticator struct {
+	t          *testing.T
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{t: t, key: key, id: id}
+}
+
+// register answers registration options with a "none" attestation
+func (a *softAuthenticator) register(ceremony *domains.WebAuthnCeremony) []byte {
+	options, ok := ceremony.Options.(*protocol.CredentialCreation)
+	if !ok {
+		a.t.Fatalf("unexpected registration options %T", ceremony.Options)
+	}
+	a.userHandle = userHandle(a.t, options.Response.User.ID)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1,
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	authData := a.authenticatorData(flagUserPresent | flagUserVerified | flagAttestedData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.id)))
+	authData = append(authData, a.id...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.credential(map[string]interface{}{
+		"clientDataJSON":    encode(a.clientData("webauthn.create", options.Response.Challenge.String())),
+		"attestationObject": encode(attestation),
+	})
+}
+
+// assert answers assertion options, signing with the given authenticator flags
+func (a *softAuthenticator) assert(ceremony *domains.WebAuthnCeremony, flags byte) []byte {
+	options, ok := ceremony.Options.(*protocol.CredentialAssertion)
+	if !ok {
+		a.t.Fatalf("unexpected assertion options %T", ceremony.Options)
+	}
+
+	a.signCount++
+	authData := a.authenticatorData(flags)
+	clientData := a.clientData("webauthn.get", options.Response.Challenge.String())
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.credential(map[string]interface{}{
+		"clientDataJSON":    encode(clientData),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge string) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    testRPOrigin,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) credential(response map[string]interface{}) []byte {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":       encode(a.id),
+		"rawId":    encode(a.id),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return body
+}
+
+// userHandle returns the user id the library put in the creation options
+func userHandle(t *testing.T, id interface{}) []byte {
+	switch id := id.(type) {
+	case []byte:
+		return id
+	case protocol.URLEncodedBase64:
+		return id
+	case string:
+		return []byte(id)
+	}
+	t.Fatalf("unexpected user id %T", id)
+	return nil
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+type webAuthnTest struct {
+	service     WebAuthnService
+	authService domains.AuthService
+	users       *fakeUserRepository
+	credentials *fakeWebAuthnCredentialRepository
+	user        *domains.User
+}
+
+func newWebAuthnTest(t *testing.T) *webAuthnTest {
+	logger := testLogger()
+	authService, err := NewAuthService(lib.Env{JWTSecret: "test secret"}, logger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &domains.User{ID: "user-1", Email: "jane@example.com", Name: "Jane"}
+	users := newFakeUserRepository(user)
+	credentials := &fakeWebAuthnCredentialRepository{}
+
+	service, err := NewWebAuthnService(
+		lib.Env{WebAuthnRPOrigins: testRPOrigin},
+		logger,
+		authService,
+		users,
+		credentials,
+		&fakeWebAuthnSessionRepository{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &webAuthnTest{
+		service:     service,
+		authService: authService,
+		users:       users,
+		credentials: credentials,
+		user:        user,
+	}
+}
+
+// recentSignIn is the authentication of a session that just signed in with a password
+func recentSignIn() domains.Authentication {
+	return domains.Authentication{Time: time.Now(), Methods: []string{domains.PasswordMethod}}
+}
+
+// register runs a registration ceremony for the test user
+func (wt *webAuthnTest) register(t *testing.T, authenticator *softAuthenticator, secondFactor bool) *domains.WebAuthnCredential {
+	ctx := context.Background()
+
+	ceremony, err := wt.service.BeginRegistration(ctx, wt.user.ID, recentSignIn())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := wt.service.FinishRegistration(ctx, wt.user.ID, recentSignIn(), ceremony.SessionID, "Laptop", secondFactor, authenticator.register(ceremony))
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+	return credential
+}
+
+// login runs a passwordless login ceremony
+func (wt *webAuthnTest) login(t *testing.T, authenticator *softAuthenticator, flags byte) (*domains.User, error) {
+	ctx := context.Background()
+
+	ceremony, err := wt.service.BeginLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wt.service.FinishLogin(ctx, ceremony.SessionID, authenticator.assert(ceremony, flags))
+}
+
+func (wt *webAuthnTest) mfaToken(t *testing.T) string {
+	claims := &domains.ActionClaims{}
+	claims.Subject = wt.user.ID
+	token, err := wt.authService.CreateActionToken(domains.MFAChallengePurpose, claims, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+
+	credential := wt.register(t, authenticator, false)
+
+	if credential.ID != encode(authenticator.id) || credential.UserID != wt.user.ID || credential.Name != "Laptop" {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+	if string(authenticator.userHandle) != wt.user.ID {
+		t.Fatalf("user handle = %q, want the user id", authenticator.userHandle)
+	}
+
+	stored, _ := wt.credentials.ListByUser(context.Background(), wt.user.ID)
+	if len(stored) != 1 {
+		t.Fatalf("stored %d credentials, want 1", len(stored))
+	}
+
+	user, _ := wt.users.GetByID(context.Background(), wt.user.ID)
+	if user.MFA.WebAuthn {
+		t.Fatal("a passkey registered for login only must not become a second factor")
+	}
+}
+
+func TestWebAuthnRegistrationSessionIsSingleUse(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+	ctx := context.Background()
+
+	ceremony, err := wt.service.BeginRegistration(ctx, wt.user.ID, recentSignIn())
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := authenticator.register(ceremony)
+
+	if _, err := wt.service.FinishRegistration(ctx, "other-user", recentSignIn(), ceremony.SessionID, "", false, response); !errors.Is(err, domains.ErrInvalidWebAuthnSession) {
+		t.Fatalf("finishing another user's ceremony: err = %v, want ErrInvalidWebAuthnSession", err)
+	}
+	if _, err := wt.service.FinishRegistration(ctx, wt.user.ID, recentSignIn(), ceremony.SessionID, "", false, response); !errors.Is(err, domains.ErrInvalidWebAuthnSession) {
+		t.Fatalf("reusing a consumed ceremony: err = %v, want ErrInvalidWebAuthnSession", err)
+	}
+}
+
+func TestWebAuthnDiscoverableLogin(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+	wt.register(t, authenticator, false)
+
+	user, err := wt.login(t, authenticator, flagUserPresent|flagUserVerified)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if user.ID != wt.user.ID {
+		t.Fatalf("signed in as %q, want %q", user.ID, wt.user.ID)
+	}
+
+	stored, _ := wt.credentials.ListByUser(context.Background(), wt.user.ID)
+	if stored[0].SignCount != authenticator.signCount || stored[0].LastUsedAt == nil {
+		t.Fatalf("usage not recorded: %+v", stored[0])
+	}
+}
+
+func TestWebAuthnLoginRequiresUserVerification(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+	wt.register(t, authenticator, false)
+
+	ceremony, err := wt.service.BeginLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := ceremony.Options.(*protocol.CredentialAssertion)
+	if options.Response.UserVerification != protocol.VerificationRequired {
+		t.Fatalf("userVerification = %q, want required", options.Response.UserVerification)
+	}
+
+	if _, err := wt.login(t, authenticator, flagUserPresent); !errors.Is(err, domains.ErrWebAuthnFailed) {
+		t.Fatalf("login without user verification: err = %v, want ErrWebAuthnFailed", err)
+	}
+}
+
+func TestWebAuthnSecondFactor(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+	wt.register(t, authenticator, true)
+	ctx := context.Background()
+
+	user, _ := wt.users.GetByID(ctx, wt.user.ID)
+	if !user.MFA.WebAuthn {
+		t.Fatal("registering a second factor passkey must enable it")
+	}
+
+	mfaToken := wt.mfaToken(t)
+	ceremony, err := wt.service.BeginSecondFactor(ctx, mfaToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After a password the passkey only has to prove possession
+	user, err = wt.service.FinishSecondFactor(ctx, mfaToken, ceremony.SessionID, authenticator.assert(ceremony, flagUserPresent))
+	if err != nil {
+		t.Fatalf("second factor failed: %v", err)
+	}
+	if user.ID != wt.user.ID {
+		t.Fatalf("signed in as %q, want %q", user.ID, wt.user.ID)
+	}
+
+	if _, err := wt.service.BeginSecondFactor(ctx, "not a token"); err == nil {
+		t.Fatal("a second factor ceremony started without an MFA challenge")
+	}
+}
+
+func TestWebAuthnDeletingLastPasskeyDisablesSecondFactor(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+	credential := wt.register(t, authenticator, true)
+	ctx := context.Background()
+
+	if err := wt.service.DeleteCredential(ctx, wt.user.ID, recentSignIn(), credential.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ := wt.users.GetByID(ctx, wt.user.ID)
+	if user.MFA.WebAuthn {
+		t.Fatal("second factor still enabled without passkeys")
+	}
+}
+
+func TestWebAuthnPasskeyChangesRequireRecentSignIn(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+	credential := wt.register(t, authenticator, true)
+	ctx := context.Background()
+
+	stale := domains.Authentication{Time: time.Now().Add(-time.Hour), Methods: []string{domains.PasswordMethod}}
+	for name, auth := range map[string]domains.Authentication{
+		"stale sign in":   stale,
+		"no sign in time": {},
+	} {
+		if _, err := wt.service.BeginRegistration(ctx, wt.user.ID, auth); !errors.Is(err, domains.ErrReauthenticationRequired) {
+			t.Errorf("%s: begin registration err = %v, want ErrReauthenticationRequired", name, err)
+		}
+		if err := wt.service.DeleteCredential(ctx, wt.user.ID, auth, credential.ID); !errors.Is(err, domains.ErrReauthenticationRequired) {
+			t.Errorf("%s: delete err = %v, want ErrReauthenticationRequired", name, err)
+		}
+	}
+
+	// A ceremony begun in time cannot be finished by a session past the window
+	ceremony, err := wt.service.BeginRegistration(ctx, wt.user.ID, recentSignIn())
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := newSoftAuthenticator(t).register(ceremony)
+	if _, err := wt.service.FinishRegistration(ctx, wt.user.ID, stale, ceremony.SessionID, "", false, response); !errors.Is(err, domains.ErrReauthenticationRequired) {
+		t.Fatalf("finish registration err = %v, want ErrReauthenticationRequired", err)
+	}
+
+	stored, _ := wt.credentials.ListByUser(ctx, wt.user.ID)
+	user, _ := wt.users.GetByID(ctx, wt.user.ID)
+	if len(stored) != 1 || !user.MFA.WebAuthn {
+		t.Fatalf("passkeys changed without a recent sign in: %d stored, second factor %v", len(stored), user.MFA.WebAuthn)
+	}
+}
+
+func TestWebAuthnSignCountRegression(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	authenticator := newSoftAuthenticator(t)
+	wt.register(t, authenticator, false)
+
+	authenticator.signCount = 4
+	if _, err := wt.login(t, authenticator, flagUserPresent|flagUserVerified); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	// A clone of the authenticator replays an older counter
+	authenticator.signCount = 2
+	if _, err := wt.login(t, authenticator, flagUserPresent|flagUserVerified); !errors.Is(err, domains.ErrWebAuthnFailed) {
+		t.Fatalf("login with a lower sign count: err = %v, want ErrWebAuthnFailed", err)
+	}
+
+	stored, _ := wt.credentials.ListByUser(context.Background(), wt.user.ID)
+	if stored[0].SignCount != 5 {
+		t.Fatalf("sign count = %d, want it to stay at 5", stored[0].SignCount)
+	}
+}